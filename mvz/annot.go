@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// This file implements annotations.
+
+// formatRect formats a rectangle [x0 y0 x1 y1].
+func formatRect(r [4]Length) string {
+	return fmt.Sprintf("[%.2f %.2f %.2f %.2f]", r[0], r[1], r[2], r[3])
+}
+
+// AddLinkURL makes the area rect of page a link to url.
+// The rectangle is given as [x0 y0 x1 y1] in page coordinates.
+func (p *PDFWriter) AddLinkURL(page PDFID, rect [4]Length, url string) error {
+	return p.addLink(page, rect, url, 0, "")
+}
+
+// AddLinkToPage makes the area rect of srcPage a link to destPage,
+// which must already be written.
+func (p *PDFWriter) AddLinkToPage(srcPage PDFID, rect [4]Length, destPage PDFID) error {
+	if _, err := p.page(destPage); err != nil {
+		return fmt.Errorf("invalid link destination: %s", err)
+	}
+	return p.addLink(srcPage, rect, "", destPage, "")
+}
+
+// AddLinkToName makes the area rect of page a link to the named
+// destination name, which may be added later by AddNamedDestination.
+func (p *PDFWriter) AddLinkToName(page PDFID, rect [4]Length, name string) error {
+	if name == "" {
+		return fmt.Errorf("empty destination name")
+	}
+	return p.addLink(page, rect, "", 0, name)
+}
+
+// addLink writes a link annotation to either url, destPage or
+// the named destination dest.
+func (p *PDFWriter) addLink(page PDFID, rect [4]Length, url string, destPage PDFID, dest string) error {
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	id, _ := p.startObj()
+	p.print("/Type /Annot")
+	p.print("/Subtype /Link")
+	p.printf("/Rect %s", formatRect(rect))
+	p.print("/Border [0 0 0]")
+	if url != "" {
+		p.printf("/A << /S /URI /URI %s >>", p.byteStr(url))
+	} else if dest != "" {
+		p.printf("/Dest %s", p.byteStr(dest))
+	} else {
+		p.printf("/Dest [%d 0 R /Fit]", destPage)
+	}
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	return p.err
+}
+
+// A StampKind is the kind of a rubber stamp annotation, named as
+// in the PDF specification.
+type StampKind string
+
+const (
+	Approved     StampKind = "Approved"
+	NotApproved  StampKind = "NotApproved"
+	Draft        StampKind = "Draft"
+	Final        StampKind = "Final"
+	Confidential StampKind = "Confidential"
+)
+
+// stampStyles are the labels and colors of the stamp kinds.
+var stampStyles = map[StampKind]struct {
+	label string
+	color [3]float64
+}{
+	Approved:     {"APPROVED", [3]float64{0, 0.5, 0}},
+	NotApproved:  {"NOT APPROVED", [3]float64{0.8, 0, 0}},
+	Draft:        {"DRAFT", [3]float64{0, 0.25, 0.75}},
+	Final:        {"FINAL", [3]float64{0, 0.5, 0}},
+	Confidential: {"CONFIDENTIAL", [3]float64{0.8, 0, 0}},
+}
+
+// AddStampAnnotation adds a rubber stamp of the given kind to page,
+// drawn in the area rect given as [x0 y0 x1 y1]: its label in bold
+// capitals, in a rounded frame.
+func (p *PDFWriter) AddStampAnnotation(page PDFID, rect [4]Length, kind StampKind) error {
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	style, ok := stampStyles[kind]
+	if !ok {
+		return fmt.Errorf("unknown stamp kind %q", kind)
+	}
+	w, h := rect[2]-rect[0], rect[3]-rect[1]
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("invalid rectangle %s", formatRect(rect))
+	}
+	f, err := p.StandardFont("Helvetica-Bold")
+	if err != nil {
+		return err
+	}
+	var cs ContentStream
+	border := h / 10
+	c := cs.Canvas()
+	c.SetStrokeColor(style.color[0], style.color[1], style.color[2])
+	c.SetLineWidth(border)
+	c.RoundedRect(border/2, border/2, w-border, h-border, h/5)
+	c.Stroke()
+	// the largest size fitting the label within the frame
+	size := 0.5 * float64(h)
+	if width := f.StringWidth(style.label, 1); width > 0 {
+		size = math.Min(size, float64(w-4*border)/float64(width))
+	}
+	cs.SetTextColor(style.color[0], style.color[1], style.color[2])
+	cs.Text(f, size, (w-f.StringWidth(style.label, size))/2, (h-Length(0.7*size))/2, style.label)
+	ap := p.writeAppearance(w, h, &cs)
+
+	id, _ := p.startObj()
+	p.print("/Type /Annot")
+	p.print("/Subtype /Stamp")
+	p.printf("/Rect %s", formatRect(rect))
+	p.printf("/Name /%s", kind)
+	p.printf("/Contents %s", p.str(style.label))
+	p.print("/F 4") // print
+	p.printf("/AP << /N %d 0 R >>", ap)
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	return p.err
+}
+
+// AddHighlight highlights areas of page, such as the lines of text
+// matching a search, in one annotation. Each area is a rectangle
+// [x0 y0 x1 y1]. Components of color are clamped to [0, 1]; a zero
+// color means yellow.
+func (p *PDFWriter) AddHighlight(page PDFID, quads [][4]Length, color [3]float64) error {
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	if len(quads) == 0 {
+		return fmt.Errorf("highlight without areas")
+	}
+	if color == ([3]float64{}) {
+		color = [3]float64{1, 1, 0}
+	}
+	bounds := quads[0]
+	points := make([]string, 0, 8*len(quads))
+	for _, q := range quads {
+		if q[2] <= q[0] || q[3] <= q[1] {
+			return fmt.Errorf("invalid rectangle %s", formatRect(q))
+		}
+		bounds = [4]Length{
+			Length(math.Min(float64(bounds[0]), float64(q[0]))),
+			Length(math.Min(float64(bounds[1]), float64(q[1]))),
+			Length(math.Max(float64(bounds[2]), float64(q[2]))),
+			Length(math.Max(float64(bounds[3]), float64(q[3]))),
+		}
+		// top left, top right, bottom left, bottom right, as
+		// viewers expect
+		for _, v := range []Length{q[0], q[3], q[2], q[3], q[0], q[1], q[2], q[1]} {
+			points = append(points, fmt.Sprintf("%.2f", v))
+		}
+	}
+	id, _ := p.startObj()
+	p.print("/Type /Annot")
+	p.print("/Subtype /Highlight")
+	p.printf("/Rect %s", formatRect(bounds))
+	p.printf("/QuadPoints [%s]", strings.Join(points, " "))
+	p.printf("/C %s", colorArray(color))
+	p.print("/F 4") // print
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	return p.err
+}
+
+// The size of the note icon of text annotations.
+const noteSize = 20
+
+// AddTextNote adds a note with the given contents and author to
+// page, shown as an icon whose lower left corner is at. The note is
+// dated mtime, unless it is zero.
+func (p *PDFWriter) AddTextNote(page PDFID, at [2]Length, contents, author string, mtime time.Time) error {
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	id, _ := p.startObj()
+	p.print("/Type /Annot")
+	p.print("/Subtype /Text")
+	p.printf("/Rect %s", formatRect([4]Length{at[0], at[1], at[0] + noteSize, at[1] + noteSize}))
+	p.printf("/Contents %s", p.str(contents))
+	if author != "" {
+		p.printf("/T %s", p.str(author))
+	}
+	if !mtime.IsZero() {
+		p.printf("/M %s", p.str(pdfDate(mtime)))
+	}
+	p.print("/Name /Comment")
+	p.print("/F 4") // print
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	return p.err
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLinkURL(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WritePage(21*CM, 29.7*CM, nil)
+	if err := p.AddLinkURL(page, [4]Length{10, 10, 100, 30}, "http://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddLinkURL(page, [4]Length{10, 40, 100, 60}, "http://example.com/(b)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddLinkURL(page+10, [4]Length{}, "http://example.com/"); err == nil {
+		t.Errorf("expected error for a missing page")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	var links []PDFID
+	for _, obj := range objs {
+		if bytes.Contains(obj.dict, []byte("/Subtype /Link")) {
+			links = append(links, obj.id)
+		}
+	}
+	if len(links) != 2 {
+		t.Fatalf("expected 2 link annotations, got %d", len(links))
+	}
+	annots := fmt.Sprintf("/Annots [ %d 0 R %d 0 R ]", links[0], links[1])
+	if pg := objs[page-1]; !bytes.Contains(pg.dict, []byte(annots)) {
+		t.Errorf("missing %s in %s", annots, pg.dict)
+	}
+	for _, s := range []string{
+		"/Rect [10.00 40.00 100.00 60.00]",
+		`/A << /S /URI /URI (http://example.com/\(b\)) >>`,
+	} {
+		if !bytes.Contains(objs[links[1]-1].dict, []byte(s)) {
+			t.Errorf("missing %s in %s", s, objs[links[1]-1].dict)
+		}
+	}
+}
+
+func TestLinkToPage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var pages []PDFID
+	for i := 0; i < 3; i++ {
+		id, _ := p.WritePage(21*CM, 29.7*CM, nil)
+		pages = append(pages, id)
+	}
+	toc := pages[0]
+	if err := p.AddLinkToPage(toc, [4]Length{72, 700, 300, 720}, pages[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddLinkToPage(toc, [4]Length{72, 680, 300, 700}, pages[2]+10); err == nil {
+		t.Errorf("expected error for a missing destination")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	link := findObject(t, buf.Bytes(), "/Subtype /Link")
+	dest := fmt.Sprintf("/Dest [%d 0 R /Fit]", pages[2])
+	if !bytes.Contains(link.dict, []byte(dest)) {
+		t.Errorf("missing %s in %s", dest, link.dict)
+	}
+	annots := fmt.Sprintf("/Annots [ %d 0 R ]", link.id)
+	if pg := readObjects(t, buf.Bytes())[toc-1]; !bytes.Contains(pg.dict, []byte(annots)) {
+		t.Errorf("missing %s in %s", annots, pg.dict)
+	}
+}
+
+func TestStampAnnotation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	if err := p.AddStampAnnotation(page, [4]Length{100, 100, 300, 160}, Approved); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddStampAnnotation(page, [4]Length{100, 100, 300, 160}, "Rejected"); err == nil {
+		t.Error("expected error for unknown stamp kind")
+	}
+	if err := p.AddStampAnnotation(page, [4]Length{100, 100, 100, 160}, Draft); err == nil {
+		t.Error("expected error for empty rectangle")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stamp := findObject(t, buf.Bytes(), "/Subtype /Stamp")
+	for _, want := range []string{"/Rect [100.00 100.00 300.00 160.00]", "/Name /Approved", "/Contents (APPROVED)"} {
+		if !bytes.Contains(stamp.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, stamp.dict)
+		}
+	}
+	var ap PDFID
+	if i := bytes.Index(stamp.dict, []byte("/AP << /N ")); i < 0 {
+		t.Fatalf("no appearance in %s", stamp.dict)
+	} else {
+		fmt.Sscanf(string(stamp.dict[i:]), "/AP << /N %d 0 R >>", &ap)
+	}
+	objs := readObjects(t, buf.Bytes())
+	form := objs[ap-1]
+	font := findObject(t, buf.Bytes(), "/BaseFont /Helvetica-Bold")
+	for _, want := range []string{"/Subtype /Form", "/BBox [0 0 200.00 60.00]", fmt.Sprintf("/Font << /F1 %d 0 R >>", font.id)} {
+		if !bytes.Contains(form.dict, []byte(want)) {
+			t.Errorf("missing %s in appearance %s", want, form.dict)
+		}
+	}
+	// APPROVED is 5.612 em wide: it fits at half the height
+	for _, want := range []string{"0 0.5 0 RG", "6.00 w", "/F1 30.00 Tf", "15.82 19.50 Td\n(APPROVED) Tj"} {
+		if !bytes.Contains(form.stream, []byte(want)) {
+			t.Errorf("missing %s in appearance stream %q", want, form.stream)
+		}
+	}
+	annots := fmt.Sprintf("/Annots [ %d 0 R ]", stamp.id)
+	if pg := objs[page-1]; !bytes.Contains(pg.dict, []byte(annots)) {
+		t.Errorf("missing %s in %s", annots, pg.dict)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	// a match wrapping from the end of a line to the next one
+	lines := [][4]Length{{300, 700, 520, 714}, {72, 686, 150, 700}}
+	if err := p.AddHighlight(page, lines, [3]float64{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddHighlight(page, lines[:1], [3]float64{0, 2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddHighlight(page, nil, [3]float64{}); err == nil {
+		t.Error("expected error for highlight without areas")
+	}
+	if err := p.AddHighlight(page, [][4]Length{{72, 700, 72, 714}}, [3]float64{}); err == nil {
+		t.Error("expected error for empty area")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	hl := findObject(t, buf.Bytes(), "/Subtype /Highlight")
+	i := bytes.Index(hl.dict, []byte("/QuadPoints ["))
+	if i < 0 {
+		t.Fatalf("no quad points in %s", hl.dict)
+	}
+	points := bytes.Fields(hl.dict[i+len("/QuadPoints [") : i+bytes.IndexByte(hl.dict[i:], ']')])
+	if len(points) != 16 {
+		t.Errorf("got %d quad point coordinates, want 16", len(points))
+	}
+	for _, want := range []string{
+		"/QuadPoints [300.00 714.00 520.00 714.00 300.00 700.00 520.00 700.00 72.00 700.00 150.00 700.00 72.00 686.00 150.00 686.00]",
+		"/Rect [72.00 686.00 520.00 714.00]",
+		"/C [ 1 1 0 ]",
+	} {
+		if !bytes.Contains(hl.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, hl.dict)
+		}
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("/C [ 0 1 1 ]")) {
+		t.Error("color of second highlight not clamped")
+	}
+}
+
+func TestTextNote(t *testing.T) {
+	mtime := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	if err := p.AddTextNote(page, [2]Length{100, 700}, `Typo (twice): "teh" \ "adn"`, "O'Brien (reviewer)", mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTextNote(page+10, [2]Length{100, 700}, "", "", mtime); err == nil {
+		t.Error("expected error for a missing page")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	note := findObject(t, buf.Bytes(), "/Subtype /Text")
+	for _, want := range []string{
+		"/Rect [100.00 700.00 120.00 720.00]",
+		`/Contents (Typo \(twice\): "teh" \\ "adn")`,
+		`/T (O'Brien \(reviewer\))`,
+		"/M (D:20120304050607Z)",
+	} {
+		if !bytes.Contains(note.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, note.dict)
+		}
+	}
+
+	// the date is kept, and the output reproducible, in
+	// deterministic mode
+	write := func() []byte {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.SetDeterministic()
+		page, _ := p.WriteBlankPage(A4)
+		p.AddTextNote(page, [2]Length{100, 700}, "note", "", mtime)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	out := write()
+	if !bytes.Equal(out, write()) {
+		t.Error("notes with the same date differ")
+	}
+	if note := findObject(t, out, "/Subtype /Text"); !bytes.Contains(note.dict, []byte("/M (D:20120304050607Z)")) || bytes.Contains(note.dict, []byte("/T ")) {
+		t.Errorf("wrong date or unexpected author in %s", note.dict)
+	}
+
+	// a zero date is omitted
+	buf.Reset()
+	p, _ = NewPDFWriter(buf)
+	page, _ = p.WriteBlankPage(A4)
+	p.AddTextNote(page, [2]Length{100, 700}, "note", "", time.Time{})
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if note := findObject(t, buf.Bytes(), "/Subtype /Text"); bytes.Contains(note.dict, []byte("/M ")) {
+		t.Errorf("unexpected date in %s", note.dict)
+	}
+}
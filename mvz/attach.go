@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file implements embedded file attachments.
+
+// An attachment is a file embedded in the document.
+type attachment struct {
+	name string
+	spec PDFID // file specification
+}
+
+// AttachFile embeds a file in the document, such as the data an
+// invoice was generated from, under the given name. The MIME type,
+// if not empty, is declared as its subtype. Viewers list the
+// attachments by name.
+func (p *PDFWriter) AttachFile(name, mime string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name == "" {
+		return fmt.Errorf("attachment without a name")
+	}
+	for _, a := range p.attachments {
+		if a.name == name {
+			return fmt.Errorf("duplicate attachment %s", name)
+		}
+	}
+	file, _ := p.startObj()
+	p.print("/Type /EmbeddedFile")
+	if mime != "" {
+		p.printf("/Subtype %s", pdfName(mime))
+	}
+	p.printf("/Params << /Size %d >>", len(data))
+	p.writeStream(p.encodeStream(data, p.contentFilters()...))
+
+	spec, _ := p.startObj()
+	p.print("/Type /Filespec")
+	p.printf("/F %s", p.byteStr(name))
+	p.printf("/UF %s", p.str(name))
+	p.printf("/EF << /F %d 0 R >>", file)
+	p.endObj()
+	p.attachments = append(p.attachments, attachment{name, spec})
+	return p.err
+}
+
+// pdfName returns s as a name object, escaping the delimiters and
+// characters outside the printable ASCII range.
+func pdfName(s string) string {
+	var b strings.Builder
+	b.WriteByte('/')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || strings.IndexByte("#()<>[]{}/%", c) >= 0 {
+			fmt.Fprintf(&b, "#%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestAttachFile(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteBlankPage(A4)
+	files := []struct{ name, mime, data string }{
+		{"invoice.xml", "text/xml", "<invoice><total>42</total></invoice>"},
+		{"items.csv", "", "item,price\nwidget,42\n"},
+	}
+	// attached out of order, listed sorted by name
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+		if err := p.AttachFile(f.name, f.mime, []byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.AttachFile("items.csv", "", nil); err == nil {
+		t.Error("expected error for duplicate attachment")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	m := regexp.MustCompile(`/Names << /EmbeddedFiles << /Names \[ \((.*?)\) (\d+) 0 R \((.*?)\) (\d+) 0 R \] >> >>`).FindSubmatch(objs[CATALOG_ID-1].dict)
+	if m == nil {
+		t.Fatalf("no embedded files name tree in %s", objs[CATALOG_ID-1].dict)
+	}
+	for i, f := range files {
+		if name := string(m[1+2*i]); name != f.name {
+			t.Errorf("entry %d: got name %s, want %s", i, name, f.name)
+		}
+		id, _ := strconv.Atoi(string(m[2+2*i]))
+		spec := objs[id-1]
+		if !bytes.Contains(spec.dict, []byte("/Type /Filespec")) ||
+			!bytes.Contains(spec.dict, []byte(fmt.Sprintf("/UF (%s)", f.name))) {
+			t.Errorf("%s: wrong file specification %s", f.name, spec.dict)
+		}
+		ef := regexp.MustCompile(`/EF << /F (\d+) 0 R >>`).FindSubmatch(spec.dict)
+		if ef == nil {
+			t.Fatalf("%s: no embedded file in %s", f.name, spec.dict)
+		}
+		id, _ = strconv.Atoi(string(ef[1]))
+		file := objs[id-1]
+		if want := fmt.Sprintf("/Params << /Size %d >>", len(f.data)); !bytes.Contains(file.dict, []byte(want)) {
+			t.Errorf("%s: missing %s in %s", f.name, want, file.dict)
+		}
+		if string(file.stream) != f.data {
+			t.Errorf("%s: got contents %q, want %q", f.name, file.stream, f.data)
+		}
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("/Subtype /text#2Fxml")) {
+		t.Error("missing MIME type of invoice.xml")
+	}
+}
+
+func TestPDFName(t *testing.T) {
+	for in, want := range map[string]string{
+		"text/csv": "/text#2Fcsv",
+		"a b(c)":   "/a#20b#28c#29",
+		"café#1":   "/caf#C3#A9#231",
+	} {
+		if got := pdfName(in); got != want {
+			t.Errorf("pdfName(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
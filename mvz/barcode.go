@@ -0,0 +1,156 @@
+package main
+
+import "fmt"
+
+// This file implements Code 128 barcodes.
+
+// The bar and space widths, in modules, of the Code 128 symbols,
+// by value. The last one is the stop pattern, with its final bar.
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// Special Code 128 symbols.
+const (
+	code128C      = 99
+	code128B      = 100
+	code128A      = 101
+	code128StartA = 103
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// DefaultBarcodeModule is the default width of the narrowest bar of
+// barcodes: 0.35 mm.
+const DefaultBarcodeModule = INCH / 72
+
+// SetBarcodeModule sets the width of the narrowest bar of the
+// barcodes drawn by DrawBarcode128. Zero restores the default.
+func (p *PDFWriter) SetBarcodeModule(w Length) {
+	p.barcodeModule = w
+}
+
+// DrawBarcode128 draws value as a Code 128 barcode of the given
+// height on page, starting at (x, y). Scanners need a light quiet
+// zone of 10 modules on either side. Characters beyond ASCII cannot
+// be encoded.
+func (p *PDFWriter) DrawBarcode128(page PDFID, x, y, height Length, value string) error {
+	symbols, err := code128(value)
+	if err != nil {
+		return err
+	}
+	c, err := p.Canvas(page)
+	if err != nil {
+		return err
+	}
+	m := p.barcodeModule
+	if m <= 0 {
+		m = DefaultBarcodeModule
+	}
+	c.SetFillColor(0, 0, 0)
+	for _, s := range symbols {
+		for i, w := range code128Patterns[s] {
+			width := Length(w-'0') * m
+			if i%2 == 0 {
+				c.Rect(x, y, width, height)
+			}
+			x += width
+		}
+	}
+	c.Fill()
+	return p.err
+}
+
+// code128 returns the symbols encoding value, from the start symbol
+// to the stop one, switching to code set C for runs of digits, and
+// between code sets A and B for control characters and lower case.
+func code128(value string) ([]int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("empty barcode")
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return nil, fmt.Errorf("cannot encode %q in Code 128", value[i])
+		}
+	}
+	// digits reports the length of the run of digits at i
+	digits := func(i int) int {
+		n := 0
+		for i+n < len(value) && value[i+n] >= '0' && value[i+n] <= '9' {
+			n++
+		}
+		return n
+	}
+	var symbols []int
+	set := 0 // code set, 'A', 'B' or 'C'
+	switchTo := func(s int) {
+		start := map[int]int{'A': code128StartA, 'B': code128StartB, 'C': code128StartC}
+		code := map[int]int{'A': code128A, 'B': code128B, 'C': code128C}
+		if set == 0 {
+			symbols = append(symbols, start[s])
+		} else {
+			symbols = append(symbols, code[s])
+		}
+		set = s
+	}
+	for i := 0; i < len(value); {
+		// code set C is worth it for 4 digits at the boundaries,
+		// 6 inside the value, or a value of 2 digits
+		n := digits(i)
+		if set != 'C' && (n >= 4 && (i == 0 || i+n == len(value) || n >= 6) || n == 2 && n == len(value)) {
+			if n%2 == 1 {
+				// the odd digit goes in the current code set
+				if set == 0 {
+					switchTo('B')
+				}
+				symbols = append(symbols, code128Value(set, value[i]))
+				i++
+			}
+			switchTo('C')
+		}
+		if set == 'C' {
+			if digits(i) >= 2 {
+				symbols = append(symbols, int(value[i]-'0')*10+int(value[i+1]-'0'))
+				i += 2
+				continue
+			}
+			set = -1 // leave code set C
+		}
+		c := value[i]
+		switch {
+		case c < 32 && set != 'A':
+			switchTo('A')
+		case c >= 96 && set != 'B':
+			switchTo('B')
+		case set != 'A' && set != 'B':
+			switchTo('B')
+		}
+		symbols = append(symbols, code128Value(set, c))
+		i++
+	}
+	check := symbols[0]
+	for i, s := range symbols[1:] {
+		check += (i + 1) * s
+	}
+	return append(symbols, check%103, code128Stop), nil
+}
+
+// code128Value returns the value of ASCII character c in code set A
+// or B.
+func code128Value(set int, c byte) int {
+	if set == 'A' && c < 32 {
+		return int(c) + 64
+	}
+	return int(c) - 32
+}
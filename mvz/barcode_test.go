@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCode128(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		symbols []int
+	}{
+		// Start B, A B C 1 2 3, checksum 582 % 103, stop
+		{"ABC123", []int{104, 33, 34, 35, 17, 18, 19, 67, 106}},
+		// Start C, 12 34 56
+		{"123456", []int{105, 12, 34, 56, (105 + 12 + 2*34 + 3*56) % 103, 106}},
+		{"42", []int{105, 42, (105 + 42) % 103, 106}},
+		// the odd digit in code set B, then C
+		{"12345", []int{104, 17, 99, 23, 45, (104 + 17 + 2*99 + 3*23 + 4*45) % 103, 106}},
+		// C for the trailing digits, back to B
+		{"AB1234", []int{104, 33, 34, 99, 12, 34, (104 + 33 + 2*34 + 3*99 + 4*12 + 5*34) % 103, 106}},
+		{"1234a", []int{105, 12, 34, 100, 65, (105 + 12 + 2*34 + 3*100 + 4*65) % 103, 106}},
+		// a control character in code set A
+		{"A\tb", []int{104, 33, 101, 73, 100, 66, (104 + 33 + 2*101 + 3*73 + 4*100 + 5*66) % 103, 106}},
+	} {
+		got, err := code128(tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.symbols) {
+			t.Errorf("code128(%q) = %v, want %v", tc.value, got, tc.symbols)
+		}
+	}
+	for _, bad := range []string{"", "café"} {
+		if _, err := code128(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestDrawBarcode128(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	p.SetBarcodeModule(0.5)
+	if err := p.DrawBarcode128(page, 100, 200, 30, "ABC123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	// read the bars back as modules
+	var modules []byte
+	end := 100.0
+	for _, line := range strings.Split(content, "\n") {
+		var x, y, w, h float64
+		if n, _ := fmt.Sscanf(line, "%f %f %f %f re", &x, &y, &w, &h); n != 4 {
+			continue
+		}
+		if y != 200 || h != 30 {
+			t.Fatalf("wrong bar %q", line)
+		}
+		modules = append(modules, bytes.Repeat([]byte{'0'}, int((x-end)/0.5+0.5))...)
+		modules = append(modules, bytes.Repeat([]byte{'1'}, int(w/0.5+0.5))...)
+		end = x + w
+	}
+	// 9 symbols of 11 modules, and the final bar of the stop pattern
+	if len(modules) != 9*11+2 {
+		t.Fatalf("got %d modules, want %d", len(modules), 9*11+2)
+	}
+	for _, tc := range []struct {
+		name    string
+		at      int
+		pattern string
+	}{
+		{"start B", 0, "11010010000"},   // 211214
+		{"checksum", 77, "10000101100"}, // 141122, value 67
+		{"stop", 88, "1100011101011"},   // 2331112
+	} {
+		if got := string(modules[tc.at : tc.at+len(tc.pattern)]); got != tc.pattern {
+			t.Errorf("%s: got modules %s, want %s", tc.name, got, tc.pattern)
+		}
+	}
+}
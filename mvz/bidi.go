@@ -0,0 +1,102 @@
+package main
+
+import "unicode"
+
+// This file implements the reordering of right-to-left text,
+// such as Hebrew and Arabic, for display.
+
+// A TextDirection is the direction of a paragraph of text.
+type TextDirection int
+
+const (
+	LeftToRight TextDirection = iota
+	RightToLeft
+)
+
+// isRTL reports whether r is a strongly right-to-left character,
+// from the Hebrew, Arabic, Syriac and Thaana blocks and their
+// presentation forms.
+func isRTL(r rune) bool {
+	return r >= 0x0590 && r <= 0x08ff ||
+		r >= 0xfb1d && r <= 0xfdff ||
+		r >= 0xfe70 && r <= 0xfeff
+}
+
+// bidiMirror maps brackets to their mirror images, which
+// right-to-left text displays in place of the originals.
+var bidiMirror = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'<': '>', '>': '<',
+	'«': '»', '»': '«',
+}
+
+// visualOrder returns text in the order its characters are drawn,
+// from left to right, for a paragraph of direction dir. It handles
+// the simple cases of the Unicode bidirectional algorithm: runs of
+// letters and digits of either direction, separated by neutral
+// characters, which take the direction of the surrounding runs if
+// they agree and that of the paragraph otherwise. Embeddings and
+// explicit direction marks are not supported.
+func visualOrder(text string, dir TextDirection) string {
+	if dir != RightToLeft {
+		return text
+	}
+	runes := []rune(text)
+	// levels: 1 for right-to-left characters, 2 for left-to-right
+	// ones embedded in the right-to-left paragraph
+	levels := make([]int, len(runes))
+	for i, r := range runes {
+		switch {
+		case isRTL(r):
+			levels[i] = 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			levels[i] = 2
+		}
+	}
+	for i := 0; i < len(runes); {
+		if levels[i] != 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && levels[j] == 0 {
+			j++
+		}
+		level := 1
+		if i > 0 && j < len(runes) && levels[i-1] == 2 && levels[j] == 2 {
+			level = 2
+		}
+		for ; i < j; i++ {
+			levels[i] = level
+		}
+	}
+	reverseRunes(runes)
+	for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+		levels[i], levels[j] = levels[j], levels[i]
+	}
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && levels[j] == levels[i] {
+			j++
+		}
+		if levels[i] == 2 {
+			reverseRunes(runes[i:j])
+		} else {
+			for k := i; k < j; k++ {
+				if m, ok := bidiMirror[runes[k]]; ok {
+					runes[k] = m
+				}
+			}
+		}
+		i = j
+	}
+	return string(runes)
+}
+
+func reverseRunes(r []rune) {
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVisualOrder(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		dir  TextDirection
+		want string
+	}{
+		{"abc def", LeftToRight, "abc def"},
+		{"abc def", RightToLeft, "abc def"},
+		{"שלום", LeftToRight, "שלום"},
+		{"שלום", RightToLeft, "םולש"},
+		{"שלום עולם", RightToLeft, "םלוע םולש"},
+		// Latin words and numbers keep their order
+		{"abc שלום 123", RightToLeft, "123 םולש abc"},
+		{"שלום abc def!", RightToLeft, "!abc def םולש"},
+		{"(שלום)", RightToLeft, "(םולש)"},
+		{"", RightToLeft, ""},
+	} {
+		if got := visualOrder(tc.text, tc.dir); got != tc.want {
+			t.Errorf("visualOrder(%q, %d) = %q, want %q", tc.text, tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestRightToLeftText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ttf")
+	// glyphs are numbered in rune order: space ו ל ם ש
+	data := buildTTF("TestHebrew", map[rune]int{'ש': 1200, 'ל': 1000, 'ו': 500, 'ם': 1100, ' ': 512})
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	f, err := p.EmbedTTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFont(f)
+	p.SetTextDirection(RightToLeft)
+	if err := p.DrawText(page, 500, 700, 10, "שלום"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.DrawTextBox(page, [4]Length{100, 100, 500, 200}, 10, "שלום"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	// ם ו ל ש, the last letter drawn first
+	if want := "<0004000200030005> Tj"; strings.Count(content, want) != 2 {
+		t.Errorf("content stream missing %q twice:\n%s", want, content)
+	}
+	width := f.StringWidth("שלום", 10)
+	for _, want := range []string{
+		fmt.Sprintf("%.2f 700.00 Td\n", 500-width),
+		fmt.Sprintf("%.2f 190.00 Td\n", 500-width),
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content stream missing %q:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// This file implements vector drawing.
+
+// A Canvas draws paths on a page. Paths are built with MoveTo,
+// LineTo and Rect, then painted with Stroke or Fill. Each painted
+// path is drawn in its own graphics state, so drawing does not
+// affect other content of the page.
+type Canvas struct {
+	cs    *ContentStream
+	path  bytes.Buffer // current path
+	open  bool         // whether the path has a current point
+	saved int          // graphics states saved by Save
+
+	// graphics state operators; empty means the default
+	lineWidth, dash, stroke, fill, gstate string
+}
+
+// Canvas returns a canvas for drawing on page.
+func (p *PDFWriter) Canvas(page PDFID) (*Canvas, error) {
+	pg, err := p.page(page)
+	if err != nil {
+		return nil, err
+	}
+	return pg.extra.Canvas(), nil
+}
+
+// MoveTo starts a new subpath at (x, y).
+func (c *Canvas) MoveTo(x, y Length) {
+	fmt.Fprintf(&c.path, "%.2f %.2f m\n", x, y)
+	c.open = true
+}
+
+// LineTo appends a straight line from the current point to (x, y).
+func (c *Canvas) LineTo(x, y Length) {
+	fmt.Fprintf(&c.path, "%.2f %.2f l\n", x, y)
+}
+
+// Rect appends a rectangle with lower left corner (x, y) as a
+// complete subpath.
+func (c *Canvas) Rect(x, y, w, h Length) {
+	fmt.Fprintf(&c.path, "%.2f %.2f %.2f %.2f re\n", x, y, w, h)
+	c.open = true
+}
+
+// CurveTo appends a cubic Bézier curve from the current point to
+// (x3, y3), with control points (x1, y1) and (x2, y2).
+func (c *Canvas) CurveTo(x1, y1, x2, y2, x3, y3 Length) error {
+	return c.curve("c", x1, y1, x2, y2, x3, y3)
+}
+
+// CurveToV appends a cubic Bézier curve from the current point to
+// (x3, y3), whose first control point is the current point and
+// second one (x2, y2).
+func (c *Canvas) CurveToV(x2, y2, x3, y3 Length) error {
+	return c.curve("v", x2, y2, x3, y3)
+}
+
+// CurveToY appends a cubic Bézier curve from the current point to
+// (x3, y3), whose first control point is (x1, y1) and second one
+// the end point.
+func (c *Canvas) CurveToY(x1, y1, x3, y3 Length) error {
+	return c.curve("y", x1, y1, x3, y3)
+}
+
+// curve appends the curve operator op with the given coordinates.
+func (c *Canvas) curve(op string, coords ...Length) error {
+	if !c.open {
+		return fmt.Errorf("curve without a current point")
+	}
+	for _, v := range coords {
+		fmt.Fprintf(&c.path, "%.2f ", v)
+	}
+	c.path.WriteString(op + "\n")
+	return nil
+}
+
+// kappa is the distance of the control points of a cubic Bézier
+// curve approximating a quarter circle of radius 1 from its ends.
+const kappa = 0.5523
+
+// Ellipse appends an ellipse centered on (cx, cy), with horizontal
+// radius rx and vertical radius ry, as a complete subpath of four
+// Bézier curves.
+func (c *Canvas) Ellipse(cx, cy, rx, ry Length) {
+	kx, ky := rx*kappa, ry*kappa
+	c.MoveTo(cx+rx, cy)
+	c.CurveTo(cx+rx, cy+ky, cx+kx, cy+ry, cx, cy+ry)
+	c.CurveTo(cx-kx, cy+ry, cx-rx, cy+ky, cx-rx, cy)
+	c.CurveTo(cx-rx, cy-ky, cx-kx, cy-ry, cx, cy-ry)
+	c.CurveTo(cx+kx, cy-ry, cx+rx, cy-ky, cx+rx, cy)
+	c.path.WriteString("h\n")
+}
+
+// Circle appends a circle centered on (cx, cy) with radius r,
+// as a complete subpath.
+func (c *Canvas) Circle(cx, cy, r Length) {
+	c.Ellipse(cx, cy, r, r)
+}
+
+// RoundedRect appends a rectangle with lower left corner (x, y)
+// and corners rounded to quarter circles of the given radius, as
+// a complete subpath. The radius is reduced to half the smaller
+// side if larger, making the short sides semicircles.
+func (c *Canvas) RoundedRect(x, y, w, h, radius Length) error {
+	if w < 0 || h < 0 {
+		return fmt.Errorf("invalid rectangle size %.2fx%.2f", w, h)
+	}
+	if radius < 0 {
+		return fmt.Errorf("invalid corner radius %.2f", radius)
+	}
+	if radius > w/2 {
+		radius = w / 2
+	}
+	if radius > h/2 {
+		radius = h / 2
+	}
+	r, k := radius, radius*kappa
+	// sides are skipped when the corners meet
+	c.MoveTo(x+r, y)
+	if w > 2*r {
+		c.LineTo(x+w-r, y)
+	}
+	c.CurveTo(x+w-r+k, y, x+w, y+r-k, x+w, y+r)
+	if h > 2*r {
+		c.LineTo(x+w, y+h-r)
+	}
+	c.CurveTo(x+w, y+h-r+k, x+w-r+k, y+h, x+w-r, y+h)
+	if w > 2*r {
+		c.LineTo(x+r, y+h)
+	}
+	c.CurveTo(x+r-k, y+h, x, y+h-r+k, x, y+h-r)
+	if h > 2*r {
+		c.LineTo(x, y+r)
+	}
+	c.CurveTo(x, y+r-k, x+r-k, y, x+r, y)
+	c.path.WriteString("h\n")
+	return nil
+}
+
+// Stroke strokes the current path and starts a new one.
+func (c *Canvas) Stroke() {
+	c.paint("S")
+}
+
+// Fill fills the current path, using the nonzero winding rule,
+// and starts a new one.
+func (c *Canvas) Fill() {
+	c.paint("f")
+}
+
+// Clip intersects the clipping region with the current path, using
+// the nonzero winding rule, and starts a new path. The clip applies
+// to everything drawn afterwards in the stream, until the enclosing
+// graphics state is restored: wrap it and the clipped drawing in
+// Save and Restore.
+func (c *Canvas) Clip() {
+	c.clip("W")
+}
+
+// ClipEvenOdd is like Clip, using the even-odd rule.
+func (c *Canvas) ClipEvenOdd() {
+	c.clip("W*")
+}
+
+func (c *Canvas) clip(op string) {
+	if c.path.Len() == 0 {
+		return
+	}
+	c.cs.buf.Write(c.path.Bytes())
+	c.cs.buf.WriteString(op + " n\n")
+	c.path.Reset()
+	c.open = false
+}
+
+// Shade paints a shading registered with RegisterLinearGradient
+// over the clipping region, which bounds the painted area: usually
+// the shading should be clipped first, within Save and Restore.
+func (c *Canvas) Shade(ref ShadingRef) {
+	fmt.Fprintf(&c.cs.buf, "/%s sh\n", c.cs.resourceName("Shading", "Sh", ref.id))
+}
+
+// MaxInlineImage is the largest size, in bytes of samples, of
+// images drawn by DrawInlineImage.
+const MaxInlineImage = 4096
+
+// DrawInlineImage draws img into the rectangle with lower left
+// corner (x, y), width w and height h, with its samples in the
+// content stream rather than in an image XObject. This saves an
+// object for small images, such as icons; larger ones should be
+// registered with RegisterImage instead. Transparency is ignored.
+func (c *Canvas) DrawInlineImage(x, y, w, h Length, img image.Image) error {
+	cs, pix, err := rawSamples(img)
+	if err != nil {
+		return err
+	}
+	if len(pix) > MaxInlineImage {
+		return fmt.Errorf("%d bytes of samples are too many for an inline image", len(pix))
+	}
+	if len(pix) == 0 {
+		return fmt.Errorf("empty image")
+	}
+	// inline images use abbreviated color space names
+	cs = strings.NewReplacer("/Indexed", "/I", "/DeviceRGB", "/RGB", "/DeviceGray", "/G").Replace(cs)
+	fmt.Fprintf(&c.cs.buf, "q\n%.2f 0 0 %.2f %.2f %.2f cm\nBI\n/W %d\n/H %d\n/CS %s\n/BPC 8\n/F /AHx\nID\n%s>\nEI\nQ\n",
+		w, h, x, y, img.Bounds().Dx(), img.Bounds().Dy(), cs, hex.EncodeToString(pix))
+	return nil
+}
+
+// Save saves the graphics state, including the clipping region,
+// to be restored by Restore.
+func (c *Canvas) Save() {
+	c.cs.buf.WriteString("q\n")
+	c.saved++
+}
+
+// Restore restores the graphics state saved by the matching Save.
+func (c *Canvas) Restore() error {
+	if c.saved == 0 {
+		return fmt.Errorf("restore without save")
+	}
+	c.cs.buf.WriteString("Q\n")
+	c.saved--
+	return nil
+}
+
+// paint appends the current path to the page, painted with op.
+func (c *Canvas) paint(op string) {
+	if c.path.Len() == 0 {
+		return
+	}
+	c.cs.buf.WriteString("q\n")
+	for _, state := range []string{c.gstate, c.lineWidth, c.dash, c.stroke, c.fill} {
+		if state != "" {
+			c.cs.buf.WriteString(state + "\n")
+		}
+	}
+	c.cs.buf.Write(c.path.Bytes())
+	c.cs.buf.WriteString(op + "\nQ\n")
+	c.path.Reset()
+	c.open = false
+}
+
+// SetStrokeColor sets the RGB color used by Stroke. Components are
+// clamped to [0, 1]. The default is black.
+func (c *Canvas) SetStrokeColor(r, g, b float64) {
+	c.stroke = colorOp("RG", r, g, b)
+}
+
+// SetFillColor sets the RGB color used by Fill. Components are
+// clamped to [0, 1]. The default is black.
+func (c *Canvas) SetFillColor(r, g, b float64) {
+	c.fill = colorOp("rg", r, g, b)
+}
+
+// SetStrokeCMYK sets the CMYK color used by Stroke.
+func (c *Canvas) SetStrokeCMYK(cyan, magenta, yellow, black float64) {
+	c.stroke = colorOp("K", cyan, magenta, yellow, black)
+}
+
+// SetFillCMYK sets the CMYK color used by Fill.
+func (c *Canvas) SetFillCMYK(cyan, magenta, yellow, black float64) {
+	c.fill = colorOp("k", cyan, magenta, yellow, black)
+}
+
+// SetLineWidth sets the width of stroked lines. The default is 1 point.
+func (c *Canvas) SetLineWidth(w Length) error {
+	if w < 0 {
+		return fmt.Errorf("invalid line width %.2f", w)
+	}
+	c.lineWidth = fmt.Sprintf("%.2f w", w)
+	return nil
+}
+
+// SetDash sets the dash pattern of stroked lines: the lengths of
+// alternating dashes and gaps, starting phase into the pattern.
+// An empty pattern gives a solid line, the default.
+func (c *Canvas) SetDash(pattern []Length, phase Length) error {
+	if len(pattern) == 0 {
+		c.dash = ""
+		return nil
+	}
+	var total Length
+	s := "["
+	for _, l := range pattern {
+		if l < 0 {
+			return fmt.Errorf("invalid dash length %.2f", l)
+		}
+		total += l
+		s += fmt.Sprintf(" %.2f", l)
+	}
+	if total == 0 {
+		return fmt.Errorf("dash pattern has zero length")
+	}
+	if phase < 0 {
+		return fmt.Errorf("invalid dash phase %.2f", phase)
+	}
+	c.dash = fmt.Sprintf("%s ] %.2f d", s, phase)
+	return nil
+}
+
+// SetGState sets the graphics state, registered with
+// RegisterExtGState, used to paint paths. The zero GStateRef
+// restores the default state.
+func (c *Canvas) SetGState(ref GStateRef) {
+	if ref.id == 0 {
+		c.gstate = ""
+		return
+	}
+	c.gstate = "/" + c.cs.resourceName("ExtGState", "GS", ref.id) + " gs"
+}
+
+// colorOp returns the color operator op with components clamped to [0, 1].
+func colorOp(op string, components ...float64) string {
+	s := ""
+	for _, v := range components {
+		s += formatNum(math.Max(0, math.Min(1, v))) + " "
+	}
+	return s + op
+}
+
+// formatNum formats v with at most 3 decimals.
+func formatNum(v float64) string {
+	s := strings.TrimRight(strconv.FormatFloat(v, 'f', 3, 64), "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestCanvas(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Canvas(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Rect(72, 72, 3*INCH, INCH)
+	c.Stroke()
+	c.Stroke() // nothing to paint
+	c.MoveTo(72, 100)
+	c.LineTo(288, 100)
+	c.LineTo(288, 120)
+	c.Fill()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n72.00 72.00 216.00 72.00 re\nS\nQ\n" +
+		"q\n72.00 100.00 m\n288.00 100.00 l\n288.00 120.00 l\nf\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+
+	if _, err := p.Canvas(page + 100); err == nil {
+		t.Error("expected error for canvas on unknown page")
+	}
+}
+
+func TestCanvasColor(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Canvas(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetFillColor(1, 0, 0)
+	c.SetStrokeColor(0, 0, 1.5)
+	c.Rect(0, 0, 10, 10)
+	c.Fill()
+	c.SetStrokeCMYK(0.25, 0, -1, 0.333333)
+	c.Rect(0, 0, 10, 10)
+	c.Stroke()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n0 0 1 RG\n1 0 0 rg\n0.00 0.00 10.00 10.00 re\nf\nQ\n" +
+		"q\n0.25 0 0 0.333 K\n1 0 0 rg\n0.00 0.00 10.00 10.00 re\nS\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+}
+
+func TestCanvasLineStyle(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Canvas(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetLineWidth(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDash([]Length{6, 3}, 1); err != nil {
+		t.Fatal(err)
+	}
+	c.MoveTo(0, 0)
+	c.LineTo(100, 0)
+	c.Stroke()
+	if err := c.SetDash(nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.MoveTo(0, 10)
+	c.LineTo(100, 10)
+	c.Stroke()
+
+	if err := c.SetLineWidth(-1); err == nil {
+		t.Error("expected error for negative line width")
+	}
+	for _, pattern := range [][]Length{{3, -1}, {0, 0}} {
+		if err := c.SetDash(pattern, 0); err == nil {
+			t.Errorf("expected error for dash pattern %v", pattern)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n2.00 w\n[ 6.00 3.00 ] 1.00 d\n0.00 0.00 m\n100.00 0.00 l\nS\nQ\n" +
+		"q\n2.00 w\n0.00 10.00 m\n100.00 10.00 l\nS\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+}
+
+func TestCanvasCircle(t *testing.T) {
+	var cs ContentStream
+	c := cs.Canvas()
+	c.Circle(100, 100, 10)
+	c.Fill()
+	want := "q\n110.00 100.00 m\n" +
+		"110.00 105.52 105.52 110.00 100.00 110.00 c\n" +
+		"94.48 110.00 90.00 105.52 90.00 100.00 c\n" +
+		"90.00 94.48 94.48 90.00 100.00 90.00 c\n" +
+		"105.52 90.00 110.00 94.48 110.00 100.00 c\n" +
+		"h\nf\nQ\n"
+	if got := cs.buf.String(); got != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	if n := strings.Count(cs.buf.String(), " c\n"); n != 4 {
+		t.Errorf("got %d curves, want 4", n)
+	}
+}
+
+func TestCanvasCurves(t *testing.T) {
+	var cs ContentStream
+	c := cs.Canvas()
+	if err := c.CurveTo(10, 10, 20, 20, 30, 30); err == nil {
+		t.Error("expected error for curve without a current point")
+	}
+	c.MoveTo(0, 0)
+	if err := c.CurveTo(50, 0, 50, 50, 100, 50); err != nil {
+		t.Fatal(err)
+	}
+	c.CurveToV(150, 100, 200, 100)
+	c.CurveToY(250, 100, 250, 150)
+	c.Stroke()
+	if err := c.CurveToV(1, 1, 2, 2); err == nil {
+		t.Error("expected error for curve after the path was painted")
+	}
+	want := "q\n0.00 0.00 m\n" +
+		"50.00 0.00 50.00 50.00 100.00 50.00 c\n" +
+		"150.00 100.00 200.00 100.00 v\n" +
+		"250.00 100.00 250.00 150.00 y\n" +
+		"S\nQ\n"
+	if got := cs.buf.String(); got != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+}
+
+func TestCanvasRoundedRect(t *testing.T) {
+	var cs ContentStream
+	c := cs.Canvas()
+	// the radius is reduced to 20, so both ends are semicircles
+	if err := c.RoundedRect(0, 0, 100, 40, 50); err != nil {
+		t.Fatal(err)
+	}
+	c.Fill()
+	want := "q\n20.00 0.00 m\n" +
+		"80.00 0.00 l\n" +
+		"91.05 0.00 100.00 8.95 100.00 20.00 c\n" +
+		"100.00 31.05 91.05 40.00 80.00 40.00 c\n" +
+		"20.00 40.00 l\n" +
+		"8.95 40.00 0.00 31.05 0.00 20.00 c\n" +
+		"0.00 8.95 8.95 0.00 20.00 0.00 c\n" +
+		"h\nf\nQ\n"
+	if got := cs.buf.String(); got != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	if err := c.RoundedRect(0, 0, 100, 40, -1); err == nil {
+		t.Error("expected error for negative radius")
+	}
+	if err := c.RoundedRect(0, 0, -100, 40, 5); err == nil {
+		t.Error("expected error for negative width")
+	}
+}
+
+func TestCanvasClip(t *testing.T) {
+	var cs ContentStream
+	c := cs.Canvas()
+	c.Save()
+	c.Rect(72, 72, 144, 144)
+	c.Clip()
+	cs.DrawImage(ImageRef{id: 5, width: 10, height: 10}, 0, 0, 288, 288)
+	if err := c.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	c.Save()
+	c.Circle(100, 100, 50)
+	c.Circle(100, 100, 25)
+	c.ClipEvenOdd()
+	c.Restore()
+	if err := c.Restore(); err == nil {
+		t.Error("expected error for unbalanced restore")
+	}
+	got := cs.buf.String()
+	want := "q\n72.00 72.00 144.00 144.00 re\nW n\n" +
+		"q\n288.00 0 0 288.00 0.00 0.00 cm\n/I0 Do\nQ\nQ\n"
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("got content stream %q, want prefix %q", got, want)
+	}
+	if !strings.HasSuffix(got, "h\nW* n\nQ\n") {
+		t.Errorf("missing even-odd clip in %q", got)
+	}
+}
+
+func TestDrawInlineImage(t *testing.T) {
+	var cs ContentStream
+	c := cs.Canvas()
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = byte(16 * i)
+	}
+	if err := c.DrawInlineImage(72, 144, 16, 16, img); err != nil {
+		t.Fatal(err)
+	}
+	want := "q\n16.00 0 0 16.00 72.00 144.00 cm\n" +
+		"BI\n/W 4\n/H 4\n/CS /G\n/BPC 8\n/F /AHx\n" +
+		"ID\n00102030405060708090a0b0c0d0e0f0>\nEI\nQ\n"
+	if got := cs.buf.String(); got != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	if len(cs.res) != 0 {
+		t.Errorf("inline image added resources %v", cs.res)
+	}
+
+	cs.buf.Reset()
+	pal := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black, color.White})
+	if err := c.DrawInlineImage(0, 0, 1, 1, pal); err != nil {
+		t.Fatal(err)
+	}
+	if got := cs.buf.String(); !strings.Contains(got, "/CS [/I /RGB 1 <000000ffffff>]\n") {
+		t.Errorf("missing indexed color space in %q", got)
+	}
+
+	if err := c.DrawInlineImage(0, 0, 72, 72, image.NewRGBA(image.Rect(0, 0, 64, 64))); err == nil {
+		t.Error("expected error for large inline image")
+	}
+}
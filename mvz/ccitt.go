@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// This file implements CCITT Group 4 (T.6) compression
+// of bilevel images, as expected by the CCITTFaxDecode filter.
+
+// A faxCode is a variable length code of at most 16 bits.
+type faxCode struct {
+	bits uint16
+	n    uint8
+}
+
+func fc(s string) faxCode {
+	var c faxCode
+	for _, b := range s {
+		c.bits = c.bits<<1 | uint16(b-'0')
+		c.n++
+	}
+	return c
+}
+
+var (
+	faxPass       = fc("0001")
+	faxHorizontal = fc("001")
+	faxEOL        = fc("000000000001")
+	// vertical mode codes, for a1-b1 from -3 to 3.
+	faxVertical = [7]faxCode{
+		fc("0000010"), fc("000010"), fc("010"), fc("1"),
+		fc("011"), fc("000011"), fc("0000011"),
+	}
+)
+
+// Terminating codes for run lengths 0 to 63.
+var faxWhiteTerm = [64]faxCode{
+	fc("00110101"), fc("000111"), fc("0111"), fc("1000"),
+	fc("1011"), fc("1100"), fc("1110"), fc("1111"),
+	fc("10011"), fc("10100"), fc("00111"), fc("01000"),
+	fc("001000"), fc("000011"), fc("110100"), fc("110101"),
+	fc("101010"), fc("101011"), fc("0100111"), fc("0001100"),
+	fc("0001000"), fc("0010111"), fc("0000011"), fc("0000100"),
+	fc("0101000"), fc("0101011"), fc("0010011"), fc("0100100"),
+	fc("0011000"), fc("00000010"), fc("00000011"), fc("00011010"),
+	fc("00011011"), fc("00010010"), fc("00010011"), fc("00010100"),
+	fc("00010101"), fc("00010110"), fc("00010111"), fc("00101000"),
+	fc("00101001"), fc("00101010"), fc("00101011"), fc("00101100"),
+	fc("00101101"), fc("00000100"), fc("00000101"), fc("00001010"),
+	fc("00001011"), fc("01010010"), fc("01010011"), fc("01010100"),
+	fc("01010101"), fc("00100100"), fc("00100101"), fc("01011000"),
+	fc("01011001"), fc("01011010"), fc("01011011"), fc("01001010"),
+	fc("01001011"), fc("00110010"), fc("00110011"), fc("00110100"),
+}
+
+var faxBlackTerm = [64]faxCode{
+	fc("0000110111"), fc("010"), fc("11"), fc("10"),
+	fc("011"), fc("0011"), fc("0010"), fc("00011"),
+	fc("000101"), fc("000100"), fc("0000100"), fc("0000101"),
+	fc("0000111"), fc("00000100"), fc("00000111"), fc("000011000"),
+	fc("0000010111"), fc("0000011000"), fc("0000001000"), fc("00001100111"),
+	fc("00001101000"), fc("00001101100"), fc("00000110111"), fc("00000101000"),
+	fc("00000010111"), fc("00000011000"), fc("000011001010"), fc("000011001011"),
+	fc("000011001100"), fc("000011001101"), fc("000001101000"), fc("000001101001"),
+	fc("000001101010"), fc("000001101011"), fc("000011010010"), fc("000011010011"),
+	fc("000011010100"), fc("000011010101"), fc("000011010110"), fc("000011010111"),
+	fc("000001101100"), fc("000001101101"), fc("000011011010"), fc("000011011011"),
+	fc("000001010100"), fc("000001010101"), fc("000001010110"), fc("000001010111"),
+	fc("000001100100"), fc("000001100101"), fc("000001010010"), fc("000001010011"),
+	fc("000000100100"), fc("000000110111"), fc("000000111000"), fc("000000100111"),
+	fc("000000101000"), fc("000001011000"), fc("000001011001"), fc("000000101011"),
+	fc("000000101100"), fc("000001011010"), fc("000001100110"), fc("000001100111"),
+}
+
+// Make-up codes for run lengths 64 to 1728, by steps of 64.
+var faxWhiteMakeup = [27]faxCode{
+	fc("11011"), fc("10010"), fc("010111"), fc("0110111"),
+	fc("00110110"), fc("00110111"), fc("01100100"), fc("01100101"),
+	fc("01101000"), fc("01100111"), fc("011001100"), fc("011001101"),
+	fc("011010010"), fc("011010011"), fc("011010100"), fc("011010101"),
+	fc("011010110"), fc("011010111"), fc("011011000"), fc("011011001"),
+	fc("011011010"), fc("011011011"), fc("010011000"), fc("010011001"),
+	fc("010011010"), fc("011000"), fc("010011011"),
+}
+
+var faxBlackMakeup = [27]faxCode{
+	fc("0000001111"), fc("000011001000"), fc("000011001001"), fc("000001011011"),
+	fc("000000110011"), fc("000000110100"), fc("000000110101"), fc("0000001101100"),
+	fc("0000001101101"), fc("0000001001010"), fc("0000001001011"), fc("0000001001100"),
+	fc("0000001001101"), fc("0000001110010"), fc("0000001110011"), fc("0000001110100"),
+	fc("0000001110101"), fc("0000001110110"), fc("0000001110111"), fc("0000001010010"),
+	fc("0000001010011"), fc("0000001010100"), fc("0000001010101"), fc("0000001011010"),
+	fc("0000001011011"), fc("0000001100100"), fc("0000001100101"),
+}
+
+// Extended make-up codes, common to both colors,
+// for run lengths 1792 to 2560.
+var faxExtMakeup = [13]faxCode{
+	fc("00000001000"), fc("00000001100"), fc("00000001101"), fc("000000010010"),
+	fc("000000010011"), fc("000000010100"), fc("000000010101"), fc("000000010110"),
+	fc("000000010111"), fc("000000011100"), fc("000000011101"), fc("000000011110"),
+	fc("000000011111"),
+}
+
+// A bitWriter accumulates bits, most significant first.
+type bitWriter struct {
+	buf  []byte
+	acc  uint32
+	nacc uint
+}
+
+func (w *bitWriter) put(c faxCode) {
+	w.acc = w.acc<<c.n | uint32(c.bits)
+	w.nacc += uint(c.n)
+	for w.nacc >= 8 {
+		w.nacc -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nacc))
+	}
+}
+
+func (w *bitWriter) flush() []byte {
+	if w.nacc > 0 {
+		w.buf = append(w.buf, byte(w.acc<<(8-w.nacc)))
+		w.nacc = 0
+	}
+	return w.buf
+}
+
+// putRun writes a run length of the given color.
+func (w *bitWriter) putRun(n int, black bool) {
+	term, makeup := &faxWhiteTerm, &faxWhiteMakeup
+	if black {
+		term, makeup = &faxBlackTerm, &faxBlackMakeup
+	}
+	for n >= 2560 {
+		w.put(faxExtMakeup[len(faxExtMakeup)-1])
+		n -= 2560
+	}
+	if n >= 1792 {
+		w.put(faxExtMakeup[(n-1792)/64])
+		n %= 64
+	} else if n >= 64 {
+		w.put(makeup[n/64-1])
+		n %= 64
+	}
+	w.put(term[n])
+}
+
+// bilevel thresholds img to a bitmap where true means black.
+func bilevel(img *image.Gray) [][]bool {
+	r := img.Bounds()
+	rows := make([][]bool, r.Dy())
+	for y := range rows {
+		row := make([]bool, r.Dx())
+		for x := range row {
+			row[x] = img.GrayAt(r.Min.X+x, r.Min.Y+y).Y < 0x80
+		}
+		rows[y] = row
+	}
+	return rows
+}
+
+// nextChange returns the position of the first pixel after pos
+// having the given color, or len(line) if there is none.
+// Pixels before the start of the line are white.
+func nextChange(line []bool, pos int, black bool) int {
+	for x := pos + 1; x < len(line); x++ {
+		if x < 0 {
+			continue
+		}
+		if line[x] == black {
+			prev := false
+			if x > 0 {
+				prev = line[x-1]
+			}
+			if prev != black {
+				return x
+			}
+		}
+	}
+	return len(line)
+}
+
+// encodeG4 compresses a bitmap using CCITT Group 4 encoding.
+func encodeG4(rows [][]bool) []byte {
+	w := new(bitWriter)
+	var ref []bool
+	for _, line := range rows {
+		if ref == nil {
+			ref = make([]bool, len(line)) // imaginary white line
+		}
+		width := len(line)
+		a0, black := -1, false
+		for a0 < width {
+			// a1 is the next change on the coding line,
+			// b1 the next change to the opposite color.
+			a1 := width
+			for x := a0 + 1; x < width; x++ {
+				if x >= 0 && line[x] != black {
+					a1 = x
+					break
+				}
+			}
+			b1 := nextChange(ref, a0, !black)
+			b2 := nextChange(ref, b1, black)
+			switch {
+			case b2 < a1:
+				w.put(faxPass)
+				a0 = b2
+			case a1-b1 >= -3 && a1-b1 <= 3:
+				w.put(faxVertical[a1-b1+3])
+				a0, black = a1, !black
+			default:
+				a2 := width
+				for x := a1 + 1; x < width; x++ {
+					if line[x] == black {
+						a2 = x
+						break
+					}
+				}
+				start := a0
+				if start < 0 {
+					start = 0
+				}
+				w.put(faxHorizontal)
+				w.putRun(a1-start, black)
+				w.putRun(a2-a1, !black)
+				a0 = a2
+			}
+		}
+		ref = line
+	}
+	w.put(faxEOL)
+	w.put(faxEOL)
+	return w.flush()
+}
+
+// WriteBilevelPage writes a page holding img thresholded to black
+// and white, compressed with the CCITT Group 4 fax encoding.
+func (p *PDFWriter) WriteBilevelPage(img *image.Gray) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	data := encodeG4(bilevel(img))
+	return p.writeImagePage(imageDict{
+		width: w, height: h,
+		bpc:         1,
+		filter:      "/CCITTFaxDecode",
+		decodeParms: fmt.Sprintf("<< /K -1 /Columns %d /Rows %d /BlackIs1 true >>", w, h),
+		colorSpace:  "/DeviceGray",
+		// BlackIs1 makes black pixels decode as 1,
+		// which is white in DeviceGray.
+		decode: "[1 0]",
+	}, data, nil)
+}
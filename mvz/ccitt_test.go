@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"regexp"
+	"testing"
+)
+
+// A bitReader reads bits, most significant first.
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) bit() (uint16, bool) {
+	if r.pos >= 8*uint(len(r.data)) {
+		return 0, false
+	}
+	b := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+	return uint16(b), true
+}
+
+// readCode reads a code from the given table.
+func (r *bitReader) readCode(table map[faxCode]int) (int, bool) {
+	var c faxCode
+	for c.n < 16 {
+		b, ok := r.bit()
+		if !ok {
+			return 0, false
+		}
+		c.bits, c.n = c.bits<<1|b, c.n+1
+		if v, ok := table[c]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func runTable(term *[64]faxCode, makeup *[27]faxCode) map[faxCode]int {
+	m := make(map[faxCode]int)
+	for i, c := range term {
+		m[c] = i
+	}
+	for i, c := range makeup {
+		m[c] = 64 * (i + 1)
+	}
+	for i, c := range faxExtMakeup {
+		m[c] = 1792 + 64*i
+	}
+	return m
+}
+
+// readRun reads a full run length (make-up codes and terminating code).
+func (r *bitReader) readRun(table map[faxCode]int) (int, bool) {
+	n := 0
+	for {
+		v, ok := r.readCode(table)
+		if !ok {
+			return 0, false
+		}
+		n += v
+		if v < 64 {
+			return n, true
+		}
+	}
+}
+
+// decodeG4 is a straightforward T.6 decoder.
+func decodeG4(t *testing.T, data []byte, width, height int) [][]bool {
+	const (
+		pass = iota + 100
+		horiz
+		eol
+	)
+	modes := map[faxCode]int{faxPass: pass, faxHorizontal: horiz, faxEOL: eol}
+	for i, c := range faxVertical {
+		modes[c] = i - 3
+	}
+	white := runTable(&faxWhiteTerm, &faxWhiteMakeup)
+	black := runTable(&faxBlackTerm, &faxBlackMakeup)
+
+	r := &bitReader{data: data}
+	ref := make([]bool, width)
+	var rows [][]bool
+	for len(rows) < height {
+		line := make([]bool, width)
+		a0, color := -1, false
+		fill := func(from, to int, c bool) {
+			if from < 0 {
+				from = 0
+			}
+			for x := from; x < to && x < width; x++ {
+				line[x] = c
+			}
+		}
+		for a0 < width {
+			mode, ok := r.readCode(modes)
+			if !ok {
+				t.Fatalf("row %d: invalid mode code at bit %d", len(rows), r.pos)
+			}
+			b1 := nextChange(ref, a0, !color)
+			b2 := nextChange(ref, b1, color)
+			switch mode {
+			case pass:
+				fill(a0, b2, color)
+				a0 = b2
+			case horiz:
+				tables := [2]map[faxCode]int{white, black}
+				c := 0
+				if color {
+					c = 1
+				}
+				n1, ok1 := r.readRun(tables[c])
+				n2, ok2 := r.readRun(tables[1-c])
+				if !ok1 || !ok2 {
+					t.Fatalf("row %d: invalid run length", len(rows))
+				}
+				start := a0
+				if start < 0 {
+					start = 0
+				}
+				fill(start, start+n1, color)
+				fill(start+n1, start+n1+n2, !color)
+				a0 = start + n1 + n2
+			case eol:
+				t.Fatalf("row %d: unexpected EOL", len(rows))
+			default:
+				a1 := b1 + mode
+				fill(a0, a1, color)
+				a0, color = a1, !color
+			}
+		}
+		rows = append(rows, line)
+		ref = line
+	}
+	for i := 0; i < 2; i++ {
+		if mode, _ := r.readCode(modes); mode != eol {
+			t.Errorf("missing EOFB")
+		}
+	}
+	return rows
+}
+
+// checkPrefixFree verifies that no code is a prefix of another.
+func checkPrefixFree(t *testing.T, name string, codes []faxCode) {
+	for i, c := range codes {
+		for j, d := range codes {
+			if i == j || c.n > d.n {
+				continue
+			}
+			if d.bits>>(d.n-c.n) == c.bits {
+				t.Errorf("%s: code %d is a prefix of code %d", name, i, j)
+			}
+		}
+	}
+}
+
+func TestFaxCodes(t *testing.T) {
+	var white, black []faxCode
+	white = append(append(append(white, faxWhiteTerm[:]...), faxWhiteMakeup[:]...), faxExtMakeup[:]...)
+	black = append(append(append(black, faxBlackTerm[:]...), faxBlackMakeup[:]...), faxExtMakeup[:]...)
+	checkPrefixFree(t, "white", white)
+	checkPrefixFree(t, "black", black)
+	checkPrefixFree(t, "modes", append([]faxCode{faxPass, faxHorizontal, faxEOL}, faxVertical[:]...))
+}
+
+func TestBilevelPage(t *testing.T) {
+	const w, h = 3000, 40
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := byte(0xff)
+			switch {
+			case (x/7+y/5)%3 == 0, x > 100 && x < 2900 && y == 20:
+				v = 0
+			case x >= 2000 && y%9 < 4:
+				v = 0x40
+			}
+			img.Pix[y*img.Stride+x] = v
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if _, err := p.WriteBilevelPage(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	for _, s := range []string{
+		"/Filter [ /CCITTFaxDecode ]",
+		"/K -1 /Columns 3000 /Rows 40 /BlackIs1 true",
+		"/BitsPerComponent 1",
+		"/ColorSpace /DeviceGray",
+	} {
+		if !bytes.Contains(obj.dict, []byte(s)) {
+			t.Errorf("missing %s in %s", s, obj.dict)
+		}
+	}
+	if regexp.MustCompile(`/Width 3000\n/Height 40`).Find(obj.dict) == nil {
+		t.Errorf("wrong dimensions in %s", obj.dict)
+	}
+	t.Logf("compressed %dx%d bitmap to %d bytes", w, h, len(obj.stream))
+
+	want := bilevel(img)
+	got := decodeG4(t, obj.stream, w, h)
+	for y := range want {
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Fatalf("pixel (%d, %d) differs after decoding", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A ContentStream accumulates the operators of a page's contents,
+// along with the resources they use. The zero value is an empty
+// stream ready to use; attach it to a page with WritePage.
+type ContentStream struct {
+	buf bytes.Buffer
+	res resources
+	ts  textState // of Text and TextBox
+}
+
+// Append appends raw operators to the stream. Any resources
+// they use must already be in the page's resources.
+func (cs *ContentStream) Append(ops string) {
+	cs.buf.WriteString(ops)
+	if !strings.HasSuffix(ops, "\n") {
+		cs.buf.WriteByte('\n')
+	}
+}
+
+// Len returns the length of the stream in bytes.
+func (cs *ContentStream) Len() int {
+	return cs.buf.Len()
+}
+
+// SetTextDirection sets the direction of the text drawn by Text
+// and TextBox. Right-to-left text is drawn in visual order, ending
+// at the given position; the ToUnicode maps of embedded fonts keep
+// each glyph's character, so that viewers can recover the logical
+// order when copying text. Arabic letters are not shaped.
+func (cs *ContentStream) SetTextDirection(dir TextDirection) {
+	cs.ts.dir = dir
+}
+
+// SetTextColor sets the RGB color of the text drawn by Text and
+// TextBox, used both to fill and stroke glyphs. Components are
+// clamped to [0, 1]. The default is black.
+func (cs *ContentStream) SetTextColor(r, g, b float64) {
+	cs.ts.color = textColor(r, g, b)
+}
+
+// textColor returns the operators setting both the fill and
+// stroke colors of text.
+func textColor(r, g, b float64) string {
+	return colorOp("rg", r, g, b) + " " + colorOp("RG", r, g, b)
+}
+
+// A TextRenderMode tells how glyphs are painted.
+type TextRenderMode int
+
+const (
+	FillText TextRenderMode = iota
+	StrokeText
+	FillStrokeText
+	// InvisibleText is neither filled nor stroked, but can be
+	// selected and searched, as for text recognized in scans.
+	InvisibleText
+)
+
+// SetTextRenderMode sets how the text drawn by Text and TextBox
+// is painted. The default is FillText.
+func (cs *ContentStream) SetTextRenderMode(mode TextRenderMode) {
+	cs.ts.mode = mode
+}
+
+// textState holds the parameters of drawn text.
+type textState struct {
+	dir   TextDirection
+	color string // color operators; empty for black
+	mode  TextRenderMode
+}
+
+// ops returns the operators setting the color and rendering mode,
+// to follow BT, or an empty string for the defaults.
+func (ts textState) ops() string {
+	s := ""
+	if ts.color != "" {
+		s += ts.color + "\n"
+	}
+	if ts.mode != FillText {
+		s += fmt.Sprintf("%d Tr\n", ts.mode)
+	}
+	return s
+}
+
+// Text draws a line of text starting at (x, y), in font f with the
+// given size, in points. Right-to-left text ends at (x, y).
+func (cs *ContentStream) Text(f *Font, size float64, x, y Length, text string) {
+	cs.text(f, size, x, y, text, cs.ts)
+}
+
+func (cs *ContentStream) text(f *Font, size float64, x, y Length, text string, ts textState) {
+	text = visualOrder(text, ts.dir)
+	if ts.dir == RightToLeft {
+		x -= f.StringWidth(text, size)
+	}
+	cs.addResource("Font", f.name, f.id)
+	// the color and mode would outlast ET
+	state := ts.ops()
+	if state != "" {
+		cs.buf.WriteString("q\n")
+	}
+	fmt.Fprintf(&cs.buf, "BT\n/%s %.2f Tf\n%s%.2f %.2f Td\n%s Tj\nET\n",
+		f.name, size, state, x, y, f.encode(text))
+	if state != "" {
+		cs.buf.WriteString("Q\n")
+	}
+}
+
+// TextBox draws text in font f with the given size, in points,
+// wrapped at spaces to fit the width of rect [x0 y0 x1 y1], on as
+// many lines as fit in its height, and clipped to rect. Newlines in
+// text start new lines. Right-to-left lines are aligned on the right
+// edge of rect. TextBox returns the text that did not fit.
+func (cs *ContentStream) TextBox(f *Font, size float64, rect [4]Length, text string) string {
+	return cs.textBox(f, size, rect, text, cs.ts)
+}
+
+func (cs *ContentStream) textBox(f *Font, size float64, rect [4]Length, text string, ts textState) string {
+	leading := Length(size * 1.2)
+	maxLines := 0
+	if h := rect[3] - rect[1]; h >= Length(size) {
+		maxLines = int((h-Length(size))/leading) + 1
+	}
+	lines, rest := wrapText(f, size, rect[2]-rect[0], text, maxLines)
+	if len(lines) == 0 {
+		return rest
+	}
+	cs.addResource("Font", f.name, f.id)
+	fmt.Fprintf(&cs.buf, "q\n%.2f %.2f %.2f %.2f re W n\nBT\n/%s %.2f Tf\n%s",
+		rect[0], rect[1], rect[2]-rect[0], rect[3]-rect[1], f.name, size, ts.ops())
+	// lines are positioned relative to the previous one
+	x, y := Length(0), Length(0)
+	for i, line := range lines {
+		line = visualOrder(line, ts.dir)
+		lx, ly := rect[0], rect[3]-Length(size)-Length(i)*leading
+		if ts.dir == RightToLeft {
+			lx = rect[2] - f.StringWidth(line, size)
+		}
+		fmt.Fprintf(&cs.buf, "%.2f %.2f Td\n%s Tj\n", lx-x, ly-y, f.encode(line))
+		x, y = lx, ly
+	}
+	cs.buf.WriteString("ET\nQ\n")
+	return rest
+}
+
+// Canvas returns a canvas drawing into the stream.
+func (cs *ContentStream) Canvas() *Canvas {
+	return &Canvas{cs: cs}
+}
+
+// DrawImage draws a registered image into the rectangle with lower
+// left corner (x, y), width w and height h. Each image drawn in the
+// stream gets its own resource name.
+func (cs *ContentStream) DrawImage(ref ImageRef, x, y, w, h Length) {
+	cs.drawImage(ref.id, x, y, w, h)
+}
+
+// drawImage draws the image XObject img into the rectangle with
+// lower left corner (x, y).
+func (cs *ContentStream) drawImage(img PDFID, x, y, w, h Length) {
+	name := cs.resourceName("XObject", "I", img)
+	fmt.Fprintf(&cs.buf, "q\n%.2f 0 0 %.2f %.2f %.2f cm\n/%s Do\nQ\n", w, h, x, y, name)
+}
+
+// resourceName returns the name of resource id in category,
+// adding it as prefix followed by a number if needed.
+func (cs *ContentStream) resourceName(category, prefix string, id PDFID) string {
+	for name, ref := range cs.res[category] {
+		if ref == id {
+			return name
+		}
+	}
+	name := fmt.Sprintf("%s%d", prefix, len(cs.res[category]))
+	cs.addResource(category, name, id)
+	return name
+}
+
+func (cs *ContentStream) addResource(category, name string, id PDFID) {
+	if cs.res == nil {
+		cs.res = make(resources)
+	}
+	cs.res.add(category, name, id)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestContentStream(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := p.writeImage(imageDict{
+		width: 2, height: 1,
+		filter:     "/FlateDecode",
+		colorSpace: "/DeviceGray",
+	}, mustDeflate(t, []byte{0, 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	font, err := p.StandardFont("Courier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cs ContentStream
+	cs.drawImage(img, 72, 144, 200, 100)
+	cs.Text(font, 9, 72, 130, "Figure 1")
+	cs.drawImage(img, 300, 144, 20, 10) // same image, same name
+	c := cs.Canvas()
+	c.Rect(72, 144, 200, 100)
+	c.Stroke()
+	cs.Append("% raw")
+	page, err := p.WritePage(A4.Width, A4.Height, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n200.00 0 0 100.00 72.00 144.00 cm\n/I0 Do\nQ\n" +
+		"BT\n/F1 9.00 Tf\n72.00 130.00 Td\n(Figure 1) Tj\nET\n" +
+		"q\n20.00 0 0 10.00 300.00 144.00 cm\n/I0 Do\nQ\n" +
+		"q\n72.00 144.00 200.00 100.00 re\nS\nQ\n" +
+		"% raw\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	pg := readObjects(t, buf.Bytes())[page-1]
+	for _, res := range []string{
+		fmt.Sprintf("/XObject << /I0 %d 0 R >>", img),
+		fmt.Sprintf("/Font << /F1 %d 0 R >>", font.id),
+	} {
+		if !bytes.Contains(pg.dict, []byte(res)) {
+			t.Errorf("missing %s in page resources %s", res, pg.dict)
+		}
+	}
+}
+
+func mustDeflate(t *testing.T, data []byte) []byte {
+	z, err := deflate(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// This file implements encryption with the standard security
+// handler, using 128-bit RC4 (revision 3) or 256-bit AES
+// (revision 6).
+
+// A Cipher is an encryption algorithm.
+type Cipher int
+
+const (
+	RC4    Cipher = iota // 128-bit RC4, PDF 1.4
+	AES256               // 256-bit AES, PDF 2.0
+)
+
+// Permissions are the operations allowed on an encrypted document,
+// as the bits of the /P entry of the encryption dictionary.
+type Permissions uint32
+
+const (
+	PermPrint        Permissions = 1 << 2  // print, possibly at low resolution
+	PermModify       Permissions = 1 << 3  // modify contents
+	PermCopy         Permissions = 1 << 4  // copy or extract text and graphics
+	PermAnnotate     Permissions = 1 << 5  // add annotations, fill in forms
+	PermFillForms    Permissions = 1 << 8  // fill in existing form fields
+	PermExtract      Permissions = 1 << 9  // extract for accessibility
+	PermAssemble     Permissions = 1 << 10 // insert, rotate or delete pages
+	PermPrintHighRes Permissions = 1 << 11 // print at full resolution
+
+	// AllPermissions allows every operation.
+	AllPermissions = PermPrint | PermModify | PermCopy | PermAnnotate |
+		PermFillForms | PermExtract | PermAssemble | PermPrintHighRes
+)
+
+// value returns the /P entry: reserved bits 7-8 and 13-32 are set,
+// and bits 1-2 are clear.
+func (perms Permissions) value() int32 {
+	return int32(uint32(perms&AllPermissions) | 0xfffff0c0)
+}
+
+// passwordPad pads passwords to 32 bytes.
+var passwordPad = []byte{
+	0x28, 0xbf, 0x4e, 0x5e, 0x4e, 0x75, 0x8a, 0x41,
+	0x64, 0x00, 0x4e, 0x56, 0xff, 0xfa, 0x01, 0x08,
+	0x2e, 0x2e, 0x00, 0xb6, 0xd0, 0x68, 0x3e, 0x80,
+	0x2f, 0x0c, 0xa9, 0xfe, 0x64, 0x53, 0x69, 0x7a,
+}
+
+func padPassword(pwd string) []byte {
+	b := append([]byte(pwd), passwordPad...)
+	return b[:32]
+}
+
+// A securityHandler holds the encryption dictionary and file key.
+type securityHandler struct {
+	cipher Cipher
+	id     PDFID // encryption dictionary
+	o, u   []byte
+	oe, ue []byte // AES256 only
+	perms  []byte // AES256 only
+	p      int32
+	key    []byte
+}
+
+const keyLength = 16 // bytes
+
+// newRC4Handler computes the /O and /U entries and the file key,
+// following algorithms 2, 3 and 5 of the PDF specification.
+func newRC4Handler(userPwd, ownerPwd string, perms Permissions, docID []byte) *securityHandler {
+	h := &securityHandler{cipher: RC4, p: perms.value()}
+
+	// owner entry: the user password encrypted with the owner's
+	if ownerPwd == "" {
+		ownerPwd = userPwd
+	}
+	sum := md5.Sum(padPassword(ownerPwd))
+	for i := 0; i < 50; i++ {
+		sum = md5.Sum(sum[:keyLength])
+	}
+	h.o = rc4Rounds(sum[:keyLength], padPassword(userPwd))
+
+	// file key
+	d := md5.New()
+	d.Write(padPassword(userPwd))
+	d.Write(h.o)
+	binary.Write(d, binary.LittleEndian, h.p)
+	d.Write(docID)
+	key := d.Sum(nil)
+	for i := 0; i < 50; i++ {
+		sum := md5.Sum(key[:keyLength])
+		key = sum[:]
+	}
+	h.key = key[:keyLength]
+
+	// user entry: the padding hashed with the ID, encrypted with
+	// the key, followed by arbitrary padding
+	d = md5.New()
+	d.Write(passwordPad)
+	d.Write(docID)
+	h.u = append(rc4Rounds(h.key, d.Sum(nil)), make([]byte, 16)...)
+	return h
+}
+
+// rc4Rounds encrypts data with key, then 19 more times with key
+// xored with the round number.
+func rc4Rounds(key, data []byte) []byte {
+	out := append([]byte(nil), data...)
+	k := make([]byte, len(key))
+	for i := 0; i < 20; i++ {
+		for j := range key {
+			k[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(k)
+		c.XORKeyStream(out, out)
+	}
+	return out
+}
+
+// objectKey returns the key for strings and streams of object id.
+func (h *securityHandler) objectKey(id PDFID) []byte {
+	d := md5.New()
+	d.Write(h.key)
+	d.Write([]byte{byte(id), byte(id >> 8), byte(id >> 16), 0, 0})
+	key := d.Sum(nil)
+	if n := len(h.key) + 5; n < len(key) {
+		key = key[:n]
+	}
+	return key
+}
+
+// newAESHandler computes the entries of a revision 6 handler
+// for a random file key, following algorithms 8, 9 and 10 of
+// ISO 32000-2.
+func newAESHandler(userPwd, ownerPwd string, perms Permissions, random func([]byte)) *securityHandler {
+	h := &securityHandler{cipher: AES256, p: perms.value(), key: make([]byte, 32)}
+	random(h.key)
+	if ownerPwd == "" {
+		ownerPwd = userPwd
+	}
+	// validation and key salts
+	salts := make([]byte, 32)
+	random(salts)
+	h.u = append(hashR6(userPwd, salts[0:8], nil), salts[0:16]...)
+	h.ue = aesNoPad(hashR6(userPwd, salts[8:16], nil), h.key)
+	h.o = append(hashR6(ownerPwd, salts[16:24], h.u), salts[16:32]...)
+	h.oe = aesNoPad(hashR6(ownerPwd, salts[24:32], h.u), h.key)
+
+	h.perms = make([]byte, 16)
+	binary.LittleEndian.PutUint32(h.perms, uint32(h.p))
+	copy(h.perms[4:], []byte{0xff, 0xff, 0xff, 0xff, 'T', 'a', 'd', 'b'})
+	random(h.perms[12:])
+	c, _ := aes.NewCipher(h.key)
+	c.Encrypt(h.perms, h.perms)
+	return h
+}
+
+// hashR6 computes the hash of a password with a salt and, for the
+// owner password, the /U entry (algorithm 2.B of ISO 32000-2).
+func hashR6(pwd string, salt, udata []byte) []byte {
+	if len(pwd) > 127 {
+		pwd = pwd[:127]
+	}
+	k := sha256.New()
+	k.Write([]byte(pwd))
+	k.Write(salt)
+	k.Write(udata)
+	key := k.Sum(nil)
+	for i := 0; ; i++ {
+		round := append(append([]byte(pwd), key...), udata...)
+		k1 := bytes.Repeat(round, 64)
+		c, _ := aes.NewCipher(key[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(c, key[16:32]).CryptBlocks(e, k1)
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		var d hash.Hash
+		switch sum % 3 {
+		case 0:
+			d = sha256.New()
+		case 1:
+			d = sha512.New384()
+		case 2:
+			d = sha512.New()
+		}
+		d.Write(e)
+		key = d.Sum(nil)
+		if i >= 63 && int(e[len(e)-1]) <= i+1-32 {
+			break
+		}
+	}
+	return key[:32]
+}
+
+// aesNoPad encrypts data, a multiple of the block size, with a
+// zero initialization vector.
+func aesNoPad(key, data []byte) []byte {
+	c, _ := aes.NewCipher(key)
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+	return out
+}
+
+// SetEncryption encrypts the document with the given cipher. The
+// user password is needed to open it, the owner password to change
+// permissions; it defaults to the user password. Pass AllPermissions
+// to allow every operation, as in unencrypted documents.
+// SetEncryption must be called before anything is written, and no
+// later than WriteInfo for the document identifier to depend on
+// the document info.
+func (p *PDFWriter) SetEncryption(userPwd, ownerPwd string, perms Permissions, c Cipher) error {
+	if p.offset > 0 {
+		return fmt.Errorf("encryption must be set before writing objects")
+	}
+	if p.docID == nil {
+		p.docID = make([]byte, 16)
+		p.random(p.docID)
+	}
+	switch c {
+	case RC4:
+		p.crypt = newRC4Handler(userPwd, ownerPwd, perms, p.docID)
+		p.requireVersion("1.4")
+	case AES256:
+		p.crypt = newAESHandler(userPwd, ownerPwd, perms, p.random)
+		p.requireVersion("2.0")
+	default:
+		return fmt.Errorf("unknown cipher %d", c)
+	}
+	p.crypt.id, _ = p.startObj()
+	p.print("/Filter /Standard")
+	if c == RC4 {
+		p.print("/V 2")
+		p.print("/R 3")
+		p.printf("/Length %d", 8*keyLength)
+	} else {
+		p.print("/V 5")
+		p.print("/R 6")
+		p.print("/Length 256")
+		p.print("/CF << /StdCF << /AuthEvent /DocOpen /CFM /AESV3 /Length 32 >> >>")
+		p.print("/StmF /StdCF")
+		p.print("/StrF /StdCF")
+		p.printf("/OE <%s>", hex.EncodeToString(p.crypt.oe))
+		p.printf("/UE <%s>", hex.EncodeToString(p.crypt.ue))
+		p.printf("/Perms <%s>", hex.EncodeToString(p.crypt.perms))
+	}
+	p.printf("/O <%s>", hex.EncodeToString(p.crypt.o))
+	p.printf("/U <%s>", hex.EncodeToString(p.crypt.u))
+	p.printf("/P %d", p.crypt.p)
+	return p.endObj()
+}
+
+// random fills b with random bytes, or with a fixed sequence
+// of pseudo-random bytes in deterministic mode.
+func (p *PDFWriter) random(b []byte) {
+	if !p.deterministic {
+		if _, err := rand.Read(b); err != nil && p.err == nil {
+			p.err = err
+		}
+		return
+	}
+	for len(b) > 0 {
+		var block [8]byte
+		binary.BigEndian.PutUint64(block[:], p.counter)
+		p.counter++
+		sum := sha256.Sum256(block[:])
+		b = b[copy(b, sum[:]):]
+	}
+}
+
+// encrypt encrypts data of the current object, if the document
+// is encrypted.
+func (p *PDFWriter) encrypt(data []byte) []byte {
+	if p.crypt == nil || p.cur == p.crypt.id {
+		return data
+	}
+	if p.crypt.cipher == AES256 {
+		// random initialization vector, then PKCS#7 padded data
+		n := aes.BlockSize - len(data)%aes.BlockSize
+		out := make([]byte, aes.BlockSize+len(data)+n)
+		p.random(out[:aes.BlockSize])
+		copy(out[aes.BlockSize:], data)
+		for i := len(out) - n; i < len(out); i++ {
+			out[i] = byte(n)
+		}
+		c, _ := aes.NewCipher(p.crypt.key)
+		cipher.NewCBCEncrypter(c, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+		return out
+	}
+	c, _ := rc4.NewCipher(p.crypt.objectKey(p.cur))
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+// str formats s as a text string of the current object, encrypted
+// if the document is.
+func (p *PDFWriter) str(s string) string {
+	return p.byteStr(textString(s))
+}
+
+// byteStr is like str, for strings whose bytes are written as they
+// are, such as URIs and destination names.
+func (p *PDFWriter) byteStr(s string) string {
+	if p.crypt == nil {
+		return "(" + escapePDFBytes(s) + ")"
+	}
+	return "<" + hex.EncodeToString(p.encrypt([]byte(s))) + ">"
+}
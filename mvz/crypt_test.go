@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	encryptRx = regexp.MustCompile(`/Encrypt (\d+) 0 R`)
+	hexRx     = regexp.MustCompile(`/([OU]E?|Perms) <([0-9a-f]+)>`)
+	permsRx   = regexp.MustCompile(`/P (-?\d+)`)
+)
+
+// rc4Key derives the file key from the user password, following
+// algorithm 2 of the PDF specification.
+func rc4Key(t *testing.T, data []byte, pwd string) (key []byte, encrypt pdfObject) {
+	m := encryptRx.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing /Encrypt in trailer")
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	encrypt = readObjects(t, data)[id-1]
+	entries := encryptEntries(encrypt)
+	m = permsRx.FindSubmatch(encrypt.dict)
+	if m == nil || len(entries["O"]) != 32 || len(entries["U"]) != 32 {
+		t.Fatalf("invalid encryption dictionary:\n%s", encrypt.dict)
+	}
+	perms, _ := strconv.Atoi(string(m[1]))
+	docID, _ := hex.DecodeString(string(idRx.FindSubmatch(data)[1]))
+
+	h := md5.New()
+	h.Write(append([]byte(pwd), passwordPad...)[:32])
+	h.Write(entries["O"])
+	binary.Write(h, binary.LittleEndian, int32(perms))
+	h.Write(docID)
+	key = h.Sum(nil)
+	for i := 0; i < 50; i++ {
+		sum := md5.Sum(key)
+		key = sum[:]
+	}
+
+	// the key decrypts the start of /U to the hash of the padding
+	h = md5.New()
+	h.Write(passwordPad)
+	h.Write(docID)
+	want := h.Sum(nil)
+	u := entries["U"][:16]
+	for i := 19; i >= 0; i-- {
+		k := make([]byte, len(key))
+		for j := range k {
+			k[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(k)
+		c.XORKeyStream(u, u)
+	}
+	if !bytes.Equal(u, want) {
+		t.Fatalf("password %q does not match /U", pwd)
+	}
+	return key, encrypt
+}
+
+// encryptEntries returns the hex strings of an encryption dictionary.
+func encryptEntries(encrypt pdfObject) map[string][]byte {
+	entries := map[string][]byte{}
+	for _, m := range hexRx.FindAllSubmatch(encrypt.dict, -1) {
+		entries[string(m[1])], _ = hex.DecodeString(string(m[2]))
+	}
+	return entries
+}
+
+func rc4Decrypt(key []byte, id PDFID, data []byte) []byte {
+	sum := md5.Sum(append(append([]byte(nil), key...), byte(id), byte(id>>8), byte(id>>16), 0, 0))
+	c, _ := rc4.NewCipher(sum[:])
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+func TestEncryption(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if err := p.SetEncryption("user", "owner", AllPermissions, RC4); err != nil {
+		t.Fatal(err)
+	}
+	p.WriteInfo("confidential", time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC))
+	var content ContentStream
+	content.Append("BT\n/F1 12 Tf\n(secret) Tj\nET\n")
+	page, _ := p.WritePage(A4.Width, A4.Height, &content)
+	p.AddBookmark("Secret chapter", page)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Errorf("got header %q, want %%PDF-1.4", data[:9])
+	}
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("confidential")) || bytes.Contains(data, []byte("(D:")) {
+		t.Error("plain text found in encrypted document")
+	}
+
+	key, encrypt := rc4Key(t, data, "user")
+	for _, want := range []string{"/Filter /Standard", "/V 2", "/R 3", "/Length 128", "/P -4"} {
+		if !bytes.Contains(encrypt.dict, []byte(want)) {
+			t.Errorf("encryption dictionary missing %q:\n%s", want, encrypt.dict)
+		}
+	}
+	objs := readObjects(t, data)
+	if got := rc4Decrypt(key, page+1, objs[page].stream); string(got) != content.buf.String() {
+		t.Errorf("decrypted content stream to %q, want %q", got, content.buf.String())
+	}
+	m := regexp.MustCompile(`/Title <([0-9a-f]+)>`).FindSubmatch(objs[INFO_ID-1].dict)
+	if m == nil {
+		t.Fatalf("no encrypted title in info dictionary:\n%s", objs[INFO_ID-1].dict)
+	}
+	title, _ := hex.DecodeString(string(m[1]))
+	if got := rc4Decrypt(key, INFO_ID, title); string(got) != "confidential" {
+		t.Errorf("decrypted title to %q, want %q", got, "confidential")
+	}
+
+	if err := p.SetEncryption("user", "", AllPermissions, RC4); err == nil {
+		t.Error("expected error setting encryption after writing")
+	}
+}
+
+func aesDecrypt(t *testing.T, key, data []byte) []byte {
+	if len(data) < 32 || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("invalid AES data length %d", len(data))
+	}
+	c, _ := aes.NewCipher(key)
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(c, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	n := int(out[len(out)-1])
+	if n == 0 || n > aes.BlockSize {
+		t.Fatalf("invalid padding %d", n)
+	}
+	return out[:len(out)-n]
+}
+
+func TestAESEncryption(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if err := p.SetEncryption("usér", "owner", AllPermissions, AES256); err != nil {
+		t.Fatal(err)
+	}
+	p.WriteInfo("confidential", time.Now())
+	var content ContentStream
+	content.Append("BT\n/F1 12 Tf\n(secret) Tj\nET\n")
+	page, _ := p.WritePage(A4.Width, A4.Height, &content)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("%PDF-2.0\n")) {
+		t.Errorf("got header %q, want %%PDF-2.0", data[:9])
+	}
+	m := encryptRx.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing /Encrypt in trailer")
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	objs := readObjects(t, data)
+	encrypt := objs[id-1]
+	for _, want := range []string{"/V 5", "/R 6", "/CFM /AESV3", "/StmF /StdCF", "/StrF /StdCF", "/P -4"} {
+		if !bytes.Contains(encrypt.dict, []byte(want)) {
+			t.Errorf("encryption dictionary missing %q:\n%s", want, encrypt.dict)
+		}
+	}
+	e := encryptEntries(encrypt)
+	if len(e["U"]) != 48 || len(e["O"]) != 48 || len(e["UE"]) != 32 || len(e["OE"]) != 32 || len(e["Perms"]) != 16 {
+		t.Fatalf("invalid encryption dictionary:\n%s", encrypt.dict)
+	}
+
+	// authenticate both passwords, and recover the file key
+	if !bytes.Equal(hashR6("usér", e["U"][32:40], nil), e["U"][:32]) {
+		t.Fatal("user password does not match /U")
+	}
+	if !bytes.Equal(hashR6("owner", e["O"][32:40], e["U"]), e["O"][:32]) {
+		t.Fatal("owner password does not match /O")
+	}
+	key := make([]byte, 32)
+	c, _ := aes.NewCipher(hashR6("usér", e["U"][40:48], nil))
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(key, e["UE"])
+	ownerKey := make([]byte, 32)
+	c, _ = aes.NewCipher(hashR6("owner", e["O"][40:48], e["U"]))
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(ownerKey, e["OE"])
+	if !bytes.Equal(key, ownerKey) {
+		t.Error("user and owner passwords give different keys")
+	}
+	perms := make([]byte, 16)
+	c, _ = aes.NewCipher(key)
+	c.Decrypt(perms, e["Perms"])
+	if string(perms[9:12]) != "adb" || int32(binary.LittleEndian.Uint32(perms)) != -4 {
+		t.Errorf("invalid /Perms %x", perms)
+	}
+
+	if got := aesDecrypt(t, key, objs[page].stream); string(got) != content.buf.String() {
+		t.Errorf("decrypted content stream to %q, want %q", got, content.buf.String())
+	}
+	m = regexp.MustCompile(`/Title <([0-9a-f]+)>`).FindSubmatch(objs[INFO_ID-1].dict)
+	if m == nil {
+		t.Fatalf("no encrypted title in info dictionary:\n%s", objs[INFO_ID-1].dict)
+	}
+	title, _ := hex.DecodeString(string(m[1]))
+	if got := aesDecrypt(t, key, title); string(got) != "confidential" {
+		t.Errorf("decrypted title to %q, want %q", got, "confidential")
+	}
+}
+
+// aesKey returns the file key of a document encrypted with AES-256,
+// recovered with the user password pwd.
+func aesKey(t *testing.T, data []byte, pwd string) []byte {
+	m := encryptRx.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing /Encrypt in trailer")
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	e := encryptEntries(readObjects(t, data)[id-1])
+	if len(e["U"]) != 48 || len(e["UE"]) != 32 {
+		t.Fatal("invalid encryption dictionary")
+	}
+	key := make([]byte, 32)
+	c, _ := aes.NewCipher(hashR6(pwd, e["U"][40:48], nil))
+	cipher.NewCBCDecrypter(c, make([]byte, aes.BlockSize)).CryptBlocks(key, e["UE"])
+	return key
+}
+
+func TestEncryptedTextField(t *testing.T) {
+	for _, c := range []Cipher{RC4, AES256} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if err := p.SetEncryption("user", "owner", AllPermissions, c); err != nil {
+			t.Fatal(err)
+		}
+		page, _ := p.WriteBlankPage(A4)
+		if err := p.AddTextField(page, "name", [4]Length{72, 72, 288, 96}, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		field := findObject(t, data, "/FT /Tx")
+		m := regexp.MustCompile(`/DA <([0-9a-f]+)>`).FindSubmatch(field.dict)
+		if m == nil {
+			t.Fatalf("cipher %v: no encrypted /DA in field:\n%s", c, field.dict)
+		}
+		da, _ := hex.DecodeString(string(m[1]))
+		var got []byte
+		if c == RC4 {
+			key, _ := rc4Key(t, data, "user")
+			got = rc4Decrypt(key, field.id, da)
+		} else {
+			got = aesDecrypt(t, aesKey(t, data, "user"), da)
+		}
+		if want := "/F1 10 Tf 0 g"; string(got) != want {
+			t.Errorf("cipher %v: decrypted /DA to %q, want %q", c, got, want)
+		}
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	for _, test := range []struct {
+		perms Permissions
+		p     int32
+	}{
+		{AllPermissions, -4},
+		{PermPrint, -3900},
+		{PermPrint | PermPrintHighRes, -1852},
+		{0, -3904},
+		{^Permissions(0), -4}, // reserved bits are ignored
+	} {
+		if got := test.perms.value(); got != test.p {
+			t.Errorf("permissions %#x: got /P %d, want %d", uint32(test.perms), got, test.p)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if err := p.SetEncryption("", "owner", PermPrint, RC4); err != nil {
+		t.Fatal(err)
+	}
+	p.WriteBlankPage(A4)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, encrypt := rc4Key(t, buf.Bytes(), ""); !bytes.Contains(encrypt.dict, []byte("/P -3900\n")) {
+		t.Errorf("got encryption dictionary %s, want /P -3900", encrypt.dict)
+	}
+}
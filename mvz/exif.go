@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// This file implements reading the resolution and orientation of
+// JPEG images from their EXIF data.
+
+// exifInfo holds the EXIF tags used to lay out image pages.
+type exifInfo struct {
+	xdpi, ydpi  float64 // resolution, 0 if unknown
+	orientation int     // 1 to 8, 0 if unknown
+}
+
+// EXIF tags of the first image file directory (IFD0).
+const (
+	exifOrientation    = 0x0112
+	exifXResolution    = 0x011a
+	exifYResolution    = 0x011b
+	exifResolutionUnit = 0x0128
+)
+
+// jpegEXIF returns the EXIF information of a JPEG stream. Missing
+// or malformed EXIF data gives an empty exifInfo.
+func jpegEXIF(data []byte) exifInfo {
+	segs, err := jpegSegments(data)
+	if err != nil {
+		return exifInfo{}
+	}
+	for _, seg := range segs {
+		if seg.marker == 0xe1 && bytes.HasPrefix(seg.payload, []byte("Exif\x00\x00")) {
+			return parseEXIF(seg.payload[6:])
+		}
+	}
+	return exifInfo{}
+}
+
+// parseEXIF reads the tags of IFD0 from TIFF-structured EXIF data.
+func parseEXIF(tiff []byte) exifInfo {
+	var info exifInfo
+	if len(tiff) < 8 {
+		return info
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return info
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return info
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	// rational returns the value of a RATIONAL entry, stored
+	// at the offset held by the entry
+	rational := func(entry []byte) float64 {
+		off := int(order.Uint32(entry[8:]))
+		if off < 0 || off+8 > len(tiff) {
+			return 0
+		}
+		num, den := order.Uint32(tiff[off:]), order.Uint32(tiff[off+4:])
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	unit := 2 // inches
+	for i := 0; i < n; i++ {
+		off := ifd + 2 + 12*i
+		if off+12 > len(tiff) {
+			break
+		}
+		entry := tiff[off : off+12]
+		switch order.Uint16(entry) {
+		case exifOrientation:
+			if v := int(order.Uint16(entry[8:])); v >= 1 && v <= 8 {
+				info.orientation = v
+			}
+		case exifXResolution:
+			info.xdpi = rational(entry)
+		case exifYResolution:
+			info.ydpi = rational(entry)
+		case exifResolutionUnit:
+			unit = int(order.Uint16(entry[8:]))
+		}
+	}
+	switch unit {
+	case 2:
+	case 3: // centimeters
+		info.xdpi *= 2.54
+		info.ydpi *= 2.54
+	default: // no absolute unit
+		info.xdpi, info.ydpi = 0, 0
+	}
+	return info
+}
+
+// transform returns how to display an image of orientation o:
+// whether to mirror it left to right, then the clockwise angle by
+// which to rotate it, in degrees.
+func (o exifInfo) transform() (mirror bool, rotate int) {
+	switch o.orientation {
+	case 2:
+		return true, 0
+	case 3:
+		return false, 180
+	case 4:
+		return true, 180
+	case 5:
+		return true, 270
+	case 6:
+		return false, 90
+	case 7:
+		return true, 90
+	case 8:
+		return false, 270
+	}
+	return false, 0
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// exifSegment returns an APP1 segment holding EXIF data with the
+// given orientation and resolution, in the given byte order.
+func exifSegment(order binary.ByteOrder, orientation, xres, yres, unit int) []byte {
+	tiff := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, []uint16{42})
+	binary.Write(tiff, order, []uint32{8})
+	// 4 entries, then no next IFD, then the rationals
+	rationals := 8 + 2 + 4*12 + 4
+	binary.Write(tiff, order, uint16(4))
+	binary.Write(tiff, order, []uint16{exifOrientation, 3})
+	binary.Write(tiff, order, []uint32{1})
+	binary.Write(tiff, order, []uint16{uint16(orientation), 0})
+	binary.Write(tiff, order, []uint16{exifXResolution, 5})
+	binary.Write(tiff, order, []uint32{1, uint32(rationals)})
+	binary.Write(tiff, order, []uint16{exifYResolution, 5})
+	binary.Write(tiff, order, []uint32{1, uint32(rationals + 8)})
+	binary.Write(tiff, order, []uint16{exifResolutionUnit, 3})
+	binary.Write(tiff, order, []uint32{1})
+	binary.Write(tiff, order, []uint16{uint16(unit), 0})
+	binary.Write(tiff, order, uint32(0))
+	binary.Write(tiff, order, []uint32{uint32(xres), 1, uint32(yres), 1})
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	seg := []byte{0xff, 0xe1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	return append(seg, payload...)
+}
+
+// exifJPEG returns the markers of a JPEG stream with EXIF data.
+func exifJPEG(w, h int, exif []byte) []byte {
+	hdr := jpegHeader(w, h, 3, false)
+	return append(append(hdr[:2:2], exif...), hdr[2:]...)
+}
+
+func TestEXIF(t *testing.T) {
+	for _, tc := range []struct {
+		seg  []byte
+		want exifInfo
+	}{
+		{exifSegment(binary.BigEndian, 6, 300, 300, 2), exifInfo{300, 300, 6}},
+		{exifSegment(binary.LittleEndian, 3, 200, 100, 2), exifInfo{200, 100, 3}},
+		{exifSegment(binary.LittleEndian, 1, 100, 100, 3), exifInfo{254, 254, 1}},
+		// no absolute unit, invalid orientation
+		{exifSegment(binary.BigEndian, 9, 300, 300, 1), exifInfo{}},
+		{nil, exifInfo{}},
+		// truncated
+		{exifSegment(binary.BigEndian, 6, 300, 300, 2)[:30], exifInfo{}},
+	} {
+		if len(tc.seg) > 4 {
+			// fix the segment length of truncated data
+			n := len(tc.seg) - 2
+			tc.seg[2], tc.seg[3] = byte(n>>8), byte(n)
+		}
+		if got := jpegEXIF(exifJPEG(30, 20, tc.seg)); got != tc.want {
+			t.Errorf("got %+v, want %+v", got, tc.want)
+		}
+	}
+}
+
+func TestEXIFPage(t *testing.T) {
+	for _, tc := range []struct {
+		orientation int
+		rotate      string
+		mirrored    bool
+	}{
+		{1, "", false},
+		{2, "", true},
+		{3, "/Rotate 180", false},
+		{4, "/Rotate 180", true},
+		{5, "/Rotate 270", true},
+		{6, "/Rotate 90", false},
+		{7, "/Rotate 90", true},
+		{8, "/Rotate 270", false},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		data := exifJPEG(600, 300, exifSegment(binary.BigEndian, tc.orientation, 300, 300, 2))
+		page, err := p.WriteJPEGPageBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		dict := string(readObjects(t, buf.Bytes())[page-1].dict)
+		// 600x300 pixels at 300 DPI
+		if !strings.Contains(dict, "/MediaBox [0 0 144.00 72.00]") {
+			t.Errorf("orientation %d: wrong page size in %s", tc.orientation, dict)
+		}
+		if got := strings.Contains(dict, "/Rotate"); got != (tc.rotate != "") || !strings.Contains(dict, tc.rotate) {
+			t.Errorf("orientation %d: want %q in %s", tc.orientation, tc.rotate, dict)
+		}
+		want := "144.00 0 0 72.00 0.00 0.00 cm"
+		if tc.mirrored {
+			want = "-144.00 0 0 72.00 144.00 0.00 cm"
+		}
+		if c := pageContents(t, buf.Bytes(), page); !bytes.Contains(c, []byte(want)) {
+			t.Errorf("orientation %d: got contents %q, want %q", tc.orientation, c, want)
+		}
+	}
+
+	// without EXIF, the default resolution and orientation
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteJPEGPageBytes(jpegHeader(600, 300, 3, false))
+	p.Flush()
+	if dict := readObjects(t, buf.Bytes())[page-1].dict; !bytes.Contains(dict, []byte("/MediaBox [0 0 288.00 144.00]")) || bytes.Contains(dict, []byte("/Rotate")) {
+		t.Errorf("wrong page without EXIF: %s", dict)
+	}
+}
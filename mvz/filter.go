@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/ascii85"
+	"strings"
+)
+
+// This file implements stream filters.
+
+// A streamFilter is a filter applied to stream data.
+type streamFilter struct {
+	name  string // name of the decoding filter, like /FlateDecode
+	parms string // decoding parameters, if any
+	// encode encodes data, nil if the data is encoded already
+	encode func(data []byte) ([]byte, error)
+}
+
+var (
+	flateFilter   = streamFilter{name: "/FlateDecode", encode: deflate}
+	ascii85Filter = streamFilter{name: "/ASCII85Decode", encode: ascii85Encode}
+)
+
+// contentFilters returns the filters of content streams.
+func (p *PDFWriter) contentFilters() []streamFilter {
+	if p.Compress {
+		return []streamFilter{flateFilter}
+	}
+	return nil
+}
+
+// streamFilters returns filters, in the order the data is encoded,
+// followed by ASCII85 encoding if enabled.
+func (p *PDFWriter) streamFilters(filters []streamFilter) []streamFilter {
+	if p.ASCII85 {
+		filters = append(filters[:len(filters):len(filters)], ascii85Filter)
+	}
+	return filters
+}
+
+// printFilters prints the /Filter and /DecodeParms entries of
+// data encoded with filters, in order: decoding takes them in
+// reverse.
+func (p *PDFWriter) printFilters(filters []streamFilter) {
+	if len(filters) == 0 {
+		return
+	}
+	names := make([]string, len(filters))
+	parms := make([]string, len(filters))
+	hasParms := false
+	for i, f := range filters {
+		j := len(filters) - 1 - i
+		names[j], parms[j] = f.name, f.parms
+		if f.parms == "" {
+			parms[j] = "null"
+		} else {
+			hasParms = true
+		}
+	}
+	p.printf("/Filter [ %s ]", strings.Join(names, " "))
+	if hasParms {
+		p.printf("/DecodeParms [ %s ]", strings.Join(parms, " "))
+	}
+}
+
+// encodeStream prints the filter entries of a stream, and returns
+// data encoded with filters, then as ASCII85 if enabled.
+func (p *PDFWriter) encodeStream(data []byte, filters ...streamFilter) []byte {
+	filters = p.streamFilters(filters)
+	p.printFilters(filters)
+	for _, f := range filters {
+		if f.encode == nil {
+			continue
+		}
+		var err error
+		if data, err = f.encode(data); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	return data
+}
+
+// ascii85Encode encodes data in ASCII base-85, terminated by ~>.
+// Lines are limited to 80 characters.
+func ascii85Encode(data []byte) ([]byte, error) {
+	enc := make([]byte, ascii85.MaxEncodedLen(len(data)))
+	enc = enc[:ascii85.Encode(enc, data)]
+	buf := new(bytes.Buffer)
+	for len(enc) > 80 {
+		buf.Write(enc[:80])
+		buf.WriteByte('\n')
+		enc = enc[80:]
+	}
+	buf.Write(enc)
+	buf.WriteString("~>")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+// ascii85Decode decodes a stream up to its ~> terminator.
+func ascii85Decode(t *testing.T, data []byte) []byte {
+	end := bytes.Index(data, []byte("~>"))
+	if end < 0 {
+		t.Fatalf("missing ~> in ASCII85 stream")
+	}
+	out, err := ioutil.ReadAll(ascii85.NewDecoder(bytes.NewReader(data[:end])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestASCII85(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	jpg := new(bytes.Buffer)
+	if err := jpeg.Encode(jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.ASCII85 = true
+	if _, err := p.WriteJPEGPageConfig(image.Config{Width: 16, Height: 16}, jpg.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	if !bytes.Contains(obj.dict, []byte("/Filter [ /ASCII85Decode /DCTDecode ]")) {
+		t.Errorf("missing ASCII85Decode filter in %s", obj.dict)
+	}
+	m := lengthRx.FindSubmatch(obj.dict)
+	if n, _ := strconv.Atoi(string(m[1])); n != len(obj.stream) {
+		t.Errorf("/Length %d, stream has %d bytes", n, len(obj.stream))
+	}
+	for _, b := range obj.stream {
+		if b >= 0x80 {
+			t.Fatalf("binary byte %#x in ASCII85 stream", b)
+		}
+	}
+	if got := ascii85Decode(t, obj.stream); !bytes.Equal(got, jpg.Bytes()) {
+		t.Errorf("decoded stream differs from the JPEG data")
+	}
+}
+
+func TestChainedFilters(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.Compress = true
+	p.ASCII85 = true
+	var cs ContentStream
+	cs.Append("q\n1 0 0 1 72 72 cm\nQ")
+	page, err := p.WritePage(A4.Width, A4.Height, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, buf.Bytes())
+	m := refRx.FindSubmatch(contentsRx.Find(objs[page-1].dict))
+	id, _ := strconv.Atoi(string(m[1]))
+	obj := objs[id-1]
+	if !bytes.Contains(obj.dict, []byte("/Filter [ /ASCII85Decode /FlateDecode ]")) {
+		t.Errorf("wrong filters in %s", obj.dict)
+	}
+	z, err := zlib.NewReader(bytes.NewReader(ascii85Decode(t, obj.stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != cs.buf.String() {
+		t.Errorf("got content stream %q, want %q", got, cs.buf.String())
+	}
+
+	// parameters follow their filters, in decoding order
+	buf.Reset()
+	p, _ = newPDFWriter(buf, false)
+	p.printFilters([]streamFilter{{name: "/CCITTFaxDecode", parms: "<< /K -1 >>"}, ascii85Filter})
+	want := "/Filter [ /ASCII85Decode /CCITTFaxDecode ]\n/DecodeParms [ null << /K -1 >> ]\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file implements interactive forms (AcroForm).
+
+// Form fields are displayed in Helvetica, at this size.
+const fieldSize = 10
+
+// AddTextField adds an editable text field to page, in the area
+// rect given as [x0 y0 x1 y1], holding defaultValue until edited.
+// Field names must be unique in the document.
+func (p *PDFWriter) AddTextField(page PDFID, name string, rect [4]Length, defaultValue string) error {
+	pg, err := p.fieldPage(page, name, rect)
+	if err != nil {
+		return err
+	}
+	font, err := p.StandardFont("Helvetica")
+	if err != nil {
+		return err
+	}
+	id := p.startWidget(page, name, rect)
+	p.print("/FT /Tx")
+	p.printf("/V %s", p.str(defaultValue))
+	p.printf("/DV %s", p.str(defaultValue))
+	p.printf("/DA %s", p.str(fmt.Sprintf("/%s %d Tf 0 g", font.name, fieldSize)))
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	p.fields = append(p.fields, formField{name, id, font})
+	return p.err
+}
+
+// AddCheckbox adds a checkbox to page, in the area rect given as
+// [x0 y0 x1 y1], initially checked or not. Its value is /On when
+// checked, /Off otherwise.
+func (p *PDFWriter) AddCheckbox(page PDFID, name string, rect [4]Length, checked bool) error {
+	pg, err := p.fieldPage(page, name, rect)
+	if err != nil {
+		return err
+	}
+	w, h := rect[2]-rect[0], rect[3]-rect[1]
+	var on, off ContentStream
+	border := fmt.Sprintf("0 G 1 w 0.50 0.50 %.2f %.2f re S", w-1, h-1)
+	on.Append(border)
+	on.Append(fmt.Sprintf("%.2f w %.2f %.2f m %.2f %.2f l %.2f %.2f l S",
+		h/10, 0.2*w, 0.5*h, 0.4*w, 0.25*h, 0.8*w, 0.8*h))
+	off.Append(border)
+	onID, offID := p.writeAppearance(w, h, &on), p.writeAppearance(w, h, &off)
+	state := "/Off"
+	if checked {
+		state = "/On"
+	}
+	id := p.startWidget(page, name, rect)
+	p.print("/FT /Btn")
+	p.printf("/V %s", state)
+	p.printf("/AS %s", state)
+	p.printf("/AP << /N << /On %d 0 R /Off %d 0 R >> >>", onID, offID)
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	p.fields = append(p.fields, formField{name, id, nil})
+	return p.err
+}
+
+// fieldPage checks that a field can be added to page, and returns
+// the page.
+func (p *PDFWriter) fieldPage(page PDFID, name string, rect [4]Length) (*pdfPage, error) {
+	pg, err := p.page(page)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" || strings.ContainsRune(name, '.') {
+		return nil, fmt.Errorf("invalid field name %q", name)
+	}
+	for _, f := range p.fields {
+		if f.name == name {
+			return nil, fmt.Errorf("duplicate field %s", name)
+		}
+	}
+	if rect[2] <= rect[0] || rect[3] <= rect[1] {
+		return nil, fmt.Errorf("invalid rectangle %s", formatRect(rect))
+	}
+	return pg, nil
+}
+
+// startWidget starts the dictionary of a field and its widget
+// annotation, merged.
+func (p *PDFWriter) startWidget(page PDFID, name string, rect [4]Length) PDFID {
+	id, _ := p.startObj()
+	p.print("/Type /Annot")
+	p.print("/Subtype /Widget")
+	p.printf("/Rect %s", formatRect(rect))
+	p.print("/F 4") // print
+	p.printf("/P %d 0 R", page)
+	p.printf("/T %s", p.str(name))
+	return id
+}
+
+// writeAppearance writes an appearance stream of size w×h drawn
+// by cs.
+func (p *PDFWriter) writeAppearance(w, h Length, cs *ContentStream) PDFID {
+	id, _ := p.startObj()
+	p.print("/Type /XObject")
+	p.print("/Subtype /Form")
+	p.printf("/BBox [0 0 %.2f %.2f]", w, h)
+	if len(cs.res) > 0 {
+		p.print("/Resources <<")
+		p.writeResources(cs.res)
+		p.print(">>")
+	}
+	p.writeStream(p.encodeStream(cs.buf.Bytes(), p.contentFilters()...))
+	return id
+}
+
+// A formField is a field of the interactive form.
+type formField struct {
+	name string
+	id   PDFID
+	font *Font // of the default appearance, nil for checkboxes
+}
+
+// acroForm returns the interactive form dictionary of the catalog.
+// Viewers draw the appearance of text fields.
+func (p *PDFWriter) acroForm() string {
+	var fields strings.Builder
+	fonts := make(map[*Font]bool)
+	var dr strings.Builder
+	for _, f := range p.fields {
+		fmt.Fprintf(&fields, "%d 0 R ", f.id)
+		if f.font != nil && !fonts[f.font] {
+			fonts[f.font] = true
+			fmt.Fprintf(&dr, "/%s %d 0 R ", f.font.name, f.font.id)
+		}
+	}
+	form := fmt.Sprintf("<< /Fields [ %s]", fields.String())
+	if len(fonts) > 0 {
+		form += fmt.Sprintf(" /NeedAppearances true /DR << /Font << %s>> >>", dr.String())
+	}
+	if len(p.signatures) > 0 {
+		form += " /SigFlags 3" // signatures exist, append only
+	}
+	return form + " >>"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestTextField(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(Letter)
+	if err := p.AddTextField(page, "from", [4]Length{72, 700, 300, 720}, "Sender"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTextField(page, "to", [4]Length{72, 660, 300, 680}, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"to", "", "a.b"} {
+		if err := p.AddTextField(page, bad, [4]Length{72, 620, 300, 640}, ""); err == nil {
+			t.Errorf("expected error for field name %q", bad)
+		}
+	}
+	if err := p.AddTextField(page, "empty", [4]Length{72, 620, 72, 640}, ""); err == nil {
+		t.Error("expected error for empty rectangle")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	from := findObject(t, buf.Bytes(), "/T (from)")
+	to := findObject(t, buf.Bytes(), "/T (to)")
+	for _, want := range []string{"/Subtype /Widget", "/FT /Tx", "/V (Sender)", "/DA (/F1 10 Tf 0 g)",
+		fmt.Sprintf("/P %d 0 R", page), "/Rect [72.00 700.00 300.00 720.00]"} {
+		if !bytes.Contains(from.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, from.dict)
+		}
+	}
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	font := findObject(t, buf.Bytes(), "/BaseFont /Helvetica")
+	want := fmt.Sprintf("/AcroForm << /Fields [ %d 0 R %d 0 R ] /NeedAppearances true /DR << /Font << /F1 %d 0 R >> >> >>", from.id, to.id, font.id)
+	if !bytes.Contains(catalog.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, catalog.dict)
+	}
+	annots := fmt.Sprintf("/Annots [ %d 0 R %d 0 R ]", from.id, to.id)
+	if pg := readObjects(t, buf.Bytes())[page-1]; !bytes.Contains(pg.dict, []byte(annots)) {
+		t.Errorf("missing %s in %s", annots, pg.dict)
+	}
+}
+
+var appearanceRx = regexp.MustCompile(`/AP << /N << /On (\d+) 0 R /Off (\d+) 0 R >> >>`)
+
+func TestCheckbox(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(Letter)
+	if err := p.AddCheckbox(page, "fragile", [4]Length{72, 700, 84, 712}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddCheckbox(page, "express", [4]Length{72, 680, 84, 692}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddCheckbox(page, "fragile", [4]Length{72, 660, 84, 672}, false); err == nil {
+		t.Error("expected error for duplicate field")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	var ids []PDFID
+	for _, tc := range []struct{ name, state string }{{"fragile", "/On"}, {"express", "/Off"}} {
+		box := findObject(t, buf.Bytes(), "/T ("+tc.name+")")
+		ids = append(ids, box.id)
+		for _, want := range []string{"/FT /Btn", "/V " + tc.state, "/AS " + tc.state} {
+			if !bytes.Contains(box.dict, []byte(want)) {
+				t.Errorf("%s: missing %s in %s", tc.name, want, box.dict)
+			}
+		}
+		m := appearanceRx.FindSubmatch(box.dict)
+		if m == nil {
+			t.Fatalf("%s: no appearance states in %s", tc.name, box.dict)
+		}
+		on, _ := strconv.Atoi(string(m[1]))
+		off, _ := strconv.Atoi(string(m[2]))
+		if ap := objs[on-1]; !bytes.Contains(ap.dict, []byte("/Subtype /Form")) ||
+			!bytes.Contains(ap.dict, []byte("/BBox [0 0 12.00 12.00]")) ||
+			!bytes.Contains(ap.stream, []byte("1.20 w 2.40 6.00 m 4.80 3.00 l 9.60 9.60 l S")) {
+			t.Errorf("%s: wrong On appearance %s %q", tc.name, ap.dict, ap.stream)
+		}
+		if ap := objs[off-1]; bytes.Contains(ap.stream, []byte(" l ")) {
+			t.Errorf("%s: check mark in Off appearance %q", tc.name, ap.stream)
+		}
+	}
+	want := fmt.Sprintf("/AcroForm << /Fields [ %d 0 R %d 0 R ] >>", ids[0], ids[1])
+	if catalog := objs[CATALOG_ID-1]; !bytes.Contains(catalog.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, catalog.dict)
+	}
+}
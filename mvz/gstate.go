@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+// This file implements graphics state parameter dictionaries,
+// which control transparency.
+
+// ExtGStateOpts are the parameters of a graphics state registered
+// with RegisterExtGState.
+type ExtGStateOpts struct {
+	// FillAlpha and StrokeAlpha are the opacities of filled and
+	// stroked painting, including text, from 0 (transparent) to
+	// 1 (opaque), clamped to that range. The zero value is fully
+	// transparent: set both.
+	FillAlpha, StrokeAlpha float64
+}
+
+// A GStateRef refers to a graphics state registered with
+// RegisterExtGState.
+type GStateRef struct {
+	id PDFID
+}
+
+// RegisterExtGState writes a graphics state dictionary with the
+// given parameters, to be set with (*Canvas).SetGState. Registering
+// the same parameters again returns the same state. Transparency
+// requires PDF 1.4.
+func (p *PDFWriter) RegisterExtGState(opts ExtGStateOpts) (GStateRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registerExtGState(opts)
+}
+
+func (p *PDFWriter) registerExtGState(opts ExtGStateOpts) (GStateRef, error) {
+	opts.FillAlpha = math.Max(0, math.Min(1, opts.FillAlpha))
+	opts.StrokeAlpha = math.Max(0, math.Min(1, opts.StrokeAlpha))
+	if id := p.gstates[opts]; id != 0 {
+		return GStateRef{id}, nil
+	}
+	p.requireVersion("1.4")
+	id, _ := p.startObj()
+	p.print("/Type /ExtGState")
+	p.printf("/ca %s /CA %s", formatNum(opts.FillAlpha), formatNum(opts.StrokeAlpha))
+	if err := p.endObj(); err != nil {
+		return GStateRef{}, err
+	}
+	if p.gstates == nil {
+		p.gstates = make(map[ExtGStateOpts]PDFID)
+	}
+	p.gstates[opts] = id
+	return GStateRef{id}, nil
+}
+
+// transparent reports whether the document uses transparency.
+func (p *PDFWriter) transparent() bool {
+	if p.softMasked {
+		return true
+	}
+	for opts := range p.gstates {
+		if opts.FillAlpha < 1 || opts.StrokeAlpha < 1 {
+			return true
+		}
+	}
+	return false
+}
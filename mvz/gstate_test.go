@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtGState(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(Letter)
+	half, err := p.RegisterExtGState(ExtGStateOpts{FillAlpha: 0.5, StrokeAlpha: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := p.RegisterExtGState(ExtGStateOpts{FillAlpha: 0.5, StrokeAlpha: 1}); again != half {
+		t.Errorf("same state registered twice: %v, %v", half, again)
+	}
+	c, _ := p.Canvas(page)
+	c.SetGState(half)
+	c.Rect(72, 72, INCH, INCH)
+	c.Fill()
+	c.SetGState(GStateRef{})
+	c.Rect(72, 72, INCH, INCH)
+	c.Stroke()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	if gs := objs[half.id-1].dict; !bytes.Contains(gs, []byte("/Type /ExtGState\n/ca 0.5 /CA 1")) {
+		t.Errorf("wrong graphics state %s", gs)
+	}
+	res := fmt.Sprintf("/ExtGState << /GS0 %d 0 R >>", half.id)
+	if !bytes.Contains(objs[page-1].dict, []byte(res)) {
+		t.Errorf("missing %s in page %s", res, objs[page-1].dict)
+	}
+	want := "q\n/GS0 gs\n72.00 72.00 72.00 72.00 re\nf\nQ\n" +
+		"q\n72.00 72.00 72.00 72.00 re\nS\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	if !strings.Contains(buf.String(), "/Version /1.4") {
+		t.Error("transparency does not require PDF 1.4")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// This file implements an sRGB ICC profile, declared as the
+// output intent of documents.
+
+const srgbName = "sRGB IEC61966-2.1"
+
+var (
+	srgbOnce sync.Once
+	srgbData []byte
+)
+
+// srgbProfile returns a version 2 ICC display profile of the
+// sRGB color space, with D50-adapted primaries and a 1024-entry
+// tone curve shared by the three channels.
+func srgbProfile() []byte {
+	srgbOnce.Do(func() { srgbData = buildSRGBProfile() })
+	return srgbData
+}
+
+func buildSRGBProfile() []byte {
+	xyz := func(x, y, z float64) []byte {
+		b := bytes.NewBufferString("XYZ \x00\x00\x00\x00")
+		for _, v := range []float64{x, y, z} {
+			binary.Write(b, binary.BigEndian, int32(math.Round(v*65536)))
+		}
+		return b.Bytes()
+	}
+	desc := bytes.NewBufferString("desc\x00\x00\x00\x00")
+	binary.Write(desc, binary.BigEndian, uint32(len(srgbName)+1))
+	desc.WriteString(srgbName + "\x00")
+	desc.Write(make([]byte, 4+4+2+1+67)) // no Unicode or ScriptCode names
+	curv := bytes.NewBufferString("curv\x00\x00\x00\x00")
+	const n = 1024
+	binary.Write(curv, binary.BigEndian, uint32(n))
+	for i := 0; i < n; i++ {
+		v := float64(i) / (n - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		binary.Write(curv, binary.BigEndian, uint16(math.Round(v*65535)))
+	}
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc.Bytes()},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(0.4360, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curv.Bytes()},
+		{"gTRC", nil}, // same as rTRC
+		{"bTRC", nil},
+	}
+
+	// header, then tag table, then tag data aligned on 4 bytes
+	var table, data bytes.Buffer
+	off := 128 + 4 + 12*len(tags)
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	var last, size uint32
+	for _, tag := range tags {
+		if tag.data != nil {
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+			last, size = uint32(off+data.Len()), uint32(len(tag.data))
+			data.Write(tag.data)
+		}
+		table.WriteString(tag.sig)
+		binary.Write(&table, binary.BigEndian, [2]uint32{last, size})
+	}
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(off+data.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntrRGB XYZ ")
+	binary.BigEndian.PutUint16(header[24:], 1998) // creation date
+	binary.BigEndian.PutUint16(header[26:], 2)
+	binary.BigEndian.PutUint16(header[28:], 9)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1, 0.8249)[8:]) // D50 illuminant
+	return append(append(header, table.Bytes()...), data.Bytes()...)
+}
+
+// SetOutputIntentSRGB declares sRGB as the output intent of the
+// document, embedding its ICC profile: device RGB colors are then
+// to be interpreted as sRGB.
+func (p *PDFWriter) SetOutputIntentSRGB() error {
+	if p.outputProfile != 0 {
+		return nil
+	}
+	p.requireVersion("1.4")
+	id, _ := p.startObj()
+	p.print("/N 3")
+	p.writeStream(p.encodeStream(srgbProfile(), p.contentFilters()...))
+	p.outputProfile = id
+	return p.err
+}
+
+// outputIntents returns the output intents array of the catalog.
+func (p *PDFWriter) outputIntents() string {
+	return "[ << /Type /OutputIntent /S /GTS_PDFA1" +
+		" /OutputConditionIdentifier " + p.str(srgbName) +
+		" /Info " + p.str(srgbName) +
+		fmt.Sprintf(" /DestOutputProfile %d 0 R >> ]", p.outputProfile)
+}
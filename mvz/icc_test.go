@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestSRGBProfile(t *testing.T) {
+	icc := srgbProfile()
+	if n := binary.BigEndian.Uint32(icc); int(n) != len(icc) {
+		t.Errorf("profile size is %d, header says %d", len(icc), n)
+	}
+	if string(icc[36:40]) != "acsp" || string(icc[12:24]) != "mntrRGB XYZ " {
+		t.Errorf("bad profile header % x", icc[:40])
+	}
+	// every tag lies within the profile
+	count := binary.BigEndian.Uint32(icc[128:])
+	for i := 0; i < int(count); i++ {
+		tag := icc[132+12*i:]
+		off, size := binary.BigEndian.Uint32(tag[4:]), binary.BigEndian.Uint32(tag[8:])
+		if off%4 != 0 || int(off+size) > len(icc) {
+			t.Errorf("tag %s at %d, size %d, is misplaced", tag[:4], off, size)
+		}
+	}
+}
+
+func TestOutputIntent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if err := p.SetOutputIntentSRGB(); err != nil {
+		t.Fatal(err)
+	}
+	p.WriteBlankPage(A4)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, buf.Bytes())
+	profile := findObject(t, buf.Bytes(), "/N 3")
+	if len(profile.stream) != len(srgbProfile()) {
+		t.Errorf("profile stream has %d bytes, want %d", len(profile.stream), len(srgbProfile()))
+	}
+	want := fmt.Sprintf("/OutputIntents [ << /Type /OutputIntent /S /GTS_PDFA1"+
+		" /OutputConditionIdentifier (sRGB IEC61966-2.1) /Info (sRGB IEC61966-2.1)"+
+		" /DestOutputProfile %d 0 R >> ]", profile.id)
+	if catalog := objs[CATALOG_ID-1]; !bytes.Contains(catalog.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, catalog.dict)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.4")) {
+		t.Errorf("output intents require PDF 1.4, got header %q", buf.Bytes()[:8])
+	}
+}
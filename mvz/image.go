@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// This file implements conversion of decoded images
+// to raw PDF image samples.
+
+var imageSignatures = []struct {
+	format, magic string
+}{
+	{"jpeg", "\xff\xd8\xff"},
+	{"png", "\x89PNG\r\n\x1a\n"},
+	{"gif", "GIF8"},
+	{"bmp", "BM"},
+	{"tiff", "II*\x00"},
+	{"tiff", "MM\x00*"},
+}
+
+// imageFormat guesses the format of encoded image data
+// from its first bytes. It returns "" if the format is unknown.
+func imageFormat(data []byte) string {
+	for _, sig := range imageSignatures {
+		if bytes.HasPrefix(data, []byte(sig.magic)) {
+			return sig.format
+		}
+	}
+	return ""
+}
+
+// rawSamples returns the samples of img, in the returned color space.
+func rawSamples(img image.Image) (colorSpace string, samples []byte, err error) {
+	switch m := img.(type) {
+	case *image.Paletted:
+		return indexedColorSpace(m.Palette), palettedPixels(m), nil
+	case *image.Gray, *image.Gray16:
+		return "/DeviceGray", grayPixels(m), nil
+	case *image.CMYK:
+		return "/DeviceCMYK", cmykPixels(m), nil
+	}
+	pix, err := rgbPixels(img)
+	return "/DeviceRGB", pix, err
+}
+
+// invertedDecode returns the decode array inverting the samples
+// of an image in colorSpace with the given decode array, which
+// for all images written is either the default or an inversion.
+// Images with other color spaces are left as they are.
+func invertedDecode(colorSpace, decode string) string {
+	n := map[string]int{"/DeviceGray": 1, "/DeviceRGB": 3, "/DeviceCMYK": 4}[colorSpace]
+	if n == 0 {
+		return decode
+	}
+	if decode != "" {
+		return "" // inverted twice
+	}
+	return "[" + strings.TrimSpace(strings.Repeat("1 0 ", n)) + "]"
+}
+
+// indexedColorSpace returns an Indexed color space
+// whose lookup table is pal.
+func indexedColorSpace(pal color.Palette) string {
+	lookup := make([]byte, 0, 3*len(pal))
+	for _, c := range pal {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		lookup = append(lookup, nc.R, nc.G, nc.B)
+	}
+	return fmt.Sprintf("[/Indexed /DeviceRGB %d <%x>]", len(pal)-1, lookup)
+}
+
+// palettedPixels returns the 8-bit palette indices of img, row by row.
+func palettedPixels(img *image.Paletted) []byte {
+	r := img.Bounds()
+	out := make([]byte, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		out = append(out, img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]...)
+	}
+	return out
+}
+
+// grayPixels returns the 8-bit samples of a gray image, row by row.
+func grayPixels(img image.Image) []byte {
+	r := img.Bounds()
+	if m, ok := img.(*image.Gray); ok {
+		out := make([]byte, 0, r.Dx()*r.Dy())
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			out = append(out, m.Pix[m.PixOffset(r.Min.X, y):m.PixOffset(r.Max.X, y)]...)
+		}
+		return out
+	}
+	out := make([]byte, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			out = append(out, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return out
+}
+
+// cmykPixels returns the 8-bit CMYK samples of img, row by row.
+func cmykPixels(img *image.CMYK) []byte {
+	r := img.Bounds()
+	out := make([]byte, 0, 4*r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		out = append(out, img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]...)
+	}
+	return out
+}
+
+// rgbPixels flattens img to packed 8-bit RGB samples, row by row.
+// Alpha is dropped. Images other than RGBA and NRGBA, such as
+// decoded JPEG images, are converted pixel by pixel.
+func rgbPixels(img image.Image) ([]byte, error) {
+	r := img.Bounds()
+	out := make([]byte, 0, 3*r.Dx()*r.Dy())
+	switch m := img.(type) {
+	case *image.RGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(r.Min.X, y):m.PixOffset(r.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				if a := row[i+3]; a != 0xff && a != 0 {
+					// undo alpha premultiplication
+					c := color.NRGBAModel.Convert(color.RGBA{row[i], row[i+1], row[i+2], a}).(color.NRGBA)
+					out = append(out, c.R, c.G, c.B)
+					continue
+				}
+				out = append(out, row[i], row[i+1], row[i+2])
+			}
+		}
+	case *image.NRGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(r.Min.X, y):m.PixOffset(r.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				out = append(out, row[i], row[i+1], row[i+2])
+			}
+		}
+	default:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				out = append(out, c.R, c.G, c.B)
+			}
+		}
+	}
+	return out, nil
+}
+
+// genericAlpha is alphaPixels for other image types.
+func genericAlpha(img image.Image) []byte {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil
+	}
+	r := img.Bounds()
+	out := make([]byte, 0, r.Dx()*r.Dy())
+	opaque := true
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			out = append(out, byte(a>>8))
+			opaque = opaque && a == 0xffff
+		}
+	}
+	if opaque {
+		return nil
+	}
+	return out
+}
+
+// alphaPixels returns the 8-bit alpha samples of img,
+// or nil if it is fully opaque.
+func alphaPixels(img image.Image) []byte {
+	var pix []byte
+	var stride int
+	switch m := img.(type) {
+	case *image.RGBA:
+		pix, stride = m.Pix, m.Stride
+	case *image.NRGBA:
+		pix, stride = m.Pix, m.Stride
+	default:
+		return genericAlpha(img)
+	}
+	r := img.Bounds()
+	out := make([]byte, 0, r.Dx()*r.Dy())
+	opaque := true
+	for y := 0; y < r.Dy(); y++ {
+		row := pix[y*stride : y*stride+4*r.Dx()]
+		for i := 3; i < len(row); i += 4 {
+			out = append(out, row[i])
+			opaque = opaque && row[i] == 0xff
+		}
+	}
+	if opaque {
+		return nil
+	}
+	return out
+}
+
+// deflate compresses data in zlib format, as expected by FlateDecode.
+func deflate(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	z := zlib.NewWriter(buf)
+	if _, err := z.Write(data); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// imageSamples returns the decompressed samples of a FlateDecode image.
+func imageSamples(t *testing.T, obj pdfObject) []byte {
+	z, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPalettedPage(t *testing.T) {
+	pal := make(color.Palette, 16)
+	for i := range pal {
+		pal[i] = color.RGBA{byte(16 * i), byte(255 - 16*i), 0x80, 0xff}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 8, 4), pal)
+	for i := range img.Pix {
+		img.Pix[i] = byte(i % 16)
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if _, err := p.WritePNGPage(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	m := regexp.MustCompile(`/ColorSpace \[/Indexed /DeviceRGB (\d+) <([0-9a-f]*)>\]`).FindSubmatch(obj.dict)
+	if m == nil {
+		t.Fatalf("expected an Indexed color space, got %s", obj.dict)
+	}
+	if string(m[1]) != "15" {
+		t.Errorf("got hival %s, want 15", m[1])
+	}
+	lookup, err := hex.DecodeString(string(m[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookup) != 3*len(pal) {
+		t.Fatalf("lookup table has %d bytes, want %d", len(lookup), 3*len(pal))
+	}
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		got := lookup[3*i : 3*i+3]
+		if got[0] != byte(r>>8) || got[1] != byte(g>>8) || got[2] != byte(b>>8) {
+			t.Errorf("palette entry %d: got %v, want %v", i, got, c)
+		}
+	}
+	if samples := imageSamples(t, obj); !bytes.Equal(samples, img.Pix) {
+		t.Errorf("got samples %v, want %v", samples, img.Pix)
+	}
+}
+
+func TestAlphaMask(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 6, 5))
+	for i := range img.Pix {
+		img.Pix[i] = 0xc0
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	p, _ := NewPDFWriter(ioutil.Discard)
+	p.WritePNGPage(img)
+	if len(p.objects) != 3+3 {
+		t.Errorf("opaque image should not have a mask")
+	}
+
+	alpha := make([]byte, 0, 30)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = byte(8 * i)
+		alpha = append(alpha, img.Pix[i])
+	}
+	buf := new(bytes.Buffer)
+	p, _ = NewPDFWriter(buf)
+	if _, err := p.WritePNGPage(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, buf.Bytes())
+	im := findObject(t, buf.Bytes(), "/ColorSpace /DeviceRGB")
+	m := regexp.MustCompile(`/SMask (\d+) 0 R`).FindSubmatch(im.dict)
+	if m == nil {
+		t.Fatalf("missing /SMask in %s", im.dict)
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	mask := objs[id-1]
+	for _, s := range []string{"/Subtype /Image", "/Width 6\n/Height 5", "/ColorSpace /DeviceGray"} {
+		if !bytes.Contains(mask.dict, []byte(s)) {
+			t.Errorf("missing %s in mask %s", s, mask.dict)
+		}
+	}
+	if got := imageSamples(t, mask); !bytes.Equal(got, alpha) {
+		t.Errorf("got mask %v, want %v", got, alpha)
+	}
+}
+
+func TestInvert(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.Invert = true
+	gray := image.NewGray(image.Rect(0, 0, 4, 4))
+	if _, err := p.WritePNGPage(gray); err != nil {
+		t.Fatal(err)
+	}
+	rgba := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = byte(i)
+	}
+	if _, err := p.WritePNGPage(rgba); err != nil {
+		t.Fatal(err)
+	}
+	// already inverted by BlackIs1
+	if _, err := p.WriteBilevelPage(gray); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	im := findObject(t, buf.Bytes(), "/ColorSpace /DeviceGray\n/BitsPerComponent 8")
+	if !bytes.Contains(im.dict, []byte("/Decode [1 0]\n")) {
+		t.Errorf("missing inverted /Decode in %s", im.dict)
+	}
+	im = findObject(t, buf.Bytes(), "/ColorSpace /DeviceRGB")
+	if !bytes.Contains(im.dict, []byte("/Decode [1 0 1 0 1 0]\n")) {
+		t.Errorf("missing inverted /Decode in %s", im.dict)
+	}
+	m := regexp.MustCompile(`/SMask (\d+) 0 R`).FindSubmatch(im.dict)
+	if m == nil {
+		t.Fatalf("missing /SMask in %s", im.dict)
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	if mask := readObjects(t, buf.Bytes())[id-1]; bytes.Contains(mask.dict, []byte("/Decode [")) {
+		t.Errorf("soft mask is inverted: %s", mask.dict)
+	}
+	im = findObject(t, buf.Bytes(), "/CCITTFaxDecode")
+	if bytes.Contains(im.dict, []byte("/Decode [")) {
+		t.Errorf("bilevel image should not have /Decode: %s", im.dict)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// This file implements N-up imposition of images.
+
+// Impose writes pages of the given size holding images laid out in
+// a grid of cols by rows cells, filled left to right from the top.
+// Each image is scaled to fit within margin of its cell edges, and
+// centered. The last page holds the remaining images, if fewer.
+// Impose returns the pages written.
+func (p *PDFWriter) Impose(images []ImageRef, size PageSize, cols, rows int, margin Length) ([]PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cols <= 0 || rows <= 0 {
+		return nil, fmt.Errorf("invalid grid %dx%d", cols, rows)
+	}
+	cell := PageSize{size.Width / Length(cols), size.Height / Length(rows)}
+	n := cols * rows
+	var pages []PDFID
+	for start := 0; start < len(images); start += n {
+		end := start + n
+		if end > len(images) {
+			end = len(images)
+		}
+		var cs ContentStream
+		for i, ref := range images[start:end] {
+			x, y, w, h, err := fitImage(ref, cell, margin)
+			if err != nil {
+				return pages, err
+			}
+			x += Length(i%cols) * cell.Width
+			y += size.Height - Length(i/cols+1)*cell.Height
+			cs.DrawImage(ref, x, y, w, h)
+		}
+		page, err := p.writeContentPage(size.Width, size.Height, &cs)
+		if err != nil {
+			return pages, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestImpose(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var refs []ImageRef
+	for i := 0; i < 5; i++ {
+		// receipts: twice as tall as wide
+		img := image.NewNRGBA(image.Rect(0, 0, 50, 100))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{uint8(50 * i)}), image.ZP, draw.Src)
+		ref, err := p.RegisterImage(img, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	pages, err := p.Impose(refs, Letter, 2, 2, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d sheets, want 2", len(pages))
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cells are 306x396; images are 180x360 within 18pt margins
+	want := "q\n180.00 0 0 360.00 63.00 414.00 cm\n/I0 Do\nQ\n" +
+		"q\n180.00 0 0 360.00 369.00 414.00 cm\n/I1 Do\nQ\n" +
+		"q\n180.00 0 0 360.00 63.00 18.00 cm\n/I2 Do\nQ\n" +
+		"q\n180.00 0 0 360.00 369.00 18.00 cm\n/I3 Do\nQ\n"
+	if got := pageContents(t, buf.Bytes(), pages[0]); string(got) != want {
+		t.Errorf("got first sheet %q, want %q", got, want)
+	}
+	want = "q\n180.00 0 0 360.00 63.00 414.00 cm\n/I0 Do\nQ\n"
+	if got := pageContents(t, buf.Bytes(), pages[1]); string(got) != want {
+		t.Errorf("got last sheet %q, want %q", got, want)
+	}
+
+	if _, err := p.Impose(refs, Letter, 0, 2, 0); err == nil {
+		t.Error("expected error for empty grid")
+	}
+}
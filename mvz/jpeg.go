@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"io"
+)
+
+// This file implements parsing of JPEG markers, to learn
+// about an image without decoding it.
+
+// A jpegSegment is a marker segment of a JPEG stream.
+type jpegSegment struct {
+	marker  byte
+	payload []byte // without marker and length
+	end     int    // offset of the end of the segment
+}
+
+// jpegSegments splits the header of a JPEG stream into marker
+// segments, up to and including the first start of scan (SOS).
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG stream")
+	}
+	var segs []jpegSegment
+	i := 2
+	for {
+		if i >= len(data) || data[i] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+		// skip fill bytes
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i >= len(data) {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		marker := data[i]
+		i++
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8) {
+			// standalone markers
+			continue
+		}
+		if marker == 0xd9 {
+			return nil, fmt.Errorf("JPEG stream has no image data")
+		}
+		if i+2 > len(data) {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		n := int(data[i])<<8 | int(data[i+1])
+		if n < 2 || i+n > len(data) {
+			return nil, fmt.Errorf("invalid JPEG segment length at offset %d", i)
+		}
+		segs = append(segs, jpegSegment{marker: marker, payload: data[i+2 : i+n], end: i + n})
+		i += n
+		if marker == 0xda {
+			return segs, nil
+		}
+	}
+}
+
+// stripJPEG returns data, a JPEG stream or its header as returned
+// by readJPEGHeader, without the application segments and comments
+// that precede the image data, as chosen by essentialJPEGSegment.
+// Decoders do not need them, and they can hold large EXIF thumbnails.
+func stripJPEG(data []byte) []byte {
+	segs, err := jpegSegments(data)
+	if err != nil {
+		return data
+	}
+	out := []byte{0xff, 0xd8}
+	for _, seg := range segs {
+		if essentialJPEGSegment(seg) {
+			n := len(seg.payload) + 2
+			out = append(out, 0xff, seg.marker, byte(n>>8), byte(n))
+			out = append(out, seg.payload...)
+		}
+	}
+	return append(out, data[segs[len(segs)-1].end:]...)
+}
+
+// essentialJPEGSegment reports whether seg affects how the image
+// looks: all but application segments and comments, except for the
+// Adobe segment and ICC profiles, which tell how colors are encoded.
+func essentialJPEGSegment(seg jpegSegment) bool {
+	switch {
+	case seg.marker == 0xee:
+		return true
+	case seg.marker == 0xe2:
+		return bytes.HasPrefix(seg.payload, []byte("ICC_PROFILE\x00"))
+	case seg.marker >= 0xe0 && seg.marker <= 0xef, seg.marker == 0xfe:
+		return false
+	}
+	return true
+}
+
+// progressiveJPEG reports whether data, a JPEG stream or its header,
+// is a progressive JPEG image.
+func progressiveJPEG(data []byte) bool {
+	segs, err := jpegSegments(data)
+	if err != nil {
+		return false
+	}
+	for _, seg := range segs {
+		if isSOF(seg.marker) {
+			return seg.marker == 0xc2
+		}
+	}
+	return false
+}
+
+// Progressive JPEG images are transcoded at this quality.
+const transcodeQuality = 90
+
+// baselineJPEG returns data, a JPEG stream, re-encoded as baseline
+// JPEG if it is progressive and KeepProgressive is not set, with a
+// warning: some viewers cannot display progressive images.
+func (p *PDFWriter) baselineJPEG(data []byte) ([]byte, error) {
+	if p.KeepProgressive || !progressiveJPEG(data) {
+		return data, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot transcode progressive JPEG: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: transcodeQuality}); err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	p.warnings = append(p.warnings, fmt.Sprintf("transcoded %dx%d progressive JPEG image to baseline", b.Dx(), b.Dy()))
+	return buf.Bytes(), nil
+}
+
+// readJPEGHeader reads a JPEG stream from r up to and including
+// the first start of scan, for use with jpegSegments.
+func readJPEGHeader(r io.Reader) ([]byte, error) {
+	var hdr []byte
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		n, _ = io.ReadFull(r, b)
+		hdr = append(hdr, b[:n]...)
+		return b[:n]
+	}
+	if b := read(2); len(b) < 2 || b[0] != 0xff || b[1] != 0xd8 {
+		return nil, fmt.Errorf("not a JPEG stream")
+	}
+	for {
+		b := read(1)
+		if len(b) == 0 {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		if b[0] != 0xff {
+			return nil, fmt.Errorf("invalid JPEG marker at offset %d", len(hdr)-1)
+		}
+		// skip fill bytes
+		for len(b) > 0 && b[0] == 0xff {
+			b = read(1)
+		}
+		if len(b) == 0 {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		marker := b[0]
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8) {
+			// standalone markers
+			continue
+		}
+		if marker == 0xd9 {
+			return nil, fmt.Errorf("JPEG stream has no image data")
+		}
+		b = read(2)
+		if len(b) < 2 {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		n := int(b[0])<<8 | int(b[1])
+		if n < 2 {
+			return nil, fmt.Errorf("invalid JPEG segment length at offset %d", len(hdr)-2)
+		}
+		if len(read(n-2)) < n-2 {
+			return nil, fmt.Errorf("truncated JPEG stream")
+		}
+		if marker == 0xda {
+			return hdr, nil
+		}
+	}
+}
+
+// isSOF reports whether marker is a start of frame marker.
+func isSOF(marker byte) bool {
+	switch marker {
+	case 0xc4, 0xc8, 0xcc:
+		// DHT, JPG, DAC
+		return false
+	}
+	return marker >= 0xc0 && marker <= 0xcf
+}
+
+// jpegDimensions returns the size and number of components of the
+// image in a JPEG stream, read from its frame header, of any of the
+// baseline, progressive, lossless or arithmetic coding types.
+func jpegDimensions(data []byte) (w, h, components int, err error) {
+	segs, err := jpegSegments(data)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, seg := range segs {
+		if !isSOF(seg.marker) {
+			continue
+		}
+		if len(seg.payload) < 6 {
+			return 0, 0, 0, fmt.Errorf("truncated JPEG frame header")
+		}
+		h = int(seg.payload[1])<<8 | int(seg.payload[2])
+		w = int(seg.payload[3])<<8 | int(seg.payload[4])
+		if w == 0 || h == 0 {
+			// a zero height is defined by a later DNL marker
+			return 0, 0, 0, fmt.Errorf("unsupported JPEG dimensions %dx%d", w, h)
+		}
+		return w, h, int(seg.payload[5]), nil
+	}
+	return 0, 0, 0, fmt.Errorf("JPEG stream has no frame header")
+}
+
+// jpegColorSpace returns the PDF color space matching the
+// number of components in the frame header of a JPEG stream,
+// and a decode array if samples need to be inverted.
+//
+// CMYK JPEG files written by Adobe applications (having an
+// APP14 marker) store inverted samples.
+func jpegColorSpace(data []byte) (colorSpace, decode string, err error) {
+	segs, err := jpegSegments(data)
+	if err != nil {
+		return "", "", err
+	}
+	adobe := false
+	for _, seg := range segs {
+		if seg.marker == 0xee && bytes.HasPrefix(seg.payload, []byte("Adobe")) {
+			adobe = true
+		}
+		if !isSOF(seg.marker) {
+			continue
+		}
+		if len(seg.payload) < 6 {
+			return "", "", fmt.Errorf("truncated JPEG frame header")
+		}
+		switch n := seg.payload[5]; n {
+		case 1:
+			return "/DeviceGray", "", nil
+		case 3:
+			return "/DeviceRGB", "", nil
+		case 4:
+			if adobe {
+				decode = "[1 0 1 0 1 0 1 0]"
+			}
+			return "/DeviceCMYK", decode, nil
+		default:
+			return "", "", fmt.Errorf("unsupported number of JPEG components: %d", n)
+		}
+	}
+	return "", "", fmt.Errorf("JPEG stream has no frame header")
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// jpegHeader builds the markers of a JPEG stream, without
+// actual image data, having the given number of components.
+func jpegHeader(w, h, components int, adobe bool) []byte {
+	buf := []byte{0xff, 0xd8}
+	if adobe {
+		app14 := []byte("Adobe\x00\x64\x00\x00\x00\x00\x02")
+		buf = append(buf, 0xff, 0xee, 0, byte(2+len(app14)))
+		buf = append(buf, app14...)
+	}
+	sof := []byte{8, byte(h >> 8), byte(h), byte(w >> 8), byte(w), byte(components)}
+	for i := 0; i < components; i++ {
+		sof = append(sof, byte(i+1), 0x11, 0)
+	}
+	buf = append(buf, 0xff, 0xc0, 0, byte(2+len(sof)))
+	buf = append(buf, sof...)
+	buf = append(buf, 0xff, 0xda, 0, 2)
+	return append(buf, 0xff, 0xd9)
+}
+
+func TestCMYKJPEGPage(t *testing.T) {
+	for _, adobe := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		img := image.NewCMYK(image.Rect(0, 0, 30, 20))
+		if _, err := p.WriteJPEGPage(img, jpegHeader(30, 20, 4, adobe)); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+		if !bytes.Contains(obj.dict, []byte("/ColorSpace /DeviceCMYK")) {
+			t.Errorf("expected DeviceCMYK color space, got %s", obj.dict)
+		}
+		hasDecode := bytes.Contains(obj.dict, []byte("/Decode [1 0 1 0 1 0 1 0]"))
+		if hasDecode != adobe {
+			t.Errorf("adobe=%v: wrong decode array in %s", adobe, obj.dict)
+		}
+	}
+}
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	buf []byte
+	off int
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	if n := b.off + len(p); n > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, n-len(b.buf))...)
+	}
+	b.off += copy(b.buf[b.off:], p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(b.off)
+	case io.SeekEnd:
+		offset += int64(len(b.buf))
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	b.off = int(offset)
+	return offset, nil
+}
+
+func TestJPEGPageFrom(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	seekable := new(seekBuffer)
+	streamed := new(bytes.Buffer) // not seekable: data is read in full
+	for _, w := range []io.Writer{seekable, streamed} {
+		p, _ := NewPDFWriter(w)
+		p.WriteBlankPage(Letter)
+		if _, err := p.WriteJPEGPageFrom(bytes.NewReader(data.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, out := range [][]byte{seekable.buf, streamed.Bytes()} {
+		obj := findObject(t, out, "/Subtype /Image")
+		if !bytes.Equal(obj.stream, data.Bytes()) {
+			t.Errorf("image data differs from JPEG stream")
+		}
+		if !bytes.Contains(obj.dict, []byte("/Width 64\n/Height 48")) {
+			t.Errorf("wrong image dimensions in %s", obj.dict)
+		}
+	}
+	want := fmt.Sprintf("/Length %-10d\n", data.Len())
+	if !bytes.Contains(seekable.buf, []byte(want)) {
+		t.Errorf("missing patched %q", want)
+	}
+	if err := Verify(bytes.NewReader(seekable.buf), int64(len(seekable.buf))); err != nil {
+		t.Error(err)
+	}
+
+	p, _ := NewPDFWriter(new(seekBuffer))
+	for _, bad := range []string{"", "GIF89a", "\xff\xd8\xff\xc0\x00"} {
+		if _, err := p.WriteJPEGPageFrom(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestJPEGPageReader(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	path := filepath.Join(t.TempDir(), "scan.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	open := func() (*os.File, image.Config, int64) {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := jpeg.DecodeConfig(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		return f, cfg, fi.Size()
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	for i := 0; i < 2; i++ {
+		f, cfg, size := open()
+		if _, err := p.WriteJPEGPageReader(cfg, f, size); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if p.offset != buf.Len() {
+		t.Errorf("offset is %d, wrote %d bytes", p.offset, buf.Len())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := 0
+	for _, obj := range readObjects(t, buf.Bytes()) {
+		if bytes.Contains(obj.dict, []byte("/Subtype /Image")) {
+			images++
+			if !bytes.Equal(obj.stream, data) {
+				t.Errorf("object %d: image data differs from file", obj.id)
+			}
+		}
+	}
+	if images != 2 {
+		t.Errorf("found %d images, want 2", images)
+	}
+
+	// the size must match the data
+	f, cfg, size := open()
+	defer f.Close()
+	p, _ = NewPDFWriter(io.Discard)
+	if _, err := p.WriteJPEGPageReader(cfg, f, size+1); err == nil {
+		t.Error("expected error for wrong size")
+	}
+}
+
+func TestJPEGPageConfig(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	cfg := image.Config{Width: 30, Height: 20}
+	if _, err := p.WriteJPEGPageConfig(cfg, jpegHeader(30, 20, 3, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	if !bytes.Contains(obj.dict, []byte("/Width 30\n/Height 20")) {
+		t.Errorf("wrong image dimensions in %s", obj.dict)
+	}
+}
+
+func benchmarkJPEGPage(b *testing.B, decode bool) {
+	img := image.NewRGBA(image.Rect(0, 0, 1240, 1754))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(data.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p, _ := NewPDFWriter(io.Discard)
+		var err error
+		if decode {
+			var img image.Image
+			if img, err = jpeg.Decode(bytes.NewReader(data.Bytes())); err == nil {
+				_, err = p.WriteJPEGPage(img, data.Bytes())
+			}
+		} else {
+			var cfg image.Config
+			if cfg, err = jpeg.DecodeConfig(bytes.NewReader(data.Bytes())); err == nil {
+				_, err = p.WriteJPEGPageConfig(cfg, data.Bytes())
+			}
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJPEGPageDecode(b *testing.B) { benchmarkJPEGPage(b, true) }
+func BenchmarkJPEGPageConfig(b *testing.B) { benchmarkJPEGPage(b, false) }
+
+func TestJPEGPageReaderUnknownSize(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg := image.Config{Width: 64, Height: 48}
+
+	// a pipe: neither seekable nor sized
+	var out struct{ io.Writer }
+	buf := new(bytes.Buffer)
+	out.Writer = buf
+	p, _ := NewPDFWriter(out)
+	if _, err := p.WriteJPEGPageReader(cfg, bytes.NewReader(data.Bytes()), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Error(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	if !bytes.Equal(obj.stream, data.Bytes()) {
+		t.Errorf("image data differs from JPEG stream")
+	}
+	if want := fmt.Sprintf("/Length %d\n", data.Len()); !bytes.Contains(obj.dict, []byte(want)) {
+		t.Errorf("missing %q in %s", want, obj.dict)
+	}
+
+	// streaming a stream of unknown size needs to seek
+	p, _ = NewPDFWriter(out)
+	if err := p.patchLength(nil, 0, 1); err == nil {
+		t.Error("expected error patching a length without seeking")
+	}
+}
+
+func TestJPEGDimensions(t *testing.T) {
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, image.NewRGBA(image.Rect(0, 0, 37, 23)), nil); err != nil {
+		t.Fatal(err)
+	}
+	w, h, n, err := jpegDimensions(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 37 || h != 23 || n != 3 {
+		t.Errorf("got %dx%d with %d components, want 37x23 with 3", w, h, n)
+	}
+
+	// progressive frame header
+	progressive := jpegHeader(640, 480, 1, false)
+	progressive[bytes.Index(progressive, []byte{0xff, 0xc0})+1] = 0xc2
+	if w, h, n, err := jpegDimensions(progressive); err != nil || w != 640 || h != 480 || n != 1 {
+		t.Errorf("progressive: got %dx%d with %d components, %v", w, h, n, err)
+	}
+
+	noSOF := []byte{0xff, 0xd8, 0xff, 0xda, 0, 2, 0xff, 0xd9}
+	for name, data := range map[string][]byte{
+		"empty":       nil,
+		"truncated":   data.Bytes()[:20],
+		"no frame":    noSOF,
+		"zero height": jpegHeader(640, 0, 3, false),
+		"not JPEG":    []byte("\x89PNG\r\n\x1a\n"),
+	} {
+		if _, _, _, err := jpegDimensions(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestJPEGPageBytes(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if _, err := p.WriteJPEGPageBytes(jpegHeader(300, 150, 3, false)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.WriteJPEGPageBytes([]byte("not a JPEG")); err == nil {
+		t.Error("expected error for invalid data")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	if !bytes.Contains(obj.dict, []byte("/Width 300\n/Height 150")) {
+		t.Errorf("wrong image dimensions in %s", obj.dict)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("/MediaBox [0 0 144.00 72.00]")) {
+		t.Error("wrong page size for 300x150 image at 150 DPI")
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 30))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// an EXIF segment, a fake thumbnail and a comment
+	extra := exifSegment(binary.BigEndian, 1, 300, 300, 2)
+	extra = append(extra, 0xff, 0xe1, 0x10, 0x02)
+	extra = append(extra, make([]byte, 0x1000)...)
+	extra = append(extra, 0xff, 0xfe, 0x00, 0x07, 'h', 'e', 'l', 'l', 'o')
+	data := append(append([]byte{0xff, 0xd8}, extra...), enc.Bytes()[2:]...)
+
+	embed := func(strip, reader bool) (stream, page []byte) {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.StripMetadata = strip
+		var err error
+		if reader {
+			_, err = p.WriteJPEGPageReader(image.Config{Width: 60, Height: 30}, bytes.NewReader(data), int64(len(data)))
+		} else {
+			_, err = p.WriteJPEGPageBytes(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return findObject(t, buf.Bytes(), "/Subtype /Image").stream, findObject(t, buf.Bytes(), "/Type /Page\n").dict
+	}
+	full, _ := embed(false, false)
+	if !bytes.Equal(full, data) {
+		t.Error("JPEG data changed without StripMetadata")
+	}
+	for _, reader := range []bool{false, true} {
+		stripped, page := embed(true, reader)
+		if len(stripped) != len(enc.Bytes()) {
+			t.Errorf("got %d bytes of stripped JPEG, want %d", len(stripped), len(enc.Bytes()))
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+			t.Errorf("stripped JPEG does not decode: %v", err)
+		}
+		// 60x30 pixels at 300 DPI
+		if !bytes.Contains(page, []byte("/MediaBox [0 0 14.40 7.20]")) {
+			t.Errorf("EXIF resolution lost in %s", page)
+		}
+	}
+
+	// the Adobe segment tells how colors are encoded
+	cmyk := jpegHeader(10, 10, 4, true)
+	if got := stripJPEG(cmyk); !bytes.Equal(got, cmyk) {
+		t.Errorf("Adobe segment stripped: got % x, want % x", got, cmyk)
+	}
+
+	// so do ICC profiles
+	icc := []byte{0xff, 0xe2, 0x00, 0x17}
+	icc = append(icc, "ICC_PROFILE\x00\x01\x01profile"...)
+	withICC := append(append([]byte{0xff, 0xd8}, icc...), enc.Bytes()[2:]...)
+	if _, err := jpegSegments(withICC); err != nil {
+		t.Fatal(err)
+	}
+	if got := stripJPEG(withICC); !bytes.Equal(got, withICC) {
+		t.Error("ICC profile stripped")
+	}
+	// but not other APP2 segments
+	other := append([]byte{0xff, 0xe2, 0x00, 0x07}, "FPXR\x00"...)
+	if got := stripJPEG(append(append([]byte{0xff, 0xd8}, other...), enc.Bytes()[2:]...)); !bytes.Equal(got, enc.Bytes()) {
+		t.Error("non-ICC APP2 segment kept")
+	}
+}
+
+// progressiveGray returns a progressive JPEG stream of an 8x8 mid-gray
+// image, with one scan for the DC coefficient and one for the others,
+// which are all zero.
+func progressiveGray() []byte {
+	seg := func(marker byte, payload ...byte) []byte {
+		n := len(payload) + 2
+		return append([]byte{0xff, marker, byte(n >> 8), byte(n)}, payload...)
+	}
+	dqt := make([]byte, 65)
+	for i := 1; i < len(dqt); i++ {
+		dqt[i] = 1
+	}
+	// a Huffman table with the single 1-bit code 0 for symbol 0:
+	// a zero DC difference, or the end of block
+	huff := func(class byte) []byte {
+		t := []byte{class << 4, 1}
+		return append(append(t, make([]byte, 15)...), 0)
+	}
+	var data []byte
+	data = append(data, 0xff, 0xd8)
+	data = append(data, seg(0xdb, dqt...)...)
+	data = append(data, seg(0xc2, 8, 0, 8, 0, 8, 1, 1, 0x11, 0)...)
+	data = append(data, seg(0xc4, huff(0)...)...)
+	data = append(data, seg(0xda, 1, 1, 0x00, 0, 0, 0)...)
+	data = append(data, 0x7f)
+	data = append(data, seg(0xc4, huff(1)...)...)
+	data = append(data, seg(0xda, 1, 1, 0x00, 1, 63, 0)...)
+	data = append(data, 0x7f)
+	return append(data, 0xff, 0xd9)
+}
+
+func TestProgressiveJPEG(t *testing.T) {
+	data := progressiveGray()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid test image: %v", err)
+	}
+	if c := img.(*image.Gray).Pix[0]; c != 128 {
+		t.Fatalf("test image has gray level %d, want 128", c)
+	}
+	if !progressiveJPEG(data) || progressiveJPEG(jpegHeader(8, 8, 1, false)) {
+		t.Error("wrong progressive JPEG detection")
+	}
+
+	for _, keep := range []bool{false, true} {
+		for _, reader := range []bool{false, true} {
+			buf := new(bytes.Buffer)
+			p, _ := NewPDFWriter(buf)
+			p.KeepProgressive = keep
+			if reader {
+				_, err = p.WriteJPEGPageReader(image.Config{Width: 8, Height: 8}, bytes.NewReader(data), -1)
+			} else {
+				_, err = p.WriteJPEGPageBytes(data)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.RegisterImage(img, data); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range readObjects(t, buf.Bytes()) {
+				if !bytes.Contains(obj.dict, []byte("/DCTDecode")) {
+					continue
+				}
+				if keep {
+					if !bytes.Equal(obj.stream, data) {
+						t.Error("progressive JPEG changed with KeepProgressive")
+					}
+					continue
+				}
+				if progressiveJPEG(obj.stream) {
+					t.Error("progressive JPEG embedded as is")
+				}
+				out, err := jpeg.Decode(bytes.NewReader(obj.stream))
+				if err != nil {
+					t.Fatalf("transcoded JPEG does not decode: %v", err)
+				}
+				if c := out.(*image.Gray).Pix[0]; c != 128 {
+					t.Errorf("transcoded JPEG has gray level %d, want 128", c)
+				}
+			}
+			if got := len(p.Warnings()); got != map[bool]int{false: 2, true: 0}[keep] {
+				t.Errorf("got warnings %q", p.Warnings())
+			}
+		}
+	}
+}
+
+func TestWriteImageAsJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 60, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 60; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(8 * y), 128, 255})
+		}
+	}
+	for _, quality := range []int{75, 0, 1000} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if _, err := p.WriteImageAsJPEG(img, quality); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+		for _, want := range []string{"/Width 60\n/Height 30", "/ColorSpace /DeviceRGB", "/Filter [ /DCTDecode ]"} {
+			if !bytes.Contains(obj.dict, []byte(want)) {
+				t.Errorf("quality %d: image %s does not contain %s", quality, obj.dict, want)
+			}
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(obj.stream))
+		if err != nil {
+			t.Fatalf("quality %d: %v", quality, err)
+		}
+		if dec.Bounds() != img.Bounds() {
+			t.Errorf("quality %d: got %v image", quality, dec.Bounds())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// This file implements page labels.
+
+// A LabelStyle is the numbering style of page labels.
+type LabelStyle string
+
+const (
+	NoNumbers    LabelStyle = ""  // prefix only
+	Decimal      LabelStyle = "D" // 1, 2, 3
+	UpperRoman   LabelStyle = "R" // I, II, III
+	LowerRoman   LabelStyle = "r" // i, ii, iii
+	UpperLetters LabelStyle = "A" // A to Z, then AA to ZZ
+	LowerLetters LabelStyle = "a" // a to z, then aa to zz
+)
+
+// A PageLabelRange labels pages from Start, the index of its first
+// page from 0, up to the next range.
+type PageLabelRange struct {
+	Start  int
+	Style  LabelStyle
+	Prefix string
+	First  int // number of the first page, 1 if zero
+}
+
+// SetPageLabels sets the labels displayed by viewers instead of page
+// indices. Ranges must be sorted by start, the first starting at 0.
+func (p *PDFWriter) SetPageLabels(ranges []PageLabelRange) error {
+	for i, r := range ranges {
+		switch r.Style {
+		case NoNumbers, Decimal, UpperRoman, LowerRoman, UpperLetters, LowerLetters:
+		default:
+			return fmt.Errorf("invalid page label style %q", r.Style)
+		}
+		if r.First < 0 {
+			return fmt.Errorf("invalid first page number %d", r.First)
+		}
+		if i == 0 && r.Start != 0 {
+			return fmt.Errorf("first page label range starts at %d, not 0", r.Start)
+		}
+		if i > 0 && r.Start <= ranges[i-1].Start {
+			return fmt.Errorf("page label range at %d overlaps the previous one", r.Start)
+		}
+	}
+	p.labels = append([]PageLabelRange(nil), ranges...)
+	return nil
+}
+
+// pageLabels returns the page labels number tree.
+func (p *PDFWriter) pageLabels() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("<< /Nums [")
+	for _, r := range p.labels {
+		fmt.Fprintf(buf, " %d <<", r.Start)
+		if r.Style != NoNumbers {
+			fmt.Fprintf(buf, " /S /%s", r.Style)
+		}
+		if r.Prefix != "" {
+			fmt.Fprintf(buf, " /P %s", p.str(r.Prefix))
+		}
+		if r.First > 1 {
+			fmt.Fprintf(buf, " /St %d", r.First)
+		}
+		buf.WriteString(" >>")
+	}
+	buf.WriteString(" ] >>")
+	return buf.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPageLabels(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	for i := 0; i < 8; i++ {
+		p.WriteBlankPage(A4)
+	}
+	for _, bad := range [][]PageLabelRange{
+		{{Start: 1, Style: Decimal}},
+		{{Start: 0, Style: LowerRoman}, {Start: 4, Style: Decimal}, {Start: 4, Style: Decimal}},
+		{{Start: 0, Style: "x"}},
+	} {
+		if err := p.SetPageLabels(bad); err == nil {
+			t.Errorf("expected error for ranges %v", bad)
+		}
+	}
+	err := p.SetPageLabels([]PageLabelRange{
+		{Start: 0, Style: LowerRoman},
+		{Start: 4, Style: Decimal},
+		{Start: 7, Style: Decimal, Prefix: "A-", First: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	want := "/PageLabels << /Nums [ 0 << /S /r >> 4 << /S /D >> 7 << /S /D /P (A-) /St 2 >> ] >>"
+	if !bytes.Contains(catalog.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, catalog.dict)
+	}
+}
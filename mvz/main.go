@@ -41,20 +41,15 @@ func main() {
 		}
 		p.WriteInfo(title, time.Now())
 		emitPage = func(page int, img image.Image, jpgData []byte) {
-			off := p.offset
+			off := p.BytesWritten()
 			p.WriteJPEGPage(img, jpgData)
-			log.Printf("added page %d (wrote %d bytes)", page, p.offset-off)
+			log.Printf("added page %d (wrote %d bytes)", page, p.BytesWritten()-off)
 		}
 		finish := func() {
-			p.Flush()
-			if p.err != nil {
-				log.Fatal(p.err)
-			}
-			err = w.Close()
-			if err != nil {
+			if err := p.Close(); err != nil {
 				log.Fatal(err)
 			}
-			log.Printf("PDF file complete: %d bytes", p.offset)
+			log.Printf("PDF file complete: %d bytes", p.BytesWritten())
 		}
 		defer finish()
 	} else {
@@ -0,0 +1,113 @@
+package main
+
+import "fmt"
+
+// This file implements page margins.
+
+// Margins are the distances from the edges of a page to its
+// content box. DrawText coordinates are relative to the lower left
+// corner of the content box, and DrawTextBox, headers and footers
+// place text inside it by default.
+type Margins struct {
+	Top, Right, Bottom, Left Length
+}
+
+// WithMargins sets the margins of all pages, unless overridden by
+// WithPageSizeMargins or SetPageMargins. By default, pages have no
+// margins, and headers and footers are drawn half an inch from the
+// edges.
+func WithMargins(top, right, bottom, left Length) Option {
+	return func(p *PDFWriter) error {
+		m := Margins{top, right, bottom, left}
+		if err := m.check(); err != nil {
+			return err
+		}
+		p.margins = &m
+		return nil
+	}
+}
+
+// WithPageSizeMargins sets the margins of the pages of the given
+// size, overriding those set by WithMargins. A landscape page is
+// of another size than the portrait one.
+func WithPageSizeMargins(size PageSize, top, right, bottom, left Length) Option {
+	return func(p *PDFWriter) error {
+		m := Margins{top, right, bottom, left}
+		if err := m.check(); err != nil {
+			return err
+		}
+		if m.Left+m.Right >= size.Width || m.Top+m.Bottom >= size.Height {
+			return fmt.Errorf("margins leave no room on %.2fx%.2f pages", size.Width, size.Height)
+		}
+		if p.sizeMargins == nil {
+			p.sizeMargins = make(map[PageSize]Margins)
+		}
+		p.sizeMargins[size] = m
+		return nil
+	}
+}
+
+// SetPageMargins sets the margins of page, overriding those set by
+// WithMargins and WithPageSizeMargins.
+func (p *PDFWriter) SetPageMargins(page PDFID, m Margins) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	if m.Left+m.Right >= pg.width || m.Top+m.Bottom >= pg.height {
+		return fmt.Errorf("margins leave no room on page %d", page)
+	}
+	pg.margins = &m
+	return nil
+}
+
+func (m Margins) check() error {
+	if m.Top < 0 || m.Right < 0 || m.Bottom < 0 || m.Left < 0 {
+		return fmt.Errorf("negative margin")
+	}
+	return nil
+}
+
+// ContentBox returns the area of page inside its margins, as
+// [x0 y0 x1 y1].
+func (p *PDFWriter) ContentBox(page PDFID) ([4]Length, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return [4]Length{}, err
+	}
+	box, _ := p.contentBox(pg)
+	return box, nil
+}
+
+// contentBox returns the content box of pg, and whether margins
+// were set for it.
+func (p *PDFWriter) contentBox(pg *pdfPage) ([4]Length, bool) {
+	m := pg.margins
+	if m == nil {
+		if sm, ok := p.sizeMargins[PageSize{pg.width, pg.height}]; ok {
+			m = &sm
+		}
+	}
+	if m == nil {
+		m = p.margins
+	}
+	if m == nil {
+		return [4]Length{0, 0, pg.width, pg.height}, false
+	}
+	box := [4]Length{m.Left, m.Bottom, pg.width - m.Right, pg.height - m.Top}
+	// margins too large for the page leave an empty box
+	if box[2] < box[0] {
+		box[2] = box[0]
+	}
+	if box[3] < box[1] {
+		box[3] = box[1]
+	}
+	return box, true
+}
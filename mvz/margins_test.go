@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMargins(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, err := NewPDFWriter(buf, WithMargins(INCH, INCH/2, 2*INCH, INCH/2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := p.WriteBlankPage(Letter)
+	second, _ := p.WriteBlankPage(Letter)
+	if err := p.SetPageMargins(second, Margins{Top: 36, Right: 36, Bottom: 36, Left: 72}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		page PDFID
+		want [4]Length
+	}{
+		{first, [4]Length{36, 144, 576, 720}},
+		{second, [4]Length{72, 36, 576, 756}},
+	} {
+		if box, err := p.ContentBox(tc.page); err != nil || box != tc.want {
+			t.Errorf("page %d: got content box %v, %v, want %v", tc.page, box, err, tc.want)
+		}
+	}
+
+	if _, err := p.DrawTextBox(first, [4]Length{}, 12, "in the box"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DrawText(second, 0, 12, 12, "above the footer"); err != nil {
+		t.Fatal(err)
+	}
+	p.SetFooter(func(page, total int) string { return "footer" })
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), first))
+	for _, want := range []string{
+		"36.00 144.00 540.00 576.00 re W n",
+		"36.00 708.00 Td\n(in the box) Tj",
+		// the footer baseline is on the bottom of the content box
+		"36.00 144.00 Td\n(footer) Tj",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("page content %q does not contain %q", content, want)
+		}
+	}
+	content = string(pageContents(t, buf.Bytes(), second))
+	if !strings.Contains(content, "72.00 36.00 Td\n(footer) Tj") {
+		t.Errorf("footer not in the page margins: %q", content)
+	}
+	// DrawText measures from the content box origin
+	if !strings.Contains(content, "72.00 48.00 Td\n(above the footer) Tj") {
+		t.Errorf("text not placed in the content box: %q", content)
+	}
+}
+
+func TestPageSizeMargins(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, err := NewPDFWriter(buf, WithMargins(INCH, INCH, INCH, INCH),
+		WithPageSizeMargins(A5, 18, 18, 18, 18))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a4, _ := p.WriteBlankPage(A4)
+	a5, _ := p.WriteBlankPage(A5)
+	landscape, _ := p.WriteBlankPage(A5.Landscape())
+	override, _ := p.WriteBlankPage(A5)
+	p.SetPageMargins(override, Margins{Top: 36, Right: 36, Bottom: 36, Left: 36})
+	for _, tc := range []struct {
+		page PDFID
+		want [4]Length
+	}{
+		{a4, [4]Length{INCH, INCH, A4.Width - INCH, A4.Height - INCH}},
+		{a5, [4]Length{18, 18, A5.Width - 18, A5.Height - 18}},
+		{landscape, [4]Length{INCH, INCH, A5.Height - INCH, A5.Width - INCH}},
+		{override, [4]Length{36, 36, A5.Width - 36, A5.Height - 36}},
+	} {
+		if box, err := p.ContentBox(tc.page); err != nil || box != tc.want {
+			t.Errorf("page %d: got content box %v, %v, want %v", tc.page, box, err, tc.want)
+		}
+	}
+
+	// a margin-aware footer lands inside the content box of the size
+	p.SetFooter(func(page, total int) string { return "footer" })
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if content := string(pageContents(t, buf.Bytes(), a5)); !strings.Contains(content, "18.00 18.00 Td\n(footer) Tj") {
+		t.Errorf("footer not in the A5 margins: %q", content)
+	}
+}
+
+func TestInvalidMargins(t *testing.T) {
+	if _, err := NewPDFWriter(new(bytes.Buffer), WithMargins(0, -1, 0, 0)); err == nil {
+		t.Error("expected error for negative margin")
+	}
+	if _, err := NewPDFWriter(new(bytes.Buffer), WithPageSizeMargins(A5, 0, 0, A5.Height, 0)); err == nil {
+		t.Error("expected error for page size margins taller than the page")
+	}
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	page, _ := p.WriteBlankPage(A5)
+	if err := p.SetPageMargins(page, Margins{Left: 5 * CM, Right: 10 * CM}); err == nil {
+		t.Error("expected error for margins wider than the page")
+	}
+	if box, _ := p.ContentBox(page); box != [4]Length{0, 0, A5.Width, A5.Height} {
+		t.Errorf("got content box %v without margins, want the page", box)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// This file implements the name dictionary of the document: named
+// destinations and embedded files.
+
+// A namedDest is a destination added by AddNamedDestination.
+type namedDest struct {
+	name string
+	page PDFID
+	top  Length
+}
+
+// AddNamedDestination names the position top of page, from the
+// bottom of the page, so that links, bookmarks and URLs such as
+// file.pdf#name can point to it.
+func (p *PDFWriter) AddNamedDestination(name string, page PDFID, top Length) error {
+	if name == "" {
+		return fmt.Errorf("empty destination name")
+	}
+	if _, err := p.page(page); err != nil {
+		return fmt.Errorf("invalid destination %s: %s", name, err)
+	}
+	for _, d := range p.dests {
+		if d.name == name {
+			return fmt.Errorf("duplicate destination %s", name)
+		}
+	}
+	p.dests = append(p.dests, namedDest{name, page, top})
+	return nil
+}
+
+// A nameEntry is an entry of a name tree.
+type nameEntry struct {
+	name, value string
+}
+
+// nameTree returns a name tree holding entries, as a single node.
+func (p *PDFWriter) nameTree(entries []nameEntry) string {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	var b strings.Builder
+	b.WriteString("<< /Names [")
+	for _, e := range entries {
+		fmt.Fprintf(&b, " %s %s", p.byteStr(e.name), e.value)
+	}
+	b.WriteString(" ] >>")
+	return b.String()
+}
+
+// names returns the name dictionary of the catalog.
+func (p *PDFWriter) names() string {
+	var b strings.Builder
+	b.WriteString("<<")
+	if len(p.dests) > 0 {
+		entries := make([]nameEntry, len(p.dests))
+		for i, d := range p.dests {
+			entries[i] = nameEntry{d.name, fmt.Sprintf("[%d 0 R /XYZ null %.2f null]", d.page, d.top)}
+		}
+		b.WriteString(" /Dests " + p.nameTree(entries))
+	}
+	if len(p.attachments) > 0 {
+		entries := make([]nameEntry, len(p.attachments))
+		for i, a := range p.attachments {
+			entries[i] = nameEntry{a.name, fmt.Sprintf("%d 0 R", a.spec)}
+		}
+		b.WriteString(" /EmbeddedFiles " + p.nameTree(entries))
+	}
+	b.WriteString(" >>")
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestNamedDestinations(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var pages []PDFID
+	for i := 0; i < 3; i++ {
+		id, _ := p.WriteBlankPage(A4)
+		pages = append(pages, id)
+	}
+	if err := p.AddNamedDestination("chapter2", pages[2], 700); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddNamedDestination("chapter1", pages[1], 800); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddNamedDestination("chapter1", pages[2], 800); err == nil {
+		t.Error("expected error for duplicate destination")
+	}
+	if err := p.AddNamedDestination("missing", pages[2]+10, 0); err == nil {
+		t.Error("expected error for a missing page")
+	}
+	if err := p.AddLinkToName(pages[0], [4]Length{72, 700, 300, 720}, "chapter2"); err != nil {
+		t.Fatal(err)
+	}
+	b := p.AddBookmark("Chapter 2", 0)
+	b.Dest = "chapter2"
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	m := regexp.MustCompile(`/Names << /Dests << /Names \[ (.*?) \] >> >>`).FindSubmatch(catalog.dict)
+	if m == nil {
+		t.Fatalf("no destinations name tree in %s", catalog.dict)
+	}
+	want := fmt.Sprintf("(chapter1) [%d 0 R /XYZ null 800.00 null] (chapter2) [%d 0 R /XYZ null 700.00 null]", pages[1], pages[2])
+	if string(m[1]) != want {
+		t.Errorf("got name tree entries %s, want %s", m[1], want)
+	}
+	if link := findObject(t, buf.Bytes(), "/Subtype /Link"); !bytes.Contains(link.dict, []byte("/Dest (chapter2)")) {
+		t.Errorf("link does not point to chapter2: %s", link.dict)
+	}
+	if item := findObject(t, buf.Bytes(), "/Title (Chapter 2)"); !bytes.Contains(item.dict, []byte("/Dest (chapter2)")) {
+		t.Errorf("bookmark does not point to chapter2: %s", item.dict)
+	}
+}
+
+func TestNamesDictionary(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	p.AddNamedDestination("top", page, A4.Height)
+	p.AttachFile("data.csv", "text/csv", []byte("a,b\n"))
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	rx := regexp.MustCompile(`/Names << /Dests << /Names \[ \(top\) \[\d+ 0 R /XYZ null 841.89 null\] \] >> /EmbeddedFiles << /Names \[ \(data.csv\) \d+ 0 R \] >> >>`)
+	if !rx.Match(catalog.dict) {
+		t.Errorf("wrong name dictionary in %s", catalog.dict)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// This file implements searchable text layers over scanned pages.
+
+// An OCRWord is a word recognized in a page image, with its bounding
+// box [x0 y0 x1 y1] in page coordinates.
+type OCRWord struct {
+	Text string
+	Box  [4]Length
+}
+
+// AddTextLayer draws words on page as invisible text in the current
+// font, on top of the existing contents, so that the page can be
+// searched and its text selected. Each word is scaled to fill its
+// box: its size is the height of the box and it is stretched to the
+// box width, with its baseline at the bottom edge.
+func (p *PDFWriter) AddTextLayer(page PDFID, words []OCRWord) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	for _, w := range words {
+		if w.Box[2] <= w.Box[0] || w.Box[3] <= w.Box[1] {
+			return fmt.Errorf("invalid box %s for word %q", formatRect(w.Box), w.Text)
+		}
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return err
+	}
+	cs := &pg.extra
+	cs.addResource("Font", f.name, f.id)
+	cs.buf.WriteString("q\nBT\n3 Tr\n")
+	for _, w := range words {
+		size := float64(w.Box[3] - w.Box[1])
+		width := f.StringWidth(w.Text, size)
+		if width == 0 {
+			continue
+		}
+		fmt.Fprintf(&cs.buf, "/%s %.2f Tf\n%.2f Tz\n1 0 0 1 %.2f %.2f Tm\n%s Tj\n",
+			f.name, size, 100*float64(w.Box[2]-w.Box[0])/float64(width),
+			w.Box[0], w.Box[1], f.encode(w.Text))
+	}
+	cs.buf.WriteString("ET\nQ\n")
+	return p.err
+}
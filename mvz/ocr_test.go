@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func TestTextLayer(t *testing.T) {
+	data := new(bytes.Buffer)
+	img := image.NewGray(image.Rect(0, 0, 100, 50))
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, err := p.WriteJPEGPage(img, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AddTextLayer(page, []OCRWord{
+		// Hello is 22.78 wide at size 10
+		{"Hello", [4]Length{10, 20, 32.78, 30}},
+		{"world", [4]Length{40, 20, 80, 40}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddTextLayer(page, []OCRWord{{"bad", [4]Length{10, 10, 5, 20}}}); err == nil {
+		t.Error("expected error for empty box")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	if !strings.HasPrefix(content, "q\n48.00 0 0 24.00 0.00 0.00 cm\n/I0 Do\nQ\n") {
+		t.Errorf("image no longer drawn first in %s", content)
+	}
+	want := "q\nBT\n3 Tr\n" +
+		"/F1 10.00 Tf\n100.00 Tz\n1 0 0 1 10.00 20.00 Tm\n(Hello) Tj\n" +
+		"/F1 20.00 Tf\n83.72 Tz\n1 0 0 1 40.00 20.00 Tm\n(world) Tj\n" +
+		"ET\nQ\n"
+	if !strings.HasSuffix(content, want) {
+		t.Errorf("got content stream %q, want suffix %q", content, want)
+	}
+}
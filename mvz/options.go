@@ -0,0 +1,52 @@
+package main
+
+import "fmt"
+
+// This file implements the options of NewPDFWriter.
+
+// An Option configures a PDFWriter created by NewPDFWriter.
+type Option func(p *PDFWriter) error
+
+// WithDPI sets the resolution of image pages, as the DPI field.
+func WithDPI(dpi float64) Option {
+	return func(p *PDFWriter) error {
+		if !(dpi > 0) {
+			return fmt.Errorf("invalid resolution %g", dpi)
+		}
+		p.DPI = dpi
+		return nil
+	}
+}
+
+// WithCompression enables compression of content streams, as the
+// Compress field.
+func WithCompression() Option {
+	return func(p *PDFWriter) error {
+		p.Compress = true
+		return nil
+	}
+}
+
+// WithVersion sets the PDF version of the document, such as "1.5",
+// as by SetVersion.
+func WithVersion(v string) Option {
+	return func(p *PDFWriter) error {
+		return p.setVersion(v)
+	}
+}
+
+// WithDeterministic makes the output reproducible, as by
+// SetDeterministic.
+func WithDeterministic() Option {
+	return func(p *PDFWriter) error {
+		p.SetDeterministic()
+		return nil
+	}
+}
+
+// validVersion reports whether v is a PDF version, 1.0 to 1.7
+// or 2.0.
+func validVersion(v string) bool {
+	return len(v) == 3 && v[1] == '.' &&
+		(v[0] == '1' && v[2] >= '0' && v[2] <= '7' || v == "2.0")
+}
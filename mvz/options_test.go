@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// optionsDocument writes a document with an image page and some
+// text, with the given options.
+func optionsDocument(t *testing.T, opts ...Option) []byte {
+	buf := new(bytes.Buffer)
+	p, err := NewPDFWriter(buf, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.WriteJPEGPageBytes(jpegHeader(600, 300, 3, false)); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := p.WriteBlankPage(A4)
+	p.DrawText(page, 72, 72, 12, "options")
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOptions(t *testing.T) {
+	plain := optionsDocument(t)
+	if !bytes.HasPrefix(plain, []byte("%PDF-1.3")) ||
+		!bytes.Contains(plain, []byte("/MediaBox [0 0 288.00 144.00]")) ||
+		bytes.Contains(plain, []byte("/FlateDecode")) {
+		t.Error("wrong document without options")
+	}
+	if data := optionsDocument(t, WithDPI(300)); !bytes.Contains(data, []byte("/MediaBox [0 0 144.00 72.00]")) {
+		t.Error("WithDPI(300) does not change the page size")
+	}
+	if data := optionsDocument(t, WithCompression()); !bytes.Contains(data, []byte("/FlateDecode")) {
+		t.Error("WithCompression does not compress content streams")
+	}
+	if data := optionsDocument(t, WithVersion("1.5")); !bytes.HasPrefix(data, []byte("%PDF-1.5")) {
+		t.Errorf("WithVersion(1.5) wrote header %q", data[:8])
+	}
+	a := optionsDocument(t, WithDeterministic())
+	time.Sleep(time.Millisecond)
+	if b := optionsDocument(t, WithDeterministic()); !bytes.Equal(a, b) {
+		t.Error("WithDeterministic output differs between runs")
+	}
+
+	for _, opt := range []Option{WithDPI(0), WithDPI(-300), WithVersion("1.8"), WithVersion("3"), WithVersion("")} {
+		if _, err := NewPDFWriter(new(bytes.Buffer), opt); err == nil {
+			t.Error("expected error for invalid option")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+// This file implements the document outline (bookmarks).
+
+// A Bookmark is an item of the document outline,
+// pointing to a page.
+type Bookmark struct {
+	Title string
+	Page  PDFID
+	// Dest, if not empty, is the named destination the item
+	// points to instead of Page.
+	Dest string
+	// Open tells whether children are initially visible.
+	Open bool
+
+	children []*Bookmark
+	id       PDFID
+}
+
+// AddBookmark adds a top-level item to the document outline.
+func (p *PDFWriter) AddBookmark(title string, page PDFID) *Bookmark {
+	b := &Bookmark{Title: title, Page: page}
+	p.outline = append(p.outline, b)
+	return b
+}
+
+// AddChild adds a nested item under b.
+func (b *Bookmark) AddChild(title string, page PDFID) *Bookmark {
+	c := &Bookmark{Title: title, Page: page}
+	b.children = append(b.children, c)
+	return c
+}
+
+// visible returns the number of descendants of b
+// that are visible when b is open.
+func (b *Bookmark) visible() int {
+	return countVisible(b.children)
+}
+
+func countVisible(items []*Bookmark) int {
+	n := 0
+	for _, c := range items {
+		n++
+		if c.Open {
+			n += c.visible()
+		}
+	}
+	return n
+}
+
+// writeOutline writes the outline dictionary and all its items.
+func (p *PDFWriter) writeOutline() (PDFID, error) {
+	root := p.reserveObj()
+	p.reserveOutline(p.outline)
+	p.startObjID(root)
+	p.print("/Type /Outlines")
+	p.printf("/First %d 0 R", p.outline[0].id)
+	p.printf("/Last %d 0 R", p.outline[len(p.outline)-1].id)
+	p.printf("/Count %d", countVisible(p.outline))
+	p.endObj()
+	p.writeOutlineItems(root, p.outline)
+	return root, p.err
+}
+
+func (p *PDFWriter) reserveOutline(items []*Bookmark) {
+	for _, b := range items {
+		b.id = p.reserveObj()
+		p.reserveOutline(b.children)
+	}
+}
+
+func (p *PDFWriter) writeOutlineItems(parent PDFID, items []*Bookmark) {
+	for i, b := range items {
+		p.startObjID(b.id)
+		p.printf("/Title %s", p.str(b.Title))
+		p.printf("/Parent %d 0 R", parent)
+		if i > 0 {
+			p.printf("/Prev %d 0 R", items[i-1].id)
+		}
+		if i+1 < len(items) {
+			p.printf("/Next %d 0 R", items[i+1].id)
+		}
+		if len(b.children) > 0 {
+			p.printf("/First %d 0 R", b.children[0].id)
+			p.printf("/Last %d 0 R", b.children[len(b.children)-1].id)
+			if b.Open {
+				p.printf("/Count %d", b.visible())
+			} else {
+				p.printf("/Count %d", -b.visible())
+			}
+		}
+		if b.Dest != "" {
+			p.printf("/Dest %s", p.byteStr(b.Dest))
+		} else {
+			p.printf("/Dest [%d 0 R /Fit]", b.Page)
+		}
+		p.endObj()
+		p.writeOutlineItems(b.id, b.children)
+	}
+}
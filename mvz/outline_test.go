@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestOutline(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfo("outline", time.Now())
+	var pages []PDFID
+	for i := 0; i < 4; i++ {
+		id, _ := p.WritePage(21*CM, 29.7*CM, nil)
+		pages = append(pages, id)
+	}
+	ch1 := p.AddBookmark("Chapter 1", pages[0])
+	ch1.Open = true
+	ch1.AddChild("Section 1.1", pages[1])
+	ch1.AddChild("Section 1.2", pages[2])
+	ch2 := p.AddBookmark("Chapter 2", pages[3])
+	ch2.AddChild("Section 2.1", pages[3])
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	byTitle := make(map[string]pdfObject)
+	for _, obj := range objs {
+		if m := regexp.MustCompile(`/Title \((.*)\)`).FindSubmatch(obj.dict); m != nil {
+			byTitle[string(m[1])] = obj
+		}
+	}
+	ref := func(title string) string {
+		return fmt.Sprintf("%d 0 R", byTitle[title].id)
+	}
+	root := findObject(t, buf.Bytes(), "/Type /Outlines")
+	if !bytes.Contains(objs[CATALOG_ID-1].dict, []byte(fmt.Sprintf("/Outlines %d 0 R", root.id))) {
+		t.Errorf("catalog does not reference outline")
+	}
+
+	for _, test := range []struct {
+		obj  pdfObject
+		keys []string
+	}{
+		{root, []string{
+			"/First " + ref("Chapter 1"), "/Last " + ref("Chapter 2"), "/Count 4"}},
+		{byTitle["Chapter 1"], []string{
+			"/Next " + ref("Chapter 2"), "/First " + ref("Section 1.1"),
+			"/Last " + ref("Section 1.2"), "/Count 2",
+			fmt.Sprintf("/Dest [%d 0 R /Fit]", pages[0])}},
+		{byTitle["Chapter 2"], []string{
+			"/Prev " + ref("Chapter 1"), "/Count -1"}},
+		{byTitle["Section 1.2"], []string{
+			"/Parent " + ref("Chapter 1"), "/Prev " + ref("Section 1.1"),
+			fmt.Sprintf("/Dest [%d 0 R /Fit]", pages[2])}},
+		{byTitle["Section 2.1"], []string{"/Parent " + ref("Chapter 2")}},
+	} {
+		for _, key := range test.keys {
+			if !bytes.Contains(test.obj.dict, []byte(key)) {
+				t.Errorf("missing %s in object %d:\n%s", key, test.obj.id, test.obj.dict)
+			}
+		}
+	}
+}
+
+func TestEmptyOutline(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WritePage(21*CM, 29.7*CM, nil)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("/Outlines")) {
+		t.Errorf("empty outline should not be written")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"runtime"
+	"sync"
+)
+
+// This file implements encoding of JPEG pages in parallel.
+
+// WriteJPEGPages writes a page for each image, in order, encoded
+// as JPEG with the given quality. Images are encoded concurrently
+// by the given number of workers, or one per CPU if workers is not
+// positive, a few images ahead of those being written. The output
+// is the same as that of calling WriteJPEGPage in sequence: after
+// an error, the pages of the images before the failing one are
+// written, and no more images are handed to the workers, though
+// those they already took are still encoded.
+func (p *PDFWriter) WriteJPEGPages(imgs []image.Image, quality, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, len(imgs))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// ahead bounds the number of encoded images waiting
+	// to be written
+	ahead := make(chan struct{}, 2*workers)
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	// failed is closed by the first worker failing to encode
+	failed := make(chan struct{})
+	var fail sync.Once
+	go func() {
+		defer close(jobs)
+		for i := range imgs {
+			select {
+			case ahead <- struct{}{}:
+			case <-done:
+				return
+			case <-failed:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			case <-failed:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				buf := new(bytes.Buffer)
+				err := jpeg.Encode(buf, imgs[i], &jpeg.Options{Quality: quality})
+				if err != nil {
+					fail.Do(func() { close(failed) })
+				}
+				results[i] <- result{buf.Bytes(), err}
+			}
+		}()
+	}
+	for i, img := range imgs {
+		r := <-results[i]
+		if r.err != nil {
+			return fmt.Errorf("image %d: %v", i, r.err)
+		}
+		if _, err := p.WriteJPEGPage(img, r.data); err != nil {
+			return err
+		}
+		<-ahead
+	}
+	return nil
+}
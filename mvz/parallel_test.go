@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// testImages returns n images of distinct gradients.
+func testImages(n, size int) []image.Image {
+	imgs := make([]image.Image, n)
+	for i := range imgs {
+		img := image.NewRGBA(image.Rect(0, 0, size, size+i))
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < size; x++ {
+				img.Set(x, y, color.RGBA{uint8(x + i), uint8(y), uint8(x * y), 255})
+			}
+		}
+		imgs[i] = img
+	}
+	return imgs
+}
+
+func TestWriteJPEGPages(t *testing.T) {
+	imgs := testImages(20, 32)
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	seq := new(bytes.Buffer)
+	p, _ := NewPDFWriter(seq)
+	p.SetDeterministic()
+	p.WriteInfo("pages", mtime)
+	for _, img := range imgs {
+		data := new(bytes.Buffer)
+		if err := jpeg.Encode(data, img, &jpeg.Options{Quality: 80}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.WriteJPEGPage(img, data.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 1, 3, 50} {
+		par := new(bytes.Buffer)
+		p, _ := NewPDFWriter(par)
+		p.SetDeterministic()
+		p.WriteInfo("pages", mtime)
+		if err := p.WriteJPEGPages(imgs, 80, workers); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(seq.Bytes(), par.Bytes()) {
+			t.Errorf("%d workers: output differs from sequential writes", workers)
+		}
+	}
+}
+
+func TestWriteJPEGPagesError(t *testing.T) {
+	imgs := testImages(10, 8)
+	// too large to encode as JPEG
+	imgs[4] = image.NewGray(image.Rect(0, 0, 1<<16, 1))
+	p, _ := NewPDFWriter(ioutil.Discard)
+	if err := p.WriteJPEGPages(imgs, 80, 2); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(p.pages) != 4 {
+		t.Errorf("got %d pages written, want 4", len(p.pages))
+	}
+}
+
+func benchmarkJPEGPages(b *testing.B, workers int) {
+	imgs := testImages(32, 256)
+	for i := 0; i < b.N; i++ {
+		p, _ := NewPDFWriter(ioutil.Discard)
+		if err := p.WriteJPEGPages(imgs, 85, workers); err != nil {
+			b.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJPEGPagesSequential(b *testing.B) { benchmarkJPEGPages(b, 1) }
+func BenchmarkJPEGPagesParallel(b *testing.B)   { benchmarkJPEGPages(b, 0) }
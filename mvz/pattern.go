@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// This file implements tiling patterns.
+
+// A PatternRef refers to a pattern registered with
+// RegisterTilingPattern.
+type PatternRef struct {
+	id PDFID
+}
+
+// RegisterTilingPattern writes a tiling pattern repeating img,
+// compressed losslessly and scaled to tileW by tileH, to fill paths
+// with (*Canvas).FillWithPattern. Tiles are aligned on the origin of
+// the page, so that adjacent paths filled with the pattern join up.
+func (p *PDFWriter) RegisterTilingPattern(img image.Image, tileW, tileH Length) (PatternRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tileW <= 0 || tileH <= 0 {
+		return PatternRef{}, fmt.Errorf("invalid tile size %.2fx%.2f", tileW, tileH)
+	}
+	ref, err := p.registerImage(img, nil)
+	if err != nil {
+		return PatternRef{}, err
+	}
+	var cs ContentStream
+	cs.DrawImage(ref, 0, 0, tileW, tileH)
+	id, _ := p.startObj()
+	p.print("/Type /Pattern")
+	p.print("/PatternType 1") // tiling
+	p.print("/PaintType 1")   // colored
+	p.print("/TilingType 1")  // constant spacing
+	p.printf("/BBox [0 0 %.2f %.2f]", tileW, tileH)
+	p.printf("/XStep %.2f", tileW)
+	p.printf("/YStep %.2f", tileH)
+	p.print("/Resources <<")
+	p.writeResources(cs.res)
+	p.print(">>")
+	p.writeStream(p.encodeStream(cs.buf.Bytes(), p.contentFilters()...))
+	return PatternRef{id}, p.err
+}
+
+// FillWithPattern fills the current path with a pattern registered
+// with RegisterTilingPattern, using the nonzero winding rule, and
+// starts a new path.
+func (c *Canvas) FillWithPattern(ref PatternRef) {
+	if c.path.Len() == 0 {
+		return
+	}
+	fill := c.fill
+	c.fill = fmt.Sprintf("/Pattern cs /%s scn", c.cs.resourceName("Pattern", "P", ref.id))
+	c.paint("f")
+	c.fill = fill
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestTilingPattern(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	tile := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := 0; i < 8; i++ {
+		tile.Pix[7*8+i] = 0xff
+	}
+	pat, err := p.RegisterTilingPattern(tile, 36, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := p.Canvas(page)
+	c.SetFillColor(1, 0, 0)
+	c.Rect(0, 0, A4.Width, A4.Height)
+	c.FillWithPattern(pat)
+	c.Rect(0, 0, 72, 72)
+	c.Fill()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	obj := objs[pat.id-1]
+	for _, s := range []string{
+		"/Type /Pattern\n/PatternType 1\n/PaintType 1\n/TilingType 1\n",
+		"/BBox [0 0 36.00 18.00]\n/XStep 36.00\n/YStep 18.00\n",
+		fmt.Sprintf("/XObject << /I0 %d 0 R >>", pat.id-1),
+	} {
+		if !bytes.Contains(obj.dict, []byte(s)) {
+			t.Errorf("missing %q in pattern %s", s, obj.dict)
+		}
+	}
+	if want := "q\n36.00 0 0 18.00 0.00 0.00 cm\n/I0 Do\nQ\n"; string(obj.stream) != want {
+		t.Errorf("got pattern content %q, want %q", obj.stream, want)
+	}
+	res := fmt.Sprintf("/Pattern << /P0 %d 0 R >>", pat.id)
+	if !bytes.Contains(objs[page-1].dict, []byte(res)) {
+		t.Errorf("missing %s in page %s", res, objs[page-1].dict)
+	}
+	content := pageContents(t, buf.Bytes(), page)
+	// the fill color is kept for the next path
+	want := "q\n/Pattern cs /P0 scn\n0.00 0.00 595.28 841.89 re\nf\nQ\n" +
+		"q\n1 0 0 rg\n0.00 0.00 72.00 72.00 re\nf\nQ\n"
+	if string(content) != want {
+		t.Errorf("got content stream %q, want %q", content, want)
+	}
+
+	if _, err := p.RegisterTilingPattern(tile, 0, 10); err == nil {
+		t.Error("expected error for empty tile")
+	}
+}
@@ -1,29 +1,156 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"hash"
 	"image"
+	"image/jpeg"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
 	"time"
+	"unicode/utf16"
 )
 
 // This file implements simple writing of PDF files
 // with JPEG pages.
 
+// A PDFWriter writes a PDF document.
+//
+// The methods writing pages and images (WritePage, WriteBlankPage,
+// InsertBlankPage, the WriteJPEGPage, WriteImagePage, WritePNGPage
+// and WriteBilevelPage families, WriteImageAsJPEG,
+// WriteImageLossless, RegisterImage, SetSoftMask,
+// RegisterExtGState, RegisterLinearGradient,
+// RegisterTilingPattern, WriteImageFitted, Impose, DrawImage,
+// DrawImageTransform, DrawText, DrawTextBox, AddTextLayer,
+// SetPageMargins, ContentBox and AttachFile), Flush and Close may
+// be called concurrently: calls are serialized, and pages and
+// objects are numbered in the order the calls are made, so that
+// the output follows call order. Other methods, including the
+// setters, must not be called concurrently with them.
 type PDFWriter struct {
+	// DPI is the resolution of image pages, used to compute
+	// their physical size. It defaults to DefaultDPI. JPEG pages
+	// use the resolution in their EXIF data instead, if any.
+	DPI float64
+	// Compress enables FlateDecode compression of content streams.
+	Compress bool
+	// XRefStream writes the cross-reference table as a compressed
+	// stream, which requires PDF 1.5.
+	XRefStream bool
+	// ObjectStreams packs the dictionaries written by Flush (pages,
+	// outline, info and catalog) into a compressed object stream.
+	// It implies XRefStream, and is ignored in encrypted documents.
+	ObjectStreams bool
+	// ASCII85 encodes streams as ASCII text, for transports that
+	// do not preserve binary data. It makes them 25% larger.
+	ASCII85 bool
+	// KeepProgressive embeds progressive JPEG images as they are,
+	// for viewers known to display them. By default they are
+	// transcoded to baseline JPEG, losing some quality.
+	KeepProgressive bool
+	// StripMetadata removes EXIF and other application data, such
+	// as thumbnails, from JPEG images before embedding them. The
+	// resolution and orientation of JPEG pages still follow EXIF.
+	StripMetadata bool
+	// Invert swaps black and white, or inverts colors, in images
+	// written while it is set, without changing their samples.
+	// Soft masks and images with a palette are not inverted.
+	Invert bool
+	// Interpolate asks viewers to smooth images written while it is
+	// set when they are scaled up. It can blur scanned text.
+	Interpolate bool
+	// PDFA1b makes the document conform to PDF/A-1b, for archiving.
+	// It must be set before anything is written. Flush then adds XMP
+	// metadata and an sRGB output intent, and fails if the document
+	// is encrypted, uses fonts that are not embedded, or needs a PDF
+	// version above 1.4, or Interpolate was used.
+	PDFA1b bool
+
 	w       io.Writer
-	h       hash.Hash // for document ID
-	w2      io.Writer // multiwriter(w, h)
+	bw      *bufio.Writer // buffers w, may be nil
+	h       hash.Hash     // for document ID
+	w2      io.Writer     // multiwriter(bw, h)
 	offset  int
-	objects []int // offsets
-	pages   []PDFID
-	err     error
+	version string // PDF version; raised by features that need it
+	header  string // version written in the header
+	objects []int  // offsets
+	pages   []*pdfPage
+	docID   []byte // permanent document identifier
+	info    Info
+	xmp     *XMPMetadata
+	outline []*Bookmark
+	labels  []PageLabelRange
+	fonts   map[string]*Font // by base font name
+	gstates map[ExtGStateOpts]PDFID
+	font    *Font            // current font
+	text    textState        // of text drawn by DrawText
+	crypt   *securityHandler // nil if not encrypted
+	cur     PDFID            // object being written
+	// applications named in the document information
+	producer, creator string
+	// view set by SetOpenAction, nil for the viewer default
+	openAction  *OpenAction
+	viewerPrefs ViewerPreferences
+	// width of the narrowest bar of barcodes
+	barcodeModule Length
+	// fields of the interactive form
+	fields     []formField
+	signatures []*SignaturePlaceholder
+	// destinations added by AddNamedDestination
+	dests []namedDest
+	// files embedded by AttachFile
+	attachments []attachment
+	// whether an image was written with Interpolate set
+	interpolated bool
+	// whether SetSoftMask was used
+	softMasked bool
+	// ICC profile of the output intent, if any
+	outputProfile PDFID
+	// default page margins, nil if not set, and those of pages
+	// of given sizes
+	margins     *Margins
+	sizeMargins map[PageSize]Margins
+	// page headers and footers, drawn by Flush
+	headerFunc func(page, total int) string
+	footerFunc func(page, total int) string
+	// watermark drawn by Flush
+	watermark        string
+	watermarkOpacity float64
+	// version set by SetVersion, and warnings about exceeding it
+	requested string
+	warnings  []string
+	// progress reporting
+	progress      func(pagesWritten, pagesTotal int)
+	expectedPages int
+	// serializes the methods writing pages and images
+	mu sync.Mutex
+	// deterministic mode
+	deterministic bool
+	counter       uint64 // for random
+	// objects written by Flush
+	metadata PDFID
+	outlines PDFID
+	// object stream being filled by Flush
+	packing bool
+	pending *pendingObj
+	objStm  objStm
+	// set by Flush and Close
+	flushed, closed bool
+
+	err error
 }
 
+const producer = "mvztopdf 1.0"
+
 const (
 	INFO_ID    = PDFID(1)
 	CATALOG_ID = PDFID(2)
@@ -39,160 +166,1242 @@ const (
 	CM   Length = 72 / 2.54
 )
 
-func NewPDFWriter(w io.Writer) (*PDFWriter, error) {
+// A PageSize is the size of a page, in points.
+type PageSize struct {
+	Width, Height Length
+}
+
+// Standard paper sizes, in portrait orientation.
+var (
+	A3     = PageSize{29.7 * CM, 42 * CM}
+	A4     = PageSize{21 * CM, 29.7 * CM}
+	A5     = PageSize{14.8 * CM, 21 * CM}
+	Letter = PageSize{8.5 * INCH, 11 * INCH}
+	Legal  = PageSize{8.5 * INCH, 14 * INCH}
+)
+
+// Landscape returns the page size with swapped dimensions.
+func (s PageSize) Landscape() PageSize {
+	return PageSize{s.Height, s.Width}
+}
+
+// NewPDFWriter returns a PDFWriter writing to w, configured by
+// opts. Output is buffered: it is complete only after Flush returns.
+func NewPDFWriter(w io.Writer, opts ...Option) (*PDFWriter, error) {
+	p, err := newPDFWriter(w, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func newPDFWriter(w io.Writer, buffered bool) (*PDFWriter, error) {
 	p := &PDFWriter{
-		w:       w,
-		h:       md5.New(),
-		objects: []int{0, 0, 0},
+		DPI:           DefaultDPI,
+		w:             w,
+		h:             md5.New(),
+		version:       "1.3",
+		objects:       []int{0, 0, 0},
+		expectedPages: -1,
+		producer:      producer,
+	}
+	if buffered {
+		p.bw = bufio.NewWriter(w)
+		p.w2 = io.MultiWriter(p.bw, p.h)
+	} else {
+		p.w2 = io.MultiWriter(p.w, p.h)
+	}
+	// the header is written with the first object
+	return p, nil
+}
+
+// requireVersion raises the PDF version of the document to at
+// least v. Once the header is written, the catalog declares it.
+func (p *PDFWriter) requireVersion(v string) {
+	if v > p.version {
+		if p.requested != "" {
+			p.warnings = append(p.warnings, fmt.Sprintf("document requires PDF %s, above the requested %s", v, p.requested))
+		}
+		p.version = v
+	}
+}
+
+// SetVersion sets the PDF version declared in the header, 1.3 by
+// default. It must be called before anything is written. Features
+// needing a later version, such as transparency (1.4), object
+// streams (1.5) or AES-256 encryption (2.0), still raise it, with
+// a warning reported by Warnings.
+func (p *PDFWriter) SetVersion(major, minor int) error {
+	return p.setVersion(fmt.Sprintf("%d.%d", major, minor))
+}
+
+func (p *PDFWriter) setVersion(v string) error {
+	if !validVersion(v) {
+		return fmt.Errorf("invalid PDF version %s", v)
+	}
+	if p.offset > 0 {
+		return fmt.Errorf("PDF version set after writing started")
+	}
+	p.requested, p.version = v, v
+	return nil
+}
+
+// Warnings returns the problems found while writing the document
+// that did not prevent writing it, such as raising the PDF version
+// above the one set by SetVersion, or transcoding progressive JPEG
+// images.
+func (p *PDFWriter) Warnings() []string {
+	return p.warnings
+}
+
+// writeHeader writes the file header, if it was not written yet.
+func (p *PDFWriter) writeHeader() {
+	if p.offset > 0 {
+		return
+	}
+	if p.XRefStream || p.ObjectStreams {
+		p.requireVersion("1.5")
+	}
+	if p.PDFA1b {
+		p.requireVersion("1.4")
+	}
+	p.header = p.version
+	p.printf("%%PDF-%s", p.header)
+	// bytes above 127 mark the file as binary, as PDF/A requires
+	p.print("%\xe2\xe3\xcf\xd3")
+}
+
+// SetDeterministic makes the output depend only on what is written:
+// the document identifier is derived from the contents, and the
+// random values used by encryption are a fixed sequence, so writing
+// the same document twice gives identical files. All timestamps come
+// from the caller, through WriteInfo, WriteInfoFull and WriteXMP.
+//
+// Deterministic encryption is weaker, as it reuses salts and
+// initialization vectors across documents.
+func (p *PDFWriter) SetDeterministic() {
+	p.deterministic = true
+	if p.crypt == nil {
+		p.docID = nil
 	}
-	p.w2 = io.MultiWriter(p.w, p.h)
-	p.print("%PDF-1.3")
-	return p, p.err
+}
+
+// Info holds the entries of the document information dictionary.
+// Empty strings are omitted.
+type Info struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	ModTime  time.Time // creation and modification date
 }
 
 func (p *PDFWriter) WriteInfo(title string, mtime time.Time) error {
-	p.objects[INFO_ID-1] = p.offset
-	p.printf("%d 0 obj", INFO_ID)
-	p.print("<<")
-	p.printf("/Title (%s)", title)
-	p.printf("/CreationDate (D:%s)", mtime.Format("20060102150405"))
-	p.printf("/ModDate (D:%s)", mtime.Format("20060102150405"))
-	p.print("/Producer (mvztopdf 1.0)")
-	p.endObj()
+	return p.WriteInfoFull(Info{Title: title, ModTime: mtime})
+}
 
-	// catalog
-	p.objects[CATALOG_ID-1] = p.offset
-	p.printf("%d 0 obj", CATALOG_ID)
-	p.print("<<")
+// SetProducer sets the name of the application converting the
+// document to PDF, written to its information dictionary and XMP
+// metadata. It defaults to mvztopdf; an empty name is omitted.
+func (p *PDFWriter) SetProducer(name string) {
+	p.producer = name
+}
+
+// SetCreator sets the name of the application that created the
+// original document, written like the producer. It is omitted
+// by default.
+func (p *PDFWriter) SetCreator(name string) {
+	p.creator = name
+}
+
+// WriteInfoFull sets the document information dictionary,
+// which is written by Flush.
+func (p *PDFWriter) WriteInfoFull(info Info) error {
+	// The permanent identifier only depends on the document
+	// info, so that it is reproducible given a fixed mtime.
+	h := md5.New()
+	for _, s := range []string{info.Title, info.Author, info.Subject, info.Keywords} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	io.WriteString(h, info.ModTime.Format(time.RFC3339Nano))
+	if p.crypt == nil && !p.deterministic {
+		// otherwise the encryption key depends on it
+		p.docID = h.Sum(nil)
+	}
+	p.info = info
+	return p.err
+}
+
+func (p *PDFWriter) writeInfo() error {
+	info := p.info
+	p.startObjID(INFO_ID)
+	for _, e := range []struct{ key, value string }{
+		{"Title", info.Title},
+		{"Author", info.Author},
+		{"Subject", info.Subject},
+		{"Keywords", info.Keywords},
+	} {
+		if e.value != "" {
+			p.printf("/%s %s", e.key, p.str(e.value))
+		}
+	}
+	if !info.ModTime.IsZero() {
+		p.printf("/CreationDate %s", p.str(pdfDate(info.ModTime)))
+		p.printf("/ModDate %s", p.str(pdfDate(info.ModTime)))
+	}
+	if p.creator != "" {
+		p.printf("/Creator %s", p.str(p.creator))
+	}
+	if p.producer != "" {
+		p.printf("/Producer %s", p.str(p.producer))
+	}
+	return p.endObj()
+}
+
+func (p *PDFWriter) writeCatalog() error {
+	p.startObjID(CATALOG_ID)
 	p.print("/Type /Catalog")
+	if p.version > p.header {
+		p.printf("/Version /%s", p.version)
+	}
 	p.printf("/Pages %d 0 R", PAGES_ID)
-	p.endObj()
-	return p.err
+	if p.metadata != 0 {
+		p.printf("/Metadata %d 0 R", p.metadata)
+	}
+	if p.outlines != 0 {
+		p.printf("/Outlines %d 0 R", p.outlines)
+	}
+	if mode := p.pageMode(); mode != "" {
+		p.printf("/PageMode %s", mode)
+	}
+	if prefs := p.viewerPreferences(); prefs != "" {
+		p.printf("/ViewerPreferences %s", prefs)
+	}
+	if len(p.labels) > 0 {
+		p.printf("/PageLabels %s", p.pageLabels())
+	}
+	if p.openAction != nil {
+		p.printf("/OpenAction %s", p.openActionDest())
+	}
+	if len(p.dests) > 0 || len(p.attachments) > 0 {
+		p.printf("/Names %s", p.names())
+	}
+	if len(p.fields) > 0 {
+		p.printf("/AcroForm %s", p.acroForm())
+	}
+	if p.outputProfile != 0 {
+		p.printf("/OutputIntents %s", p.outputIntents())
+	}
+	return p.endObj()
 }
 
-func (p *PDFWriter) WritePage(x, y Length, data []byte) (PDFID, error) {
-	id, _ := p.startObj()
+// A pdfPage holds the contents of a page dictionary,
+// which is written by Flush.
+type pdfPage struct {
+	id            PDFID
+	width, height Length
+	contents      []PDFID
+	extra         ContentStream // content added after the page was written
+	resources     resources     // shared with extra
+	annots        []PDFID
+	rotate        int
+	margins       *Margins // nil for the document margins
+	background    string   // fill color operator, or empty
+}
+
+// resources maps resource categories (XObject, Font...)
+// to named objects.
+type resources map[string]map[string]PDFID
+
+func (r resources) add(category, name string, id PDFID) {
+	if r[category] == nil {
+		r[category] = make(map[string]PDFID)
+	}
+	r[category][name] = id
+}
+
+// newPage returns a page record with the given contents.
+func newPage(id PDFID, width, height Length, contents PDFID, res resources) *pdfPage {
+	pg := &pdfPage{
+		id: id, width: width, height: height,
+		contents:  []PDFID{contents},
+		resources: make(resources),
+	}
+	for cat, refs := range res {
+		for name, ref := range refs {
+			pg.resources.add(cat, name, ref)
+		}
+	}
+	pg.extra.res = pg.resources
+	return pg
+}
+
+// WritePage writes a page of the given size with contents cs,
+// which may be nil for an empty page.
+func (p *PDFWriter) WritePage(x, y Length, cs *ContentStream) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeContentPage(x, y, cs)
+}
+
+func (p *PDFWriter) writeContentPage(x, y Length, cs *ContentStream) (PDFID, error) {
+	if cs == nil {
+		cs = new(ContentStream)
+	}
+	id := p.reserveObj()
+	if err := p.addPage(id, x, y, cs); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// addPage writes the contents of page id, and registers it
+// to be written by Flush.
+func (p *PDFWriter) addPage(id PDFID, x, y Length, cs *ContentStream) error {
+	streamId, err := p.writeStreamObject(cs.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	p.pages = append(p.pages, newPage(id, x, y, streamId, cs.res))
+	if p.progress != nil {
+		p.progress(len(p.pages), p.expectedPages)
+	}
+	return nil
+}
+
+// SetProgress sets a function called after each page is written,
+// with the number of pages written so far and the number expected,
+// as set by ExpectPages, or -1.
+func (p *PDFWriter) SetProgress(progress func(pagesWritten, pagesTotal int)) {
+	p.progress = progress
+}
+
+// ExpectPages sets the number of pages passed to the progress
+// function.
+func (p *PDFWriter) ExpectPages(n int) {
+	p.expectedPages = n
+}
+
+// WriteBlankPage writes an empty page of the given size.
+func (p *PDFWriter) WriteBlankPage(size PageSize) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeContentPage(size.Width, size.Height, nil)
+}
+
+// InsertBlankPage writes an empty page of the given size, placed
+// at index at of the pages written so far, from 0 to the number
+// of pages.
+func (p *PDFWriter) InsertBlankPage(size PageSize, at int) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if at < 0 || at > len(p.pages) {
+		return 0, fmt.Errorf("page index %d out of range [0, %d]", at, len(p.pages))
+	}
+	id, err := p.writeContentPage(size.Width, size.Height, nil)
+	if err != nil {
+		return 0, err
+	}
+	pg := p.pages[len(p.pages)-1]
+	copy(p.pages[at+1:], p.pages[at:len(p.pages)-1])
+	p.pages[at] = pg
+	return id, nil
+}
+
+// page returns the page with the given object number.
+func (p *PDFWriter) page(id PDFID) (*pdfPage, error) {
+	for _, pg := range p.pages {
+		if pg.id == id {
+			return pg, nil
+		}
+	}
+	return nil, fmt.Errorf("no such page: %d", id)
+}
+
+// SetPageRotation sets the angle, clockwise in degrees,
+// by which page is rotated when displayed.
+func (p *PDFWriter) SetPageRotation(page PDFID, degrees int) error {
+	switch degrees {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("invalid page rotation %d: must be a multiple of 90", degrees)
+	}
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	pg.rotate = degrees
+	return nil
+}
+
+// SetPageBackground fills page with an RGB color, under all of its
+// other content. Components are clamped to [0, 1].
+func (p *PDFWriter) SetPageBackground(page PDFID, r, g, b float64) error {
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	pg.background = colorOp("rg", r, g, b)
+	return nil
+}
+
+// ReorderPages sets the order of the pages of the document.
+// order must list every page written so far exactly once.
+func (p *PDFWriter) ReorderPages(order []PDFID) error {
+	if len(order) != len(p.pages) {
+		return fmt.Errorf("got %d pages, document has %d", len(order), len(p.pages))
+	}
+	pages := make([]*pdfPage, len(order))
+	seen := make(map[PDFID]bool)
+	for i, id := range order {
+		if seen[id] {
+			return fmt.Errorf("page %d listed twice", id)
+		}
+		seen[id] = true
+		pg, err := p.page(id)
+		if err != nil {
+			return err
+		}
+		pages[i] = pg
+	}
+	p.pages = pages
+	return nil
+}
+
+func (p *PDFWriter) writePage(pg *pdfPage) error {
+	p.startObjID(pg.id)
 	p.print("/Type /Page")
 	p.printf("/Parent %d 0 R", PAGES_ID) // required
-	p.printf("/MediaBox [0 0 %.2f %.2f]", x, y)
-	p.printf("/CropBox [0 0 %.2f %.2f]", x, y)
-	p.printf("/Contents %d 0 R", id+1)
-	p.endObj()
-	streamId, _ := p.writeStreamObject(data)
-	if p.err == nil && streamId != id+1 {
-		panic("internal error: streamId != id+1")
+	p.printf("/MediaBox [0 0 %.2f %.2f]", pg.width, pg.height)
+	p.printf("/CropBox [0 0 %.2f %.2f]", pg.width, pg.height)
+	if pg.rotate != 0 {
+		p.printf("/Rotate %d", pg.rotate)
 	}
-	p.pages = append(p.pages, id)
-	return id, p.err
+	if len(pg.contents) == 1 {
+		p.printf("/Contents %d 0 R", pg.contents[0])
+	} else {
+		p.printf("/Contents %s", refArray(pg.contents))
+	}
+	if len(pg.annots) > 0 {
+		p.printf("/Annots %s", refArray(pg.annots))
+	}
+	if len(pg.resources) > 0 {
+		p.print("/Resources <<")
+		p.writeResources(pg.resources)
+		p.print(">>")
+	}
+	return p.endObj()
+}
+
+func (p *PDFWriter) writeResources(res resources) {
+	categories := make([]string, 0, len(res))
+	for cat := range res {
+		categories = append(categories, cat)
+	}
+	sort.Strings(categories)
+	for _, cat := range categories {
+		p.printf("/%s << %s>>", cat, resourceDict(res[cat]))
+	}
+}
+
+// refArray formats an array of references.
+func refArray(ids []PDFID) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("[ ")
+	for _, id := range ids {
+		fmt.Fprintf(buf, "%d 0 R ", id)
+	}
+	buf.WriteString("]")
+	return buf.String()
 }
 
-const DPI = 150
+// resourceDict formats a dictionary of named references,
+// sorted by name.
+func resourceDict(refs map[string]PDFID) string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	for _, name := range names {
+		fmt.Fprintf(buf, "/%s %d 0 R ", name, refs[name])
+	}
+	return buf.String()
+}
+
+const DefaultDPI = 150
 
+// WriteJPEGPage writes a page holding a JPEG image. The color space
+// is chosen according to the number of components of the JPEG data.
+// The resolution and orientation in its EXIF data, if any, set the
+// size and rotation of the page; mirrored orientations are drawn
+// mirrored.
 func (p *PDFWriter) WriteJPEGPage(img image.Image, data []byte) (PDFID, error) {
-	x := Length(img.Bounds().Dx()) / 150 * INCH
-	y := Length(img.Bounds().Dy()) / 150 * INCH
-	id, _ := p.startObj()
-	p.print("/Type /Page")
-	p.printf("/MediaBox [0 0 %.2f %.2f]", x, y)
-	p.printf("/CropBox [0 0 %.2f %.2f]", x, y)
-	p.printf("/Contents %d 0 R", id+1)
-	p.printf("/Resources << /XObject << /I %d 0 R >> >>", id+2)
-	p.endObj()
-	// Postscript code
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeJPEGPage(img.Bounds().Dx(), img.Bounds().Dy(), data)
+}
+
+// WriteJPEGPageConfig is like WriteJPEGPage, taking the dimensions
+// of the image from cfg, as returned by image.DecodeConfig, so that
+// the image need not be decoded.
+func (p *PDFWriter) WriteJPEGPageConfig(cfg image.Config, data []byte) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeJPEGPage(cfg.Width, cfg.Height, data)
+}
+
+// WriteJPEGPageBytes is like WriteJPEGPage, taking the dimensions
+// of the image from the JPEG data, which need not be decoded.
+func (p *PDFWriter) WriteJPEGPageBytes(data []byte) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, h, _, err := jpegDimensions(data)
+	if err != nil {
+		return 0, err
+	}
+	return p.writeJPEGPage(w, h, data)
+}
+
+// WriteImageAsJPEG writes a page holding img, encoded as JPEG at
+// the given quality, which is clamped to the range 1 to 100.
+func (p *PDFWriter) WriteImageAsJPEG(img image.Image, quality int) (PDFID, error) {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
 	buf := new(bytes.Buffer)
-	buf.WriteString("q\n")
-	fmt.Fprintf(buf, "%.2f 0 0 %.2f 0 0 cm\n", x, y)
-	buf.WriteString("/I Do\n")
-	buf.WriteString("Q\n")
-	streamId, _ := p.writeStreamObject(buf.Bytes())
-	if p.err == nil && streamId != id+1 {
-		panic("internal error: streamId != id+1")
-	}
-	// Image
-	imgId, _ := p.writeImage(img.Bounds().Dx(), img.Bounds().Dy(), data)
-	if p.err == nil && imgId != id+2 {
-		panic("internal error: imgId != id+2")
-	}
-	p.pages = append(p.pages, id)
-	return id, p.err
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeJPEGPage(img.Bounds().Dx(), img.Bounds().Dy(), buf.Bytes())
+}
+
+// WriteJPEGPageFrom writes a page holding a JPEG image read from r,
+// copying the image data to the output as it is read. With an
+// io.WriteSeeker as output, the image is not held in memory.
+func (p *PDFWriter) WriteJPEGPageFrom(r io.Reader) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hdr, err := readJPEGHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(hdr))
+	if err != nil {
+		return 0, err
+	}
+	return p.writeJPEGPageFrom(cfg.Width, cfg.Height, hdr, r, -1)
+}
+
+// WriteJPEGPageReader writes a page holding a JPEG image of size
+// bytes read from r, with dimensions given by img, as returned by
+// image.DecodeConfig. The image data is copied to the output as it
+// is read. If size is negative, the image is read until EOF and its
+// length filled in afterwards, which needs an io.WriteSeeker output:
+// on other writers, the image is held in memory until its length
+// is known.
+func (p *PDFWriter) WriteJPEGPageReader(img image.Config, r io.Reader, size int64) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hdr, err := readJPEGHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	return p.writeJPEGPageFrom(img.Width, img.Height, hdr, r, size)
+}
+
+// writeJPEGPageFrom writes a JPEG image page whose data is hdr, as
+// returned by readJPEGHeader, followed by the rest of r; size is
+// the size of the whole image, or negative if unknown.
+func (p *PDFWriter) writeJPEGPageFrom(w, h int, hdr []byte, r io.Reader, size int64) (PDFID, error) {
+	if !p.KeepProgressive && progressiveJPEG(hdr) {
+		// transcoding needs the whole image
+		data, err := ioutil.ReadAll(io.MultiReader(bytes.NewReader(hdr), r))
+		if err != nil {
+			return 0, err
+		}
+		return p.writeJPEGPage(w, h, data)
+	}
+	cs, decode, err := jpegColorSpace(hdr)
+	if err != nil {
+		return 0, err
+	}
+	dict := imageDict{
+		width: w, height: h,
+		filter:     "/DCTDecode",
+		colorSpace: cs,
+		decode:     decode,
+		exif:       jpegEXIF(hdr),
+	}
+	if p.StripMetadata {
+		stripped := stripJPEG(hdr)
+		if size >= 0 {
+			size -= int64(len(hdr) - len(stripped))
+		}
+		hdr = stripped
+	}
+	return p.writeImagePageFrom(dict, io.MultiReader(bytes.NewReader(hdr), r), size, nil)
+}
+
+func (p *PDFWriter) writeJPEGPage(w, h int, data []byte) (PDFID, error) {
+	// EXIF data does not survive transcoding
+	exif := jpegEXIF(data)
+	data, err := p.baselineJPEG(data)
+	if err != nil {
+		return 0, err
+	}
+	cs, decode, err := jpegColorSpace(data)
+	if err != nil {
+		return 0, err
+	}
+	dict := imageDict{
+		width: w, height: h,
+		filter:     "/DCTDecode",
+		colorSpace: cs,
+		decode:     decode,
+		exif:       exif,
+	}
+	if p.StripMetadata {
+		data = stripJPEG(data)
+	}
+	return p.writeImagePage(dict, data, nil)
 }
 
-func (p *PDFWriter) writeImage(w, h int, data []byte) (PDFID, error) {
+// WriteImagePage writes a page holding an encoded image, whose
+// format is detected from its signature. JPEG data is embedded
+// as is, PNG images are decoded and recompressed.
+func (p *PDFWriter) WriteImagePage(data []byte) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch format := imageFormat(data); format {
+	case "jpeg":
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		return p.writeJPEGPage(cfg.Width, cfg.Height, data)
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		return p.writePNGPage(img)
+	case "":
+		return 0, fmt.Errorf("unknown image format")
+	default:
+		return 0, fmt.Errorf("cannot embed %s images", format)
+	}
+}
+
+// WritePNGPage writes a page holding img as a losslessly
+// compressed (FlateDecode) image. Paletted images use an
+// Indexed color space, and gray images DeviceGray. Transparency
+// is kept as a soft mask, unless the image is opaque.
+func (p *PDFWriter) WritePNGPage(img image.Image) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writePNGPage(img)
+}
+
+// WriteImageLossless is WritePNGPage, for images from any source:
+// screenshots and line art that JPEG compression would blur. The
+// color space follows the color model of img: gray, CMYK, indexed
+// for paletted images, and RGB for the others, such as decoded JPEG
+// images.
+func (p *PDFWriter) WriteImageLossless(img image.Image) (PDFID, error) {
+	return p.WritePNGPage(img)
+}
+
+func (p *PDFWriter) writePNGPage(img image.Image) (PDFID, error) {
+	dict, data, mask, err := losslessImage(img)
+	if err != nil {
+		return 0, err
+	}
+	return p.writeImagePage(dict, data, mask)
+}
+
+// losslessImage returns the compressed samples of img, and its
+// compressed alpha channel unless img is opaque.
+func losslessImage(img image.Image) (dict imageDict, data, mask []byte, err error) {
+	cs, pix, err := rawSamples(img)
+	if err != nil {
+		return dict, nil, nil, err
+	}
+	if data, err = deflate(pix); err != nil {
+		return dict, nil, nil, err
+	}
+	if alpha := alphaPixels(img); alpha != nil {
+		if mask, err = deflate(alpha); err != nil {
+			return dict, nil, nil, err
+		}
+	}
+	return imageDict{
+		width:      img.Bounds().Dx(),
+		height:     img.Bounds().Dy(),
+		filter:     "/FlateDecode",
+		colorSpace: cs,
+	}, data, mask, nil
+}
+
+// An imageDict describes the encoding of an image XObject.
+type imageDict struct {
+	width, height int
+	bpc           int // bits per component, 8 if zero
+	filter        string
+	decodeParms   string // filter parameters, if any
+	colorSpace    string
+	decode        string   // decode array, if any
+	smask         PDFID    // soft mask image, if any
+	mask          bool     // whether the image is a soft mask
+	exif          exifInfo // layout of image pages
+}
+
+// writeImagePage writes a page object, its content stream
+// and a full-page image. If mask is not nil, it is written
+// after the image as a FlateDecode compressed soft mask.
+func (p *PDFWriter) writeImagePage(img imageDict, data, mask []byte) (PDFID, error) {
+	return p.writeImagePageFrom(img, bytes.NewReader(data), int64(len(data)), mask)
+}
+
+// writeImagePageFrom is like writeImagePage, reading size bytes of
+// image data from r, or until EOF if size is negative.
+func (p *PDFWriter) writeImagePageFrom(img imageDict, r io.Reader, size int64, mask []byte) (PDFID, error) {
+	xdpi, ydpi := p.DPI, p.DPI
+	if img.exif.xdpi > 0 && img.exif.ydpi > 0 {
+		xdpi, ydpi = img.exif.xdpi, img.exif.ydpi
+	}
+	x := Length(float64(img.width)/xdpi) * INCH
+	y := Length(float64(img.height)/ydpi) * INCH
+	mirror, rotate := img.exif.transform()
+	id := p.reserveObj()
+	if mask != nil {
+		maskId, err := p.writeImage(imageDict{
+			width: img.width, height: img.height,
+			filter:     "/FlateDecode",
+			colorSpace: "/DeviceGray",
+			mask:       true,
+		}, mask)
+		if err != nil {
+			return 0, err
+		}
+		img.smask = maskId
+	}
+	imgId, err := p.writeImageFrom(img, r, size)
+	if err != nil {
+		return 0, err
+	}
+	var cs ContentStream
+	if mirror {
+		cs.drawImage(imgId, x, 0, -x, y)
+	} else {
+		cs.drawImage(imgId, 0, 0, x, y)
+	}
+	if err := p.addPage(id, x, y, &cs); err != nil {
+		return 0, err
+	}
+	p.pages[len(p.pages)-1].rotate = rotate
+	return id, nil
+}
+
+// writeImage writes an image XObject. Samples are 8-bit,
+// with as many components as the color space requires.
+func (p *PDFWriter) writeImage(img imageDict, data []byte) (PDFID, error) {
+	return p.writeImageFrom(img, bytes.NewReader(data), int64(len(data)))
+}
+
+// writeImageFrom is like writeImage, with the image data read
+// as by writeStreamFrom.
+func (p *PDFWriter) writeImageFrom(img imageDict, r io.Reader, size int64) (PDFID, error) {
+	if p.Invert && !img.mask {
+		img.decode = invertedDecode(img.colorSpace, img.decode)
+	}
 	id, _ := p.startObj()
 	p.print("/Type /XObject")
 	p.print("/Subtype /Image")
-	p.print("/Name /I")
-	p.print("/Filter [ /DCTDecode ]") // for JPEG
-	p.printf("/Width %d", w)
-	p.printf("/Height %d", h)
-	p.print("/ColorSpace /DeviceRGB")
-	p.print("/BitsPerComponent 8")
-	p.printf("/Length %d", len(data))
-	p.print(">>") // end dict
-	p.writeStream(data)
-	p.endObj()
+	p.printf("/Width %d", img.width)
+	p.printf("/Height %d", img.height)
+	p.printf("/ColorSpace %s", img.colorSpace)
+	if img.bpc == 0 {
+		p.print("/BitsPerComponent 8")
+	} else {
+		p.printf("/BitsPerComponent %d", img.bpc)
+	}
+	if img.decode != "" {
+		p.printf("/Decode %s", img.decode)
+	}
+	if img.smask != 0 {
+		p.printf("/SMask %d 0 R", img.smask)
+	}
+	if p.Interpolate {
+		p.print("/Interpolate true")
+		p.interpolated = true
+	}
+	p.writeStreamFrom(r, size, streamFilter{name: img.filter, parms: img.decodeParms})
 	return id, p.err
 }
 
 func (p *PDFWriter) writeStreamObject(data []byte) (PDFID, error) {
 	id, _ := p.startObj()
-	p.printf("/Length %d", len(data))
-	p.print(">>") // end dict
-	p.writeStream(data)
-	p.print("endobj")
+	p.writeStream(p.encodeStream(data, p.contentFilters()...))
 	return id, p.err
 }
 
+// writeStream ends a stream dictionary with the stream length,
+// and writes data, encrypted if the document is, ending the object.
 func (p *PDFWriter) writeStream(data []byte) {
+	p.writeRawStream(p.encrypt(data))
+}
+
+// writeRawStream is like writeStream, without encryption.
+func (p *PDFWriter) writeRawStream(data []byte) {
+	if p.pending != nil {
+		p.unpend()
+	}
+	p.printf("/Length %d", len(data))
+	p.print(">>") // end dict
 	p.print("stream")
 	n, err := p.w2.Write(data)
 	p.offset += n
 	p.err = err
 	p.print("\nendstream")
+	p.print("endobj")
+}
+
+// lengthWidth is the width of the /Length placeholder of streams
+// backpatched by writeStreamFrom.
+const lengthWidth = 10
+
+// writeStreamFrom is like writeStream, with size bytes of data read
+// from r, or until EOF if size is negative, to be encoded with filters
+// as by encodeStream. The data is copied to the output as it is read,
+// unless it must be encoded or encrypted, or its size is unknown and
+// the output cannot seek back to fill in the length: the data is then
+// read in memory first, as /Length must precede it.
+// The document identifier is computed from the placeholder length.
+func (p *PDFWriter) writeStreamFrom(r io.Reader, size int64, filters ...streamFilter) {
+	ws, seekable := p.w.(io.WriteSeeker)
+	needsEncoding := false
+	for _, f := range p.streamFilters(filters) {
+		needsEncoding = needsEncoding || f.encode != nil
+	}
+	if p.crypt != nil || needsEncoding || (size < 0 && !seekable) {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			p.err = err
+			return
+		}
+		if size >= 0 && int64(len(data)) != size {
+			p.err = fmt.Errorf("stream has %d bytes, expected %d", len(data), size)
+			return
+		}
+		p.writeStream(p.encodeStream(data, filters...))
+		return
+	}
+	p.printFilters(filters)
+	if p.pending != nil {
+		p.unpend()
+	}
+	var lengthOff int
+	if size >= 0 {
+		p.printf("/Length %d", size)
+	} else {
+		lengthOff = p.offset + len("/Length ")
+		p.printf("/Length %*s", lengthWidth, "")
+	}
+	p.print(">>") // end dict
+	p.print("stream")
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	n, err := io.Copy(p.w2, r)
+	p.offset += int(n)
+	if err != nil {
+		p.err = err
+		return
+	}
+	if size >= 0 && n != size {
+		p.err = fmt.Errorf("stream has %d bytes, expected %d", n, size)
+		return
+	}
+	p.print("\nendstream")
+	p.print("endobj")
+	if size < 0 && p.err == nil {
+		p.err = p.patchLength(ws, lengthOff, n)
+	}
+}
+
+// patchLength overwrites the /Length placeholder at offset off
+// with n.
+func (p *PDFWriter) patchLength(ws io.WriteSeeker, off int, n int64) error {
+	if ws == nil {
+		return fmt.Errorf("internal error: stream length of unknown size on an output that cannot seek")
+	}
+	return p.patch(ws, off, fmt.Sprintf("%-*d", lengthWidth, n))
+}
+
+// patch overwrites the output at offset off with s.
+func (p *PDFWriter) patch(ws io.WriteSeeker, off int, s string) error {
+	if p.bw != nil {
+		if err := p.bw.Flush(); err != nil {
+			return err
+		}
+	}
+	cur, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(cur-int64(p.offset-off), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(ws, s); err != nil {
+		return err
+	}
+	_, err = ws.Seek(cur, io.SeekStart)
+	return err
+}
+
+// BytesWritten returns the number of bytes of the document written
+// so far. After Flush, it is the length of the file.
+func (p *PDFWriter) BytesWritten() int {
+	return p.offset
 }
 
 func (p *PDFWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.flush()
+}
+
+func (p *PDFWriter) flush() error {
+	if p.flushed && p.err != nil {
+		// a failed flush is not retried
+		return p.err
+	}
+	p.flushed = true
+	if p.ObjectStreams {
+		p.XRefStream = true
+		// strings of packed objects cannot be encrypted
+		p.packing = p.crypt == nil
+	}
+	if p.openAction != nil && len(p.pages) == 0 {
+		p.err = fmt.Errorf("open action in a document without pages")
+		return p.err
+	}
 	// pages
-	p.objects[PAGES_ID-1] = p.offset
-	p.printf("%d 0 obj", PAGES_ID)
-	p.print("<<")
+	if err := p.drawHeaders(); err != nil {
+		p.err = err
+		return err
+	}
+	if err := p.drawWatermark(); err != nil {
+		p.err = err
+		return err
+	}
+	if p.PDFA1b {
+		if err := p.preparePDFA(); err != nil {
+			p.err = err
+			return err
+		}
+	}
+	for _, page := range p.pages {
+		if page.background != "" {
+			// drawn first, under the contents
+			id, _ := p.writeStreamObject([]byte(fmt.Sprintf("q\n%s\n0 0 %.2f %.2f re\nf\nQ\n",
+				page.background, page.width, page.height)))
+			page.contents = append([]PDFID{id}, page.contents...)
+		}
+		if page.extra.Len() > 0 {
+			id, _ := p.writeStreamObject(page.extra.buf.Bytes())
+			page.contents = append(page.contents, id)
+		}
+		p.writePage(page)
+	}
+	p.startObjID(PAGES_ID)
 	p.print("/Type /Pages")
 	buf := new(bytes.Buffer)
 	for _, page := range p.pages {
-		fmt.Fprintf(buf, "%d 0 R ", page)
+		fmt.Fprintf(buf, "%d 0 R ", page.id)
 	}
 	p.printf("/Kids [ %s]", buf.String())
 	p.printf("/Count %d", len(p.pages))
 	p.endObj()
+	p.writeFonts()
+	// document info and catalog
+	p.syncXMP()
+	p.writeInfo()
+	if p.xmp != nil {
+		p.metadata, _ = p.writeXMP()
+	}
+	if len(p.outline) > 0 {
+		p.outlines, _ = p.writeOutline()
+	}
+	if p.XRefStream {
+		p.requireVersion("1.5")
+	}
+	p.writeCatalog()
+	p.packing = false
+	if len(p.objStm.index) > 0 {
+		p.writeObjStm()
+	}
 	if p.err != nil {
 		return p.err
 	}
-	// xref table
-	xrefOff := p.offset
+	// the second identifier changes with contents.
+	id := hex.EncodeToString(p.h.Sum(nil))
+	permID := id
+	if p.docID != nil {
+		permID = hex.EncodeToString(p.docID)
+	}
+	trailer := fmt.Sprintf("/Info %d 0 R\n/Root %d 0 R\n/ID [<%s> <%s>]",
+		INFO_ID, CATALOG_ID, permID, id)
+	if p.crypt != nil {
+		trailer += fmt.Sprintf("\n/Encrypt %d 0 R", p.crypt.id)
+	}
+	var xrefOff int
+	if p.XRefStream {
+		xrefOff = p.writeXRefStream(trailer)
+	} else {
+		xrefOff = p.writeXRefTable(trailer)
+	}
+	// end
+	p.print("startxref")
+	p.printf("%d", xrefOff)
+	p.print("%%EOF")
+	if p.err == nil && p.bw != nil {
+		p.err = p.bw.Flush()
+	}
+	if p.err == nil {
+		p.err = p.patchByteRanges()
+	}
+	return p.err
+}
+
+// Close completes the document, calling Flush unless it was called,
+// then syncs and closes the underlying writer if it has Sync or
+// Close methods. Closing again returns the same error.
+func (p *PDFWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return p.err
+	}
+	p.closed = true
+	if !p.flushed {
+		if err := p.flush(); err != nil && p.err == nil {
+			p.err = err
+		}
+	}
+	if s, ok := p.w.(interface{ Sync() error }); ok && p.err == nil {
+		p.err = s.Sync()
+	}
+	if c, ok := p.w.(io.Closer); ok {
+		if err := c.Close(); p.err == nil {
+			p.err = err
+		}
+	}
+	return p.err
+}
+
+// writeXRefTable writes the cross-reference table and the trailer,
+// returning the table offset.
+func (p *PDFWriter) writeXRefTable(trailer string) int {
+	off := p.offset
 	p.print("xref")
 	p.printf("0 %d", len(p.objects)+1)
 	p.print("0000000000 65535 f")
 	for _, off := range p.objects {
+		if off == 0 {
+			// reserved but never written.
+			p.print("0000000000 00001 f")
+			continue
+		}
 		p.printf("%010d 00000 n", off)
 	}
-	// trailer
-	id := hex.EncodeToString(p.h.Sum(nil))
 	p.print("trailer")
 	p.print("<<")
 	p.printf("/Size %d", len(p.objects)+1)
-	p.printf("/Info %d 0 R", INFO_ID)
-	p.printf("/Root %d 0 R", CATALOG_ID)
-	p.printf("/ID [<%s> <%s>]", id, id)
+	p.print(trailer)
 	p.print(">>")
-	// end
-	p.print("startxref")
-	p.printf("%d", xrefOff)
-	p.print("%%EOF")
-	return p.err
+	return off
+}
+
+// An objStm collects objects to be packed in an object stream.
+type objStm struct {
+	id     PDFID
+	data   bytes.Buffer
+	index  []int // object number and offset pairs
+	packed map[PDFID]int
+}
+
+// A pendingObj is an object being written while packing, which
+// goes to the object stream if it turns out to be a dictionary.
+type pendingObj struct {
+	id  PDFID
+	buf bytes.Buffer
+	w   io.Writer // saved p.w2
+}
+
+// beginObj writes the header of object id. While packing, the
+// object is held back until it is known to be a dictionary.
+func (p *PDFWriter) beginObj(id PDFID) {
+	p.cur = id
+	if p.packing {
+		p.pending = &pendingObj{id: id, w: p.w2}
+		p.w2 = &p.pending.buf
+	}
+	p.printf("%d 0 obj", id)
+	p.print("<<")
+}
+
+// packPending moves the pending object into the object stream.
+func (p *PDFWriter) packPending() {
+	obj := p.pending
+	p.pending, p.w2 = nil, obj.w
+	p.offset -= obj.buf.Len()
+	body := obj.buf.Bytes()[len(fmt.Sprintf("%d 0 obj\n", obj.id)):]
+	if p.objStm.packed == nil {
+		p.objStm.packed = make(map[PDFID]int)
+	}
+	p.objStm.packed[obj.id] = len(p.objStm.index) / 2
+	p.objStm.index = append(p.objStm.index, int(obj.id), p.objStm.data.Len())
+	p.objStm.data.Write(body)
+}
+
+// unpend writes the pending object, which is a stream, to the file.
+func (p *PDFWriter) unpend() {
+	obj := p.pending
+	p.pending, p.w2 = nil, obj.w
+	if _, err := p.w2.Write(obj.buf.Bytes()); err != nil {
+		p.err = err
+	}
+}
+
+// writeObjStm writes the object stream filled by Flush.
+func (p *PDFWriter) writeObjStm() {
+	index := new(bytes.Buffer)
+	for _, n := range p.objStm.index {
+		fmt.Fprintf(index, "%d ", n)
+	}
+	index.WriteByte('\n')
+	p.objStm.id, _ = p.startObj()
+	p.print("/Type /ObjStm")
+	p.printf("/N %d", len(p.objStm.index)/2)
+	p.printf("/First %d", index.Len())
+	data := append(index.Bytes(), p.objStm.data.Bytes()...)
+	p.writeStream(p.encodeStream(data, flateFilter))
+}
+
+// writeXRefStream writes the cross-reference table as a stream
+// object, holding the trailer entries, and returns its offset.
+// Entries are a type byte, a 4-byte offset and a 2-byte generation.
+func (p *PDFWriter) writeXRefStream(trailer string) int {
+	id := p.reserveObj()
+	off := p.offset
+	p.objects[id-1] = off // startObjID writes it there
+	entry := func(buf *bytes.Buffer, typ byte, field2 uint32, field3 uint16) {
+		buf.WriteByte(typ)
+		binary.Write(buf, binary.BigEndian, field2)
+		binary.Write(buf, binary.BigEndian, field3)
+	}
+	buf := new(bytes.Buffer)
+	entry(buf, 0, 0, 0xffff)
+	for i, off := range p.objects {
+		if n, ok := p.objStm.packed[PDFID(i+1)]; ok {
+			entry(buf, 2, uint32(p.objStm.id), uint16(n))
+			continue
+		}
+		if off == 0 {
+			entry(buf, 0, 0, 1)
+			continue
+		}
+		entry(buf, 1, uint32(off), 0)
+	}
+	p.startObjID(id)
+	p.print("/Type /XRef")
+	p.printf("/Size %d", len(p.objects)+1)
+	p.printf("/Index [0 %d]", len(p.objects)+1)
+	p.print("/W [1 4 2]")
+	p.print(trailer)
+	p.writeRawStream(p.encodeStream(buf.Bytes(), flateFilter)) // never encrypted
+	return off
 }
 
 // Utility functions
 
+// pdfDate formats t as a PDF date string.
+func pdfDate(t time.Time) string {
+	s := t.Format("D:20060102150405-07'00'")
+	if _, off := t.Zone(); off == 0 {
+		s = t.Format("D:20060102150405Z")
+	}
+	return s
+}
+
+// escapePDFString escapes s, encoded as a text string, for use in
+// a literal string (...). Parentheses are always escaped, so that
+// unbalanced ones are safe, and control characters use escape
+// sequences.
+func escapePDFString(s string) string {
+	return escapePDFBytes(textString(s))
+}
+
+// textString returns s encoded as a PDF text string: as is if it
+// is ASCII, otherwise in UTF-16BE with a byte order mark, since
+// viewers read other 8-bit strings as PDFDocEncoding, not UTF-8.
+func textString(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		ascii = ascii && s[i] < 0x80
+	}
+	if ascii {
+		return s
+	}
+	buf := []byte{0xfe, 0xff}
+	for _, u := range utf16.Encode([]rune(s)) {
+		buf = append(buf, byte(u>>8), byte(u))
+	}
+	return string(buf)
+}
+
+// escapePDFBytes escapes the bytes of s for a literal string, as
+// they are.
+func escapePDFBytes(s string) string {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '(', ')':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, `\n`...)
+		case '\r':
+			buf = append(buf, `\r`...)
+		case '\t':
+			buf = append(buf, `\t`...)
+		case '\b':
+			buf = append(buf, `\b`...)
+		case '\f':
+			buf = append(buf, `\f`...)
+		default:
+			if c < 0x20 || c == 0x7f {
+				buf = append(buf, fmt.Sprintf("\\%03o", c)...)
+			} else {
+				buf = append(buf, c)
+			}
+		}
+	}
+	return string(buf)
+}
+
 var nl = []byte{'\n'}
 
 func (p *PDFWriter) print(s string) error {
@@ -219,21 +1428,42 @@ func (p *PDFWriter) printf(format string, args ...interface{}) error {
 	return p.err
 }
 
+// reserveObj allocates an object number, for an object
+// to be written later using startObjID.
+func (p *PDFWriter) reserveObj() PDFID {
+	p.objects = append(p.objects, 0)
+	return PDFID(len(p.objects))
+}
+
+// startObjID starts writing an object whose number
+// was reserved in advance.
+func (p *PDFWriter) startObjID(id PDFID) error {
+	p.writeHeader()
+	p.objects[id-1] = p.offset
+	p.beginObj(id)
+	return p.err
+}
+
 func (p *PDFWriter) startObj() (PDFID, error) {
+	p.writeHeader()
 	p.objects = append(p.objects, p.offset)
 	id := PDFID(len(p.objects))
-	p.printf("%d 0 obj", id)
-	p.print("<<")
+	p.beginObj(id)
 	return id, p.err
 }
 
 func (p *PDFWriter) endObj() error {
 	p.print(">>")
+	if p.pending != nil {
+		p.packPending()
+		return p.err
+	}
 	p.print("endobj")
 	return p.err
 }
 
 func (p *PDFWriter) intObj(n int) (PDFID, error) {
+	p.writeHeader()
 	p.objects = append(p.objects, p.offset)
 	id := PDFID(len(p.objects))
 	p.printf("%d 0 obj", id)
@@ -2,11 +2,20 @@ package main
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
+	"image/png"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,8 +25,9 @@ func TestEmptyPdf(t *testing.T) {
 	defer endPDF(t, p)
 
 	p.WriteInfo("test document", time.Now())
-	p.WritePage(21*CM, 29.7*CM,
-		[]byte("q\n173.52 0 0 245.76 0 0 cm\nQ\n"))
+	var content ContentStream
+	content.Append("q\n173.52 0 0 245.76 0 0 cm\nQ\n")
+	p.WritePage(21*CM, 29.7*CM, &content)
 }
 
 func TestSimplePdf(t *testing.T) {
@@ -43,6 +53,700 @@ func TestSimplePdf(t *testing.T) {
 	p.WriteJPEGPage(img, buf.Bytes())
 }
 
+func TestPNGPage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	want := make([]byte, 0, 4*3*3)
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 5)
+		if i%4 != 3 {
+			want = append(want, img.Pix[i])
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfo("test document with PNG", time.Now())
+	if _, err := p.WritePNGPage(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the soft mask is written first
+	obj := findObject(t, buf.Bytes(), "/SMask")
+	if !bytes.Contains(obj.dict, []byte("/Filter [ /FlateDecode ]")) {
+		t.Errorf("missing FlateDecode filter in %s", obj.dict)
+	}
+	z, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+}
+
+func TestLosslessColorModels(t *testing.T) {
+	ycc := image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio444)
+	for i := range ycc.Y {
+		ycc.Y[i] = byte(64 * i)
+		ycc.Cb[i], ycc.Cr[i] = 128, 128
+	}
+	ycc.Cr[3] = 255
+	var wantYCC []byte
+	for _, c := range []color.YCbCr{{0, 128, 128}, {64, 128, 128}, {128, 128, 128}, {192, 128, 255}} {
+		r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+		wantYCC = append(wantYCC, r, g, b)
+	}
+	cmyk := image.NewCMYK(image.Rect(0, 0, 2, 1))
+	copy(cmyk.Pix, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	rgba64 := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	rgba64.SetNRGBA64(0, 0, color.NRGBA64{0xffff, 0x8080, 0, 0xffff})
+	rgba64.SetNRGBA64(1, 0, color.NRGBA64{0, 0, 0xffff, 0x8000})
+	for _, tc := range []struct {
+		img        image.Image
+		colorSpace string
+		want       []byte
+		mask       bool
+	}{
+		{ycc, "/DeviceRGB", wantYCC, false},
+		{cmyk, "/DeviceCMYK", []byte{1, 2, 3, 4, 5, 6, 7, 8}, false},
+		{rgba64, "/DeviceRGB", []byte{0xff, 0x80, 0, 0, 0, 0xff}, true},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if _, err := p.WriteImageLossless(tc.img); err != nil {
+			t.Fatalf("%T: %v", tc.img, err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		obj := findObject(t, buf.Bytes(), "/ColorSpace "+tc.colorSpace)
+		if got := imageSamples(t, obj); !bytes.Equal(got, tc.want) {
+			t.Errorf("%T: got samples %v, want %v", tc.img, got, tc.want)
+		}
+		if got := bytes.Contains(obj.dict, []byte("/SMask")); got != tc.mask {
+			t.Errorf("%T: got soft mask %v, want %v", tc.img, got, tc.mask)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	if _, err := p.WritePNGPage(img); err != nil {
+		t.Fatal(err)
+	}
+	p.Interpolate = true
+	if _, err := p.WritePNGPage(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var got []bool
+	for _, obj := range readObjects(t, buf.Bytes()) {
+		if bytes.Contains(obj.dict, []byte("/Subtype /Image")) {
+			got = append(got, bytes.Contains(obj.dict, []byte("/Interpolate true\n")))
+		}
+	}
+	if len(got) != 2 || got[0] || !got[1] {
+		t.Errorf("got interpolation %v, want [false true]", got)
+	}
+}
+
+func TestLosslessGray(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 6))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	img16 := image.NewGray16(image.Rect(0, 0, 2, 2))
+	img16.SetGray16(1, 1, color.Gray16{0xabcd})
+	for _, tc := range []struct {
+		img  image.Image
+		want []byte
+	}{
+		{img.SubImage(image.Rect(2, 3, 5, 5)), []byte{26, 27, 28, 34, 35, 36}},
+		{img16, []byte{0, 0, 0, 0xab}},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if _, err := p.WriteImageLossless(tc.img); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+		for _, want := range []string{"/ColorSpace /DeviceGray", "/FlateDecode"} {
+			if !bytes.Contains(obj.dict, []byte(want)) {
+				t.Errorf("%T: image %s does not contain %s", tc.img, obj.dict, want)
+			}
+		}
+		z, err := zlib.NewReader(bytes.NewReader(obj.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(z); !bytes.Equal(got, tc.want) {
+			t.Errorf("%T: got samples %v, want %v", tc.img, got, tc.want)
+		}
+	}
+}
+
+func TestGrayJPEGPage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfo("grayscale JPEG", time.Now())
+	if _, err := p.WriteJPEGPage(img, data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+	if !bytes.Contains(obj.dict, []byte("/ColorSpace /DeviceGray")) {
+		t.Errorf("expected DeviceGray color space, got %s", obj.dict)
+	}
+	if !bytes.Contains(obj.dict, []byte("/Width 64\n/Height 48")) {
+		t.Errorf("wrong image dimensions in %s", obj.dict)
+	}
+}
+
+func TestPageDPI(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 600, 600))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.DPI = 300
+	p.WriteInfo("300 DPI", time.Now())
+	if _, err := p.WriteJPEGPage(img, data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := findObject(t, buf.Bytes(), "/Type /Page\n")
+	if !bytes.Contains(obj.dict, []byte("/MediaBox [0 0 144.00 144.00]")) {
+		t.Errorf("expected a 2in x 2in page, got %s", obj.dict)
+	}
+}
+
+func TestImagePage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	jpgData, pngData := new(bytes.Buffer), new(bytes.Buffer)
+	if err := jpeg.Encode(jpgData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(pngData, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		data   []byte
+		filter string
+	}{
+		{jpgData.Bytes(), "/DCTDecode"},
+		{pngData.Bytes(), "/FlateDecode"},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.WriteInfo("image page", time.Now())
+		if _, err := p.WriteImagePage(test.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		obj := findObject(t, buf.Bytes(), "/Subtype /Image")
+		if !bytes.Contains(obj.dict, []byte("/Filter [ "+test.filter+" ]")) {
+			t.Errorf("expected filter %s, got %s", test.filter, obj.dict)
+		}
+		if !bytes.Contains(obj.dict, []byte("/Width 32\n/Height 16")) {
+			t.Errorf("wrong image dimensions in %s", obj.dict)
+		}
+	}
+
+	p, _ := NewPDFWriter(ioutil.Discard)
+	if _, err := p.WriteImagePage([]byte("GIF89a")); err == nil {
+		t.Errorf("expected error for GIF data")
+	}
+}
+
+func TestCompressedContent(t *testing.T) {
+	content := []byte("q\n173.52 0 0 245.76 0 0 cm\nQ\n")
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.Compress = true
+	p.WriteInfo("compressed content", time.Now())
+	var cs ContentStream
+	cs.Append(string(content))
+	if _, err := p.WritePage(21*CM, 29.7*CM, &cs); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := findObject(t, buf.Bytes(), "/Filter [ /FlateDecode ]")
+	z, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got content %q, want %q", got, content)
+	}
+}
+
+func TestBytesWritten(t *testing.T) {
+	for _, xrefStream := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.XRefStream = xrefStream
+		p.WriteInfo("size", time.Now())
+		p.WriteJPEGPageConfig(image.Config{Width: 30, Height: 20}, jpegHeader(30, 20, 3, false))
+		if n := p.BytesWritten(); n == 0 {
+			t.Errorf("no bytes written for the image page")
+		}
+		p.WriteBlankPage(A4)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if n := p.BytesWritten(); n != buf.Len() {
+			t.Errorf("xrefStream=%v: BytesWritten = %d, file has %d bytes", xrefStream, n, buf.Len())
+		}
+	}
+}
+
+// closeBuffer records calls to Sync and Close.
+type closeBuffer struct {
+	bytes.Buffer
+	syncs, closes int
+}
+
+func (b *closeBuffer) Sync() error {
+	b.syncs++
+	return nil
+}
+
+func (b *closeBuffer) Close() error {
+	b.closes++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	for _, flush := range []bool{false, true} {
+		buf := new(closeBuffer)
+		p, _ := NewPDFWriter(buf)
+		p.WriteBlankPage(A4)
+		if flush {
+			if err := p.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if buf.Len() != p.BytesWritten() {
+			t.Errorf("flush=%v: %d bytes in buffer, wrote %d", flush, buf.Len(), p.BytesWritten())
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("%%EOF")); n != 1 {
+			t.Errorf("flush=%v: document ended %d times", flush, n)
+		}
+		if buf.syncs != 1 || buf.closes != 1 {
+			t.Errorf("flush=%v: %d syncs and %d closes, want 1", flush, buf.syncs, buf.closes)
+		}
+	}
+}
+
+func TestCloseFlushError(t *testing.T) {
+	buf := new(closeBuffer)
+	p, _ := NewPDFWriter(buf)
+	p.SetOpenAction(OpenAction{Fit: FitPage})
+	err := p.Close()
+	if err == nil {
+		t.Fatal("expected error for open action without pages")
+	}
+	if again := p.Close(); again != err {
+		t.Errorf("closing again: got %v, want %v", again, err)
+	}
+	if buf.closes != 1 {
+		t.Errorf("%d closes, want 1", buf.closes)
+	}
+}
+
+var idRx = regexp.MustCompile(`/ID \[<([0-9a-f]{32})> <([0-9a-f]{32})>\]`)
+
+func TestDocumentID(t *testing.T) {
+	mtime := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	var ids [][][]byte
+	for _, content := range []string{"q\nQ\n", "q\n1 0 0 1 0 0 cm\nQ\n"} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.WriteInfo("document ID", mtime)
+		var cs ContentStream
+		cs.Append(content)
+		p.WritePage(21*CM, 29.7*CM, &cs)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		m := idRx.FindSubmatch(buf.Bytes())
+		if m == nil {
+			t.Fatalf("no document ID in trailer")
+		}
+		ids = append(ids, m[1:])
+	}
+	if !bytes.Equal(ids[0][0], ids[1][0]) {
+		t.Errorf("permanent IDs differ: %s != %s", ids[0][0], ids[1][0])
+	}
+	if bytes.Equal(ids[0][1], ids[1][1]) {
+		t.Errorf("documents with different contents have the same ID %s", ids[0][1])
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	mtime := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	write := func(deterministic, encrypt bool) []byte {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if deterministic {
+			p.SetDeterministic()
+		}
+		if encrypt {
+			if err := p.SetEncryption("user", "owner", AllPermissions, AES256); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p.WriteInfo("deterministic", mtime)
+		page, _ := p.WriteBlankPage(A4)
+		p.DrawText(page, 72, 72, 12, "same bytes")
+		p.AddBookmark("Start", page)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, encrypt := range []bool{false, true} {
+		out := write(true, encrypt)
+		if again := write(true, encrypt); !bytes.Equal(out, again) {
+			t.Errorf("encrypt=%v: deterministic output differs between runs", encrypt)
+		}
+		if !encrypt {
+			m := idRx.FindSubmatch(out)
+			if m == nil || !bytes.Equal(m[1], m[2]) {
+				t.Errorf("document ID not derived from contents: %s", m)
+			}
+		}
+	}
+	// random salts and initialization vectors otherwise
+	if bytes.Equal(write(false, true), write(false, true)) {
+		t.Error("encrypted output is identical without deterministic mode")
+	}
+}
+
+// A driftWriter corrupts object numbering of p
+// when it sees the end of the first object.
+type driftWriter struct {
+	bytes.Buffer
+	p *PDFWriter
+}
+
+func (w *driftWriter) Write(b []byte) (int, error) {
+	if w.p != nil && string(b) == "endobj" {
+		w.p.objects = append(w.p.objects, 0)
+		w.p = nil
+	}
+	return w.Buffer.Write(b)
+}
+
+func (w *driftWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func TestObjectNumbering(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages refer to the objects actually written,
+	// whatever their numbers
+	w := new(driftWriter)
+	p, _ := newPDFWriter(w, false)
+	p.WriteInfo("object numbering", time.Now())
+	w.p = p
+	var pages []PDFID
+	for i := 0; i < 2; i++ {
+		page, err := p.WriteJPEGPage(img, data.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, w.Bytes())
+	for _, page := range pages {
+		m := regexp.MustCompile(`/I0 (\d+) 0 R`).FindSubmatch(objs[page-1].dict)
+		if m == nil {
+			t.Fatalf("page %d has no image: %s", page, objs[page-1].dict)
+		}
+		id, _ := strconv.Atoi(string(m[1]))
+		if img := objs[id-1]; !bytes.Equal(img.stream, data.Bytes()) {
+			t.Errorf("page %d: object %d is not the image", page, id)
+		}
+		if got := pageContents(t, w.Bytes(), page); !bytes.Contains(got, []byte("/I0 Do")) {
+			t.Errorf("page %d: image not drawn: %q", page, got)
+		}
+	}
+}
+
+func benchmarkPages(b *testing.B, buffered bool) {
+	var content ContentStream
+	content.Append("q\n173.52 0 0 245.76 0 0 cm\nQ\n")
+	f, err := ioutil.TempFile("", "pdfbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	for i := 0; i < b.N; i++ {
+		f.Seek(0, os.SEEK_SET)
+		p, _ := newPDFWriter(f, buffered)
+		p.WriteInfo("benchmark", time.Now())
+		for j := 0; j < 500; j++ {
+			p.WritePage(21*CM, 29.7*CM, &content)
+		}
+		if err := p.Flush(); err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(int64(p.offset))
+	}
+}
+
+func BenchmarkPagesBuffered(b *testing.B)   { benchmarkPages(b, true) }
+func BenchmarkPagesUnbuffered(b *testing.B) { benchmarkPages(b, false) }
+
+func TestInfo(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfoFull(Info{
+		Title:    `Scans (2012) \ draft`,
+		Author:   "John Doe",
+		Keywords: "mvz, scans",
+		ModTime:  time.Now(),
+	})
+	p.WritePage(21*CM, 29.7*CM, nil)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	info := readObjects(t, buf.Bytes())[INFO_ID-1]
+	for _, s := range []string{
+		`/Title (Scans \(2012\) \\ draft)`,
+		`/Author (John Doe)`,
+		`/Keywords (mvz, scans)`,
+	} {
+		if !bytes.Contains(info.dict, []byte(s)) {
+			t.Errorf("missing %s in %s", s, info.dict)
+		}
+	}
+	if bytes.Contains(info.dict, []byte("/Subject")) {
+		t.Errorf("empty subject should be omitted: %s", info.dict)
+	}
+}
+
+func TestUnicodeInfo(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfo("Caf\u00e9 \u2014 menu", time.Now())
+	page, _ := p.WriteBlankPage(A4)
+	p.AddBookmark("\u00c9t\u00e9", page)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"/Title (\xfe\xff\\000C\\000a\\000f\\000\xe9\\000  \\024\\000 \\000m\\000e\\000n\\000u)",
+		"/Title (\xfe\xff\\000\xc9\\000t\\000\xe9)",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("missing UTF-16 text string %q", want)
+		}
+	}
+}
+
+func TestEscapePDFString(t *testing.T) {
+	for _, test := range []struct{ in, out string }{
+		{"plain text", "plain text"},
+		{"balanced (parens)", `balanced \(parens\)`},
+		{"unbalanced (", `unbalanced \(`},
+		{") unbalanced", `\) unbalanced`},
+		{`C:\scans\`, `C:\\scans\\`},
+		{"two\nlines\r\n", `two\nlines\r\n`},
+		{"tab\tbell\a", `tab\tbell\007`},
+		{"caf\u00e9", "\xfe\xff\\000c\\000a\\000f\\000\xe9"},
+		{"\U0001f600", "\xfe\xff\xd8=\xde\\000"},
+	} {
+		got := escapePDFString(test.in)
+		if got != test.out {
+			t.Errorf("escapePDFString(%q) = %q, want %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestPageRotation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WritePage(21*CM, 29.7*CM, nil)
+	if err := p.SetPageRotation(page, 45); err == nil {
+		t.Errorf("expected error for 45 degrees rotation")
+	}
+	if err := p.SetPageRotation(page, 90); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := readObjects(t, buf.Bytes())[page-1]
+	if !bytes.Contains(obj.dict, []byte("/Rotate 90\n")) {
+		t.Errorf("missing /Rotate in %s", obj.dict)
+	}
+}
+
+func TestPageBackground(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(Letter)
+	if err := p.DrawText(page, 72, 72, 12, "Title"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPageBackground(page, 1, 0.5, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPageBackground(page+100, 1, 1, 1); err == nil {
+		t.Errorf("expected error for unknown page")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "q\n1 0.5 0 rg\n0 0 612.00 792.00 re\nf\nQ\n"
+	content := pageContents(t, buf.Bytes(), page)
+	if !bytes.HasPrefix(content, []byte(want)) {
+		t.Errorf("content stream %q does not start with %q", content, want)
+	}
+	if !bytes.Contains(content, []byte("(Title) Tj")) {
+		t.Errorf("content stream %q lost the text", content)
+	}
+}
+
+func TestReorderPages(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var pages []PDFID
+	for i := 0; i < 3; i++ {
+		page, _ := p.WriteBlankPage(A4)
+		pages = append(pages, page)
+	}
+	for _, bad := range [][]PDFID{
+		{pages[0], pages[1]},
+		{pages[0], pages[1], pages[1]},
+		{pages[0], pages[1], pages[2] + 100},
+	} {
+		if err := p.ReorderPages(bad); err == nil {
+			t.Errorf("expected error for order %v", bad)
+		}
+	}
+	if err := p.ReorderPages([]PDFID{pages[2], pages[1], pages[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("/Kids [ %d 0 R %d 0 R %d 0 R ]", pages[2], pages[1], pages[0])
+	if tree := readObjects(t, buf.Bytes())[PAGES_ID-1]; !bytes.Contains(tree.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, tree.dict)
+	}
+}
+
+func TestInsertBlankPage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	first, _ := p.WriteJPEGPage(img, data.Bytes())
+	last, _ := p.WriteJPEGPage(img, data.Bytes())
+	for _, at := range []int{-1, 3} {
+		if _, err := p.InsertBlankPage(A4, at); err == nil {
+			t.Errorf("expected error inserting at %d", at)
+		}
+	}
+	blank, err := p.InsertBlankPage(A4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tree := readObjects(t, buf.Bytes())[PAGES_ID-1]
+	want := fmt.Sprintf("/Kids [ %d 0 R %d 0 R %d 0 R ]\n/Count 3", first, blank, last)
+	if !bytes.Contains(tree.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, tree.dict)
+	}
+	if got := pageContents(t, buf.Bytes(), blank); len(got) != 0 {
+		t.Errorf("blank page has contents %q", got)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	a4, _ := p.WriteBlankPage(A4)
+	letter, _ := p.WriteBlankPage(Letter.Landscape())
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, buf.Bytes())
+	for _, test := range []struct {
+		page PDFID
+		box  string
+	}{
+		{a4, "/MediaBox [0 0 595.28 841.89]"},
+		{letter, "/MediaBox [0 0 792.00 612.00]"},
+	} {
+		if obj := objs[test.page-1]; !bytes.Contains(obj.dict, []byte(test.box)) {
+			t.Errorf("missing %s in %s", test.box, obj.dict)
+		}
+	}
+}
+
 func startPDF(t *testing.T) *PDFWriter {
 	f, err := ioutil.TempFile("", "pdftest")
 	if err != nil {
@@ -73,3 +777,409 @@ func endPDF(t *testing.T, p *PDFWriter) {
 		t.Error(err)
 	}
 }
+
+// A pdfObject is an object read back from a written document.
+type pdfObject struct {
+	id     PDFID
+	dict   []byte // everything up to the stream keyword
+	stream []byte // stream contents, if any
+}
+
+var (
+	startxrefRx = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	lengthRx    = regexp.MustCompile(`/Length (\d+)`)
+)
+
+// readObjects locates all objects of a document through its
+// xref table and checks that offsets are correct.
+func readObjects(t *testing.T, data []byte) []pdfObject {
+	m := startxrefRx.FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("missing startxref")
+	}
+	off, _ := strconv.Atoi(string(m[1]))
+	lines := bytes.Split(data[off:], []byte("\n"))
+	if string(lines[0]) != "xref" {
+		t.Fatalf("no xref table at offset %d", off)
+	}
+	var objs []pdfObject
+	for i, line := range lines[3:] {
+		if bytes.HasSuffix(line, []byte(" f")) {
+			// keep objs[id-1] indexing
+			objs = append(objs, pdfObject{id: PDFID(i + 1)})
+			continue
+		}
+		if !bytes.HasSuffix(line, []byte(" n")) {
+			break
+		}
+		id := PDFID(i + 1)
+		off, _ := strconv.Atoi(string(line[:10]))
+		objs = append(objs, readObject(t, data, id, off))
+	}
+	return objs
+}
+
+func readObject(t *testing.T, data []byte, id PDFID, off int) pdfObject {
+	header := []byte(strconv.Itoa(int(id)) + " 0 obj\n")
+	if !bytes.HasPrefix(data[off:], header) {
+		t.Fatalf("object %d not found at offset %d", id, off)
+	}
+	obj := pdfObject{id: id}
+	body := data[off+len(header):]
+	end := bytes.Index(body, []byte("endobj\n"))
+	start := bytes.Index(body, []byte(">>\nstream\n"))
+	if start < 0 || start > end {
+		obj.dict = body[:end]
+		return obj
+	}
+	obj.dict = body[:start+2]
+	m := lengthRx.FindSubmatch(obj.dict)
+	if m == nil {
+		t.Fatalf("object %d: stream without length", id)
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	obj.stream = body[start+len(">>\nstream\n"):][:n]
+	return obj
+}
+
+// findObject returns the first object whose dictionary contains s.
+func findObject(t *testing.T, data []byte, s string) pdfObject {
+	for _, obj := range readObjects(t, data) {
+		if bytes.Contains(obj.dict, []byte(s)) {
+			return obj
+		}
+	}
+	t.Fatalf("no object matching %q", s)
+	return pdfObject{}
+}
+
+var (
+	contentsRx = regexp.MustCompile(`/Contents (\d+ 0 R|\[ [0-9 R]*\])`)
+	refRx      = regexp.MustCompile(`(\d+) 0 R`)
+)
+
+// pageContents returns the concatenated content streams of a page.
+func pageContents(t *testing.T, data []byte, page PDFID) []byte {
+	objs := readObjects(t, data)
+	m := contentsRx.FindSubmatch(objs[page-1].dict)
+	if m == nil {
+		t.Fatalf("page %d has no contents", page)
+	}
+	var out []byte
+	for _, ref := range refRx.FindAllSubmatch(m[1], -1) {
+		id, _ := strconv.Atoi(string(ref[1]))
+		obj := objs[id-1]
+		if !bytes.Contains(obj.dict, []byte("/FlateDecode")) {
+			out = append(out, obj.stream...)
+			continue
+		}
+		z, err := zlib.NewReader(bytes.NewReader(obj.stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, data...)
+	}
+	return out
+}
+
+func TestXRefStream(t *testing.T) {
+	for _, late := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.XRefStream = !late
+		p.WriteInfo("xref stream", time.Now())
+		p.WriteBlankPage(A4)
+		p.XRefStream = true
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+
+		header, version := "%PDF-1.5\n", false
+		if late {
+			header, version = "%PDF-1.3\n", true
+		}
+		if !bytes.HasPrefix(data, []byte(header)) {
+			t.Errorf("got header %q, want %q", data[:9], header)
+		}
+		if bytes.Contains(data, []byte("/Version /1.5")) != version {
+			t.Errorf("catalog /Version present: %v, want %v", !version, version)
+		}
+		if bytes.Contains(data, []byte("\ntrailer\n")) {
+			t.Error("unexpected trailer")
+		}
+
+		xref, entries := readXRefStream(t, data)
+		id := len(entries) - 1
+		size := fmt.Sprintf("/Size %d", id+1)
+		for _, want := range []string{"/Type /XRef", size, "/W [1 4 2]", "/Root 2 0 R", "/ID ["} {
+			if !bytes.Contains(xref.dict, []byte(want)) {
+				t.Errorf("xref stream missing %q:\n%s", want, xref.dict)
+			}
+		}
+		for i, e := range entries[1:] {
+			if e[0] != 1 {
+				t.Errorf("object %d: got entry type %d, want 1", i+1, e[0])
+				continue
+			}
+			if obj := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[e[1]:], []byte(obj)) {
+				t.Errorf("object %d not found at offset %d", i+1, e[1])
+			}
+		}
+	}
+}
+
+// readXRefStream returns the cross-reference stream of a document
+// and its entries, each a type and two fields.
+func readXRefStream(t *testing.T, data []byte) (pdfObject, [][3]int) {
+	m := startxrefRx.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	off, _ := strconv.Atoi(string(m[1]))
+	m = regexp.MustCompile(`^(\d+) 0 obj\n`).FindSubmatch(data[off:])
+	if m == nil {
+		t.Fatalf("no object at xref offset %d", off)
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	xref := readObject(t, data, PDFID(id), off)
+	z, err := zlib.NewReader(bytes.NewReader(xref.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 7*(id+1) {
+		t.Fatalf("got %d bytes of xref entries, want %d", len(raw), 7*(id+1))
+	}
+	entries := make([][3]int, id+1)
+	for i := range entries {
+		e := raw[7*i:]
+		entries[i] = [3]int{int(e[0]), int(binary.BigEndian.Uint32(e[1:])), int(binary.BigEndian.Uint16(e[5:]))}
+	}
+	return xref, entries
+}
+
+func TestObjectStreams(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.ObjectStreams = true
+	p.WriteInfo("object streams", time.Now())
+	page, _ := p.WriteBlankPage(A4)
+	p.AddBookmark("Start", page)
+	p.DrawText(page, 72, 72, 12, "packed")
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.5\n")) {
+		t.Errorf("got header %q, want %%PDF-1.5", data[:9])
+	}
+
+	_, entries := readXRefStream(t, data)
+	cat := entries[CATALOG_ID]
+	if cat[0] != 2 {
+		t.Fatalf("catalog: got entry type %d, want 2", cat[0])
+	}
+	for _, id := range []PDFID{INFO_ID, PAGES_ID, page} {
+		if e := entries[id]; e[0] != 2 || e[1] != cat[1] {
+			t.Errorf("object %d: got entry %v, want it in object stream %d", id, e, cat[1])
+		}
+	}
+	// content streams and the object stream stay top-level
+	for _, id := range []PDFID{page + 1, PDFID(cat[1])} {
+		if e := entries[id]; e[0] != 1 || !bytes.HasPrefix(data[e[1]:], []byte(fmt.Sprintf("%d 0 obj\n", id))) {
+			t.Errorf("object %d: got entry %v, want a top-level object", id, e)
+		}
+	}
+
+	objStm := readObject(t, data, PDFID(cat[1]), entries[cat[1]][1])
+	if !bytes.Contains(objStm.dict, []byte("/Type /ObjStm")) {
+		t.Fatalf("object %d is not an object stream:\n%s", cat[1], objStm.dict)
+	}
+	m := regexp.MustCompile(`/N (\d+)\n/First (\d+)`).FindSubmatch(objStm.dict)
+	if m == nil {
+		t.Fatalf("object stream has no /N and /First:\n%s", objStm.dict)
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	first, _ := strconv.Atoi(string(m[2]))
+	z, err := zlib.NewReader(bytes.NewReader(objStm.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := strings.Fields(string(packed[:first]))
+	if len(index) != 2*n {
+		t.Fatalf("got object stream index %q, want %d pairs", index, n)
+	}
+	if index[2*cat[2]] != strconv.Itoa(int(CATALOG_ID)) {
+		t.Fatalf("object stream index %d is object %s, want %d", cat[2], index[2*cat[2]], CATALOG_ID)
+	}
+	off, _ := strconv.Atoi(index[2*cat[2]+1])
+	if catalog := packed[first+off:]; !bytes.HasPrefix(catalog, []byte("<<\n/Type /Catalog\n")) {
+		t.Errorf("catalog not found in object stream: %q", catalog)
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	for _, c := range []struct {
+		major, minor  int
+		objectStreams bool
+		header        string
+		warn          bool
+	}{
+		{1, 7, false, "%PDF-1.7", false},
+		{1, 2, false, "%PDF-1.2", false},
+		{1, 6, true, "%PDF-1.6", false},
+		{1, 4, true, "%PDF-1.5", true},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if err := p.SetVersion(c.major, c.minor); err != nil {
+			t.Fatal(err)
+		}
+		p.ObjectStreams = c.objectStreams
+		p.WriteBlankPage(A4)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(buf.Bytes(), []byte(c.header+"\n")) {
+			t.Errorf("%d.%d: got header %q, want %s", c.major, c.minor, buf.Bytes()[:9], c.header)
+		}
+		if w := p.Warnings(); (len(w) > 0) != c.warn {
+			t.Errorf("%d.%d: got warnings %q", c.major, c.minor, w)
+		}
+	}
+
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	if err := p.SetVersion(1, 8); err == nil {
+		t.Error("expected error for PDF 1.8")
+	}
+	p.WriteBlankPage(A4)
+	if err := p.SetVersion(1, 5); err == nil {
+		t.Error("expected error setting the version after writing")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p, _ := NewPDFWriter(ioutil.Discard)
+	var calls [][2]int
+	p.SetProgress(func(written, total int) {
+		calls = append(calls, [2]int{written, total})
+	})
+	p.WriteBlankPage(A4)
+	p.ExpectPages(3)
+	p.WriteBlankPage(A4)
+	p.WriteBlankPage(A4)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]int{{1, -1}, {2, 3}, {3, 3}}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("got progress calls %v, want %v", calls, want)
+	}
+}
+
+func TestConcurrentPages(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	const n = 50
+	var calls []int
+	p.SetProgress(func(written, total int) {
+		calls = append(calls, written)
+	})
+	var wg sync.WaitGroup
+	pages := make([]PDFID, 2*n)
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			cfg := image.Config{Width: 10 + i, Height: 20}
+			page, err := p.WriteJPEGPageConfig(cfg, jpegHeader(10+i, 20, 3, false))
+			if err == nil {
+				err = p.DrawText(page, 10, 10, 12, strconv.Itoa(i))
+			}
+			pages[2*i] = page
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			page, err := p.WriteBlankPage(A4)
+			pages[2*i+1] = page
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[PDFID]bool)
+	for _, page := range pages {
+		if seen[page] {
+			t.Errorf("page %d returned twice", page)
+		}
+		seen[page] = true
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(fmt.Sprintf("/Count %d", 2*n))) {
+		t.Errorf("missing /Count %d in page tree", 2*n)
+	}
+	for i, written := range calls {
+		if written != i+1 {
+			t.Fatalf("progress call %d reported %d pages", i, written)
+		}
+	}
+}
+
+func TestProducerCreator(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.SetProducer("ScanTool (v2)")
+	p.SetCreator(`Scanner \ driver`)
+	p.WriteXMP(XMPMetadata{Title: "scan"})
+	p.WriteBlankPage(A4)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	info := readObjects(t, buf.Bytes())[INFO_ID-1]
+	for _, s := range []string{`/Producer (ScanTool \(v2\))`, `/Creator (Scanner \\ driver)`} {
+		if !bytes.Contains(info.dict, []byte(s)) {
+			t.Errorf("missing %s in %s", s, info.dict)
+		}
+	}
+	for _, s := range []string{
+		"<xmp:CreatorTool>Scanner \\ driver</xmp:CreatorTool>",
+		"<pdf:Producer>ScanTool (v2)</pdf:Producer>",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(s)) {
+			t.Errorf("missing %s in XMP metadata", s)
+		}
+	}
+
+	buf.Reset()
+	p, _ = NewPDFWriter(buf)
+	p.WriteBlankPage(A4)
+	p.Flush()
+	info = readObjects(t, buf.Bytes())[INFO_ID-1]
+	if !bytes.Contains(info.dict, []byte("/Producer (mvztopdf 1.0)")) || bytes.Contains(info.dict, []byte("/Creator")) {
+		t.Errorf("wrong default producer or creator in %s", info.dict)
+	}
+}
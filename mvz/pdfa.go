@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+// This file implements PDF/A-1b conformance.
+
+// preparePDFA checks that the document can conform to PDF/A-1b,
+// and adds the metadata and output intent it requires.
+func (p *PDFWriter) preparePDFA() error {
+	p.writeHeader()
+	if p.header != "1.4" {
+		return fmt.Errorf("PDF/A-1b requires a PDF 1.4 header, got %s", p.header)
+	}
+	if p.crypt != nil {
+		return fmt.Errorf("PDF/A-1b forbids encryption")
+	}
+	if p.XRefStream || p.ObjectStreams {
+		return fmt.Errorf("PDF/A-1b forbids cross-reference and object streams")
+	}
+	if p.version > "1.4" {
+		return fmt.Errorf("PDF/A-1b requires PDF 1.4, document needs %s", p.version)
+	}
+	if len(p.attachments) > 0 {
+		return fmt.Errorf("PDF/A-1b forbids embedded files")
+	}
+	if p.interpolated {
+		return fmt.Errorf("PDF/A-1b forbids image interpolation")
+	}
+	if p.transparent() {
+		return fmt.Errorf("PDF/A-1b forbids transparency")
+	}
+	for _, f := range p.fonts {
+		if f.ttf == nil {
+			return fmt.Errorf("PDF/A-1b requires embedded fonts, %s is not", f.BaseFont)
+		}
+	}
+	if p.xmp == nil {
+		p.xmp = &XMPMetadata{}
+	}
+	return p.SetOutputIntentSRGB()
+}
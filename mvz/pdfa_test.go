@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPDFA1b(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ttf")
+	if err := ioutil.WriteFile(path, buildTTF("TestSans", map[rune]int{' ': 512, 'A': 1366}), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.PDFA1b = true
+	p.WriteInfoFull(Info{
+		Title:    "archived",
+		Subject:  "old scans",
+		Keywords: "scans, archive",
+		ModTime:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	font, err := p.EmbedTTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFont(font)
+	page, _ := p.WriteBlankPage(A4)
+	p.DrawText(page, 72, 72, 12, "A A")
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.4\n")) {
+		t.Errorf("wrong header %q", buf.Bytes()[:9])
+	}
+	// a comment of at least 4 binary bytes follows the header
+	comment := bytes.SplitN(buf.Bytes(), []byte("\n"), 3)[1]
+	binary := 0
+	for _, c := range comment {
+		if c > 127 {
+			binary++
+		}
+	}
+	if comment[0] != '%' || binary < 4 {
+		t.Errorf("wrong binary comment %q", comment)
+	}
+	xmp := findObject(t, buf.Bytes(), "/Subtype /XML")
+	for _, want := range []string{
+		"<pdfaid:part>1</pdfaid:part>",
+		"<pdfaid:conformance>B</pdfaid:conformance>",
+		"<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">archived</rdf:li></rdf:Alt></dc:title>",
+		"<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">old scans</rdf:li></rdf:Alt></dc:description>",
+		"<pdf:Keywords>scans, archive</pdf:Keywords>",
+	} {
+		if !bytes.Contains(xmp.stream, []byte(want)) {
+			t.Errorf("missing %s in XMP packet", want)
+		}
+	}
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	for _, want := range []string{"/Metadata ", "/OutputIntents [ << /Type /OutputIntent /S /GTS_PDFA1"} {
+		if !bytes.Contains(catalog.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, catalog.dict)
+		}
+	}
+}
+
+func TestPDFA1bViolations(t *testing.T) {
+	for name, setup := range map[string]func(p *PDFWriter){
+		"standard font": func(p *PDFWriter) {
+			page, _ := p.WriteBlankPage(A4)
+			p.DrawText(page, 72, 72, 12, "Helvetica")
+		},
+		"footer in standard font": func(p *PDFWriter) {
+			p.WriteBlankPage(A4)
+			p.SetFooter(func(page, total int) string { return "footer" })
+		},
+		"encryption": func(p *PDFWriter) {
+			p.SetEncryption("", "owner", AllPermissions, RC4)
+		},
+		"xref stream": func(p *PDFWriter) {
+			p.XRefStream = true
+		},
+		"interpolation": func(p *PDFWriter) {
+			p.Interpolate = true
+			p.WritePNGPage(image.NewGray(image.Rect(0, 0, 2, 2)))
+		},
+		"soft mask": func(p *PDFWriter) {
+			ref, _ := p.RegisterImage(image.NewGray(image.Rect(0, 0, 2, 2)), nil)
+			p.SetSoftMask(ref, ref)
+		},
+		"attachment": func(p *PDFWriter) {
+			p.AttachFile("data.csv", "text/csv", []byte("a,b\n"))
+		},
+	} {
+		p, _ := NewPDFWriter(new(bytes.Buffer))
+		p.PDFA1b = true
+		setup(p)
+		err := p.Flush()
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if again := p.Close(); again != err {
+			t.Errorf("%s: closing got %v, want %v", name, again, err)
+		}
+	}
+	// without Flush, the violation is reported by Close
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	p.PDFA1b = true
+	page, _ := p.WriteBlankPage(A4)
+	p.DrawText(page, 72, 72, 12, "Helvetica")
+	if err := p.Close(); err == nil {
+		t.Error("Close: expected error for a standard font")
+	}
+}
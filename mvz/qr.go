@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file implements QR codes (ISO/IEC 18004), in byte mode.
+
+// A QRLevel is the error correction level of a QR code: the share
+// of the code that may be damaged and still read.
+type QRLevel int
+
+const (
+	QRLow      QRLevel = iota // 7%
+	QRMedium                  // 15%
+	QRQuartile                // 25%
+	QRHigh                    // 30%
+)
+
+// qrQuietZone is the width of the light margin around QR codes,
+// in modules.
+const qrQuietZone = 4
+
+// DrawQRCode draws a QR code encoding content on page, as a square
+// of the given size with lower left corner (x, y), including its
+// quiet zone. The smallest version holding content at the error
+// correction level is used.
+func (p *PDFWriter) DrawQRCode(page PDFID, x, y, size Length, content string, level QRLevel) error {
+	qr, err := encodeQR([]byte(content), level)
+	if err != nil {
+		return err
+	}
+	c, err := p.Canvas(page)
+	if err != nil {
+		return err
+	}
+	n := qr.size + 2*qrQuietZone
+	m := size / Length(n)
+	c.SetFillColor(1, 1, 1)
+	c.Rect(x, y, size, size)
+	c.Fill()
+	c.SetFillColor(0, 0, 0)
+	for row := 0; row < qr.size; row++ {
+		// each run of dark modules is one rectangle
+		top := y + Length(n-qrQuietZone-row-1)*m
+		for col := 0; col < qr.size; {
+			if !qr.dark(col, row) {
+				col++
+				continue
+			}
+			start := col
+			for col < qr.size && qr.dark(col, row) {
+				col++
+			}
+			c.Rect(x+Length(qrQuietZone+start)*m, top, Length(col-start)*m, m)
+		}
+	}
+	c.Fill()
+	return p.err
+}
+
+// A qrCode is the matrix of modules of a QR code.
+type qrCode struct {
+	version  int
+	size     int
+	modules  []bool // dark modules, row by row
+	function []bool // modules of function patterns
+}
+
+func (q *qrCode) dark(x, y int) bool {
+	return q.modules[y*q.size+x]
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.modules[y*q.size+x] = dark
+	q.function[y*q.size+x] = true
+}
+
+// Error correction codewords per block, and number of blocks, by
+// version and level.
+var (
+	qrECCPerBlock = [4][41]int{
+		{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+		{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+		{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	}
+	qrBlocks = [4][41]int{
+		{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+		{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+		{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+		{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+	}
+	// format information bits of the levels
+	qrLevelBits = [4]int{1, 0, 3, 2}
+)
+
+// qrRawModules returns the number of modules of a version available
+// for data and error correction, including remainder bits.
+func qrRawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// qrDataCodewords returns the number of data codewords of a
+// version at a level.
+func qrDataCodewords(version int, level QRLevel) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[level][version]*qrBlocks[level][version]
+}
+
+// encodeQR returns the QR code of data, in the smallest version
+// holding it at the given level, with the best mask.
+func encodeQR(data []byte, level QRLevel) (*qrCode, error) {
+	if level < QRLow || level > QRHigh {
+		return nil, fmt.Errorf("invalid QR error correction level %d", level)
+	}
+	version := 1
+	for ; version <= 40; version++ {
+		countBits := 8
+		if version > 9 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrDataCodewords(version, level) {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, fmt.Errorf("%d bytes do not fit in a QR code", len(data))
+	}
+
+	// byte mode segment, terminator and padding
+	var bits qrBits
+	bits.append(4, 4)
+	if version > 9 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * qrDataCodewords(version, level)
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	codewords := bits.bytes()
+	for pad := byte(0xec); len(codewords) < capacity/8; pad ^= 0xec ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	size := 4*version + 17
+	q := &qrCode{version: version, size: size, modules: make([]bool, size*size), function: make([]bool, size*size)}
+	q.drawFunctionPatterns(level)
+	q.drawCodewords(qrAddECC(codewords, version, level))
+	best, bestPenalty := 0, math.MaxInt32
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(level, mask)
+		if penalty := q.penalty(); penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask) // undo
+	}
+	q.applyMask(best)
+	q.drawFormat(level, best)
+	return q, nil
+}
+
+// qrBits is a sequence of bits, one per byte.
+type qrBits []byte
+
+// append appends the n low bits of v, most significant first.
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, byte(v>>uint(i)&1))
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		out[i/8] |= bit << uint(7-i%8)
+	}
+	return out
+}
+
+// qrAddECC splits data into blocks, appends their error correction
+// codewords, and interleaves the blocks.
+func qrAddECC(data []byte, version int, level QRLevel) []byte {
+	numBlocks, eccLen := qrBlocks[level][version], qrECCPerBlock[level][version]
+	raw := qrRawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte(nil), data[:n]...)
+		data = data[n:]
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0) // skipped when interleaving
+		}
+		blocks[i] = append(block, ecc...)
+	}
+	out := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				out = append(out, block[i])
+			}
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the
+// given degree, without its leading coefficient.
+func rsDivisor(degree int) []byte {
+	div := make([]byte, degree)
+	div[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range div {
+			div[j] = gfMul(div[j], root)
+			if j+1 < degree {
+				div[j] ^= div[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return div
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	rem := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, d := range divisor {
+			rem[i] ^= gfMul(d, factor)
+		}
+	}
+	return rem
+}
+
+// gfMul multiplies in GF(256) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrAlignment returns the coordinates of the centers of the
+// alignment patterns of a version, on both axes.
+func qrAlignment(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 4*version+10; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+func (q *qrCode) drawFunctionPatterns(level QRLevel) {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+	align := qrAlignment(q.version)
+	for i, x := range align {
+		for j, y := range align {
+			last := len(align) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // finder patterns
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, maxAbs(dx, dy) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(level, 0) // reserved
+	if q.version >= 7 {
+		rem := q.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		bits := q.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 != 0
+			a, b := q.size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern and its separator, centered
+// on (x, y).
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < q.size && yy >= 0 && yy < q.size {
+				d := maxAbs(dx, dy)
+				q.setFunction(xx, yy, d != 2 && d != 4)
+			}
+		}
+	}
+}
+
+func maxAbs(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// qrFormatBits returns the 15 format information bits of a level
+// and mask.
+func qrFormatBits(level QRLevel, mask int) int {
+	data := qrLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information.
+func (q *qrCode) drawFormat(level QRLevel, mask int) {
+	bits := qrFormatBits(level, mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 != 0 }
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true) // always dark
+}
+
+// drawCodewords places the codewords in the zigzag order, two
+// columns at a time from the bottom right.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if !q.function[y*q.size+x] && i < 8*len(data) {
+					q.modules[y*q.size+x] = data[i/8]>>uint(7-i%8)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y*q.size+x] {
+				q.modules[y*q.size+x] = !q.modules[y*q.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to read, to choose the mask:
+// runs of modules of the same color, 2×2 blocks of the same color,
+// patterns that look like finders, and imbalance of dark modules.
+func (q *qrCode) penalty() int {
+	score, dark := 0, 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for pass := 0; pass < 2; pass++ {
+		// rows, then columns
+		at := func(i, j int) bool {
+			if pass == 0 {
+				return q.dark(j, i)
+			}
+			return q.dark(i, j)
+		}
+		for i := 0; i < q.size; i++ {
+			run := 0
+			for j := 0; j < q.size; j++ {
+				if j > 0 && at(i, j) == at(i, j-1) {
+					run++
+					if run == 5 {
+						score += 3
+					} else if run > 5 {
+						score++
+					}
+				} else {
+					run = 1
+				}
+				if j+7 > q.size {
+					continue
+				}
+				match := true
+				for k, f := range finder {
+					match = match && at(i, j+k) == f
+				}
+				// with four light modules on either side
+				if match && (q.lightRun(at, i, j-4, j) || q.lightRun(at, i, j+7, j+11)) {
+					score += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark(x, y) {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.dark(x, y)
+				if q.dark(x-1, y) == c && q.dark(x, y-1) == c && q.dark(x-1, y-1) == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	// 10 points per 5% away from half dark
+	k := (abs(20*dark-10*total) + total - 1) / total
+	return score + 10*(k-1)
+}
+
+// lightRun reports whether the modules from j0 to j1 of line i,
+// where the quiet zone is light, are all light.
+func (q *qrCode) lightRun(at func(i, j int) bool, i, j0, j1 int) bool {
+	for j := j0; j < j1; j++ {
+		if j >= 0 && j < q.size && at(i, j) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
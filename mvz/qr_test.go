@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// HELLO WORLD in alphanumeric mode, version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("got error correction codewords %v, want %v", got, want)
+	}
+}
+
+func TestQRVersion(t *testing.T) {
+	for _, tc := range []struct {
+		n       int
+		level   QRLevel
+		version int
+	}{
+		{1, QRLow, 1},
+		{17, QRLow, 1},
+		{18, QRLow, 2},
+		{14, QRMedium, 1},
+		{15, QRMedium, 2},
+		{7, QRHigh, 1},
+		{8, QRHigh, 2},
+		{50, QRQuartile, 5},
+		{2953, QRLow, 40},
+	} {
+		q, err := encodeQR([]byte(strings.Repeat("a", tc.n)), tc.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := 4*tc.version + 17; q.version != tc.version || q.size != size {
+			t.Errorf("%d bytes at level %d: got version %d, %d modules, want %d, %d", tc.n, tc.level, q.version, q.size, tc.version, size)
+		}
+	}
+	if _, err := encodeQR(make([]byte, 2954), QRLow); err == nil {
+		t.Error("expected error for data exceeding version 40")
+	}
+}
+
+// decodeQR reads the data of a QR code, checking its format and
+// error correction codewords.
+func decodeQR(t *testing.T, q *qrCode) []byte {
+	format := 0
+	for i := 0; i <= 5; i++ {
+		format |= b2i(q.dark(8, i)) << uint(i)
+	}
+	format |= b2i(q.dark(8, 7))<<6 | b2i(q.dark(8, 8))<<7 | b2i(q.dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		format |= b2i(q.dark(14-i, 8)) << uint(i)
+	}
+	level, mask := QRLevel(-1), -1
+	for l := QRLow; l <= QRHigh; l++ {
+		for m := 0; m < 8; m++ {
+			if qrFormatBits(l, m) == format {
+				level, mask = l, m
+			}
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("invalid format information %015b", format)
+	}
+
+	// read the codewords as placed, then unmask
+	marked := &qrCode{version: q.version, size: q.size, modules: make([]bool, len(q.modules)), function: q.function}
+	copy(marked.modules, q.modules)
+	marked.applyMask(mask)
+	var bits qrBits
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = q.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if !q.function[y*q.size+x] {
+					bits = append(bits, byte(b2i(marked.dark(x, y))))
+				}
+			}
+		}
+	}
+	raw := bits[:len(bits)/8*8].bytes()
+
+	// deinterleave and check the blocks
+	numBlocks, eccLen := qrBlocks[level][q.version], qrECCPerBlock[level][q.version]
+	numShort, shortLen := numBlocks-len(raw)%numBlocks, len(raw)/numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortLen; i++ {
+		for j := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		n := len(block) - eccLen
+		if got := rsRemainder(block[:n], rsDivisor(eccLen)); !bytes.Equal(got, block[n:]) {
+			t.Errorf("block %d: wrong error correction codewords", j)
+		}
+		data = append(data, block[:n]...)
+	}
+
+	// byte mode segment
+	if data[0]>>4 != 4 {
+		t.Fatalf("got mode %d, want byte mode", data[0]>>4)
+	}
+	if q.version > 9 {
+		n := int(data[0]&15)<<12 | int(data[1])<<4 | int(data[2]>>4)
+		return shiftNibble(data[2:], n)
+	}
+	n := int(data[0]&15)<<4 | int(data[1]>>4)
+	return shiftNibble(data[1:], n)
+}
+
+// shiftNibble returns the n bytes starting at the low half of
+// data[0].
+func shiftNibble(data []byte, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = data[i]<<4 | data[i+1]>>4
+	}
+	return out
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		level   QRLevel
+	}{
+		{"https://example.com/track/12345", QRMedium},
+		{"x", QRHigh},
+		{strings.Repeat("0123456789", 30), QRQuartile}, // version 12, two block sizes
+		{strings.Repeat("tracking ", 100), QRLow},
+	} {
+		q, err := encodeQR([]byte(tc.content), tc.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(decodeQR(t, q)); got != tc.content {
+			t.Errorf("version %d: decoded %q, want %q", q.version, got, tc.content)
+		}
+		// the finder patterns are intact
+		for _, c := range [][2]int{{0, 0}, {q.size - 7, 0}, {0, q.size - 7}} {
+			if !q.dark(c[0], c[1]) || q.dark(c[0]+1, c[1]+1) || !q.dark(c[0]+3, c[1]+3) {
+				t.Errorf("version %d: damaged finder at %v", q.version, c)
+			}
+		}
+	}
+}
+
+func TestDrawQRCode(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	// version 1 with the quiet zone is 29 modules of 2 points
+	if err := p.DrawQRCode(page, 100, 100, 58, "hello", QRMedium); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DrawQRCode(page, 100, 100, 58, "hello", QRLevel(7)); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	for _, want := range []string{
+		"1 1 1 rg\n100.00 100.00 58.00 58.00 re\nf",
+		// the top row of the top left finder pattern
+		"0 0 0 rg\n108.00 148.00 14.00 2.00 re\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in content stream", want)
+		}
+	}
+}
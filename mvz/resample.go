@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+)
+
+// This file implements downscaling of images before embedding.
+
+// WriteJPEGPageDownscaled writes a page of the given size holding
+// img, scaled to fit and centered like WriteImageFitted without
+// margin. Images with more detail than targetDPI can show at that
+// size are first resampled to that resolution. The image is then
+// encoded as JPEG at the given quality.
+func (p *PDFWriter) WriteJPEGPageDownscaled(img image.Image, targetDPI float64, size PageSize, quality int) (PDFID, error) {
+	if !(targetDPI > 0) {
+		return 0, fmt.Errorf("invalid resolution %g", targetDPI)
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return 0, fmt.Errorf("empty image")
+	}
+	// the largest image fitting the page at the target resolution
+	maxW, maxH := float64(size.Width/INCH)*targetDPI, float64(size.Height/INCH)*targetDPI
+	scale := math.Min(maxW/float64(b.Dx()), maxH/float64(b.Dy()))
+	if scale < 1 {
+		w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+		h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+		img = downscale(img, w, h)
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ref, err := p.registerJPEG(img.Bounds().Dx(), img.Bounds().Dy(), buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	x, y, w, h, err := fitImage(ref, size, 0)
+	if err != nil {
+		return 0, err
+	}
+	var cs ContentStream
+	cs.DrawImage(ref, x, y, w, h)
+	return p.writeContentPage(size.Width, size.Height, &cs)
+}
+
+// downscale resamples img to w×h pixels, no larger than img, by
+// averaging the source pixels each destination pixel covers. Gray
+// images stay gray; others become RGBA.
+func downscale(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	_, gray := img.(*image.Gray)
+	xs, ys := boxWeights(b.Dx(), w), boxWeights(b.Dy(), h)
+
+	// horizontal pass, into w columns of premultiplied RGBA
+	rows := make([]float64, 4*w*b.Dy())
+	for sy := 0; sy < b.Dy(); sy++ {
+		row := rows[4*w*sy:]
+		for dx, ws := range xs {
+			var acc [4]float64
+			for _, c := range ws {
+				r, g, bl, a := img.At(b.Min.X+c.src, b.Min.Y+sy).RGBA()
+				acc[0] += c.weight * float64(r)
+				acc[1] += c.weight * float64(g)
+				acc[2] += c.weight * float64(bl)
+				acc[3] += c.weight * float64(a)
+			}
+			copy(row[4*dx:], acc[:])
+		}
+	}
+
+	// vertical pass
+	var dst image.Image
+	var set func(x, y int, c [4]float64)
+	if gray {
+		g := image.NewGray(image.Rect(0, 0, w, h))
+		dst = g
+		set = func(x, y int, c [4]float64) {
+			g.Pix[y*g.Stride+x] = sample8(c[0])
+		}
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		dst = rgba
+		set = func(x, y int, c [4]float64) {
+			rgba.SetRGBA(x, y, color.RGBA{sample8(c[0]), sample8(c[1]), sample8(c[2]), sample8(c[3])})
+		}
+	}
+	for dy, ws := range ys {
+		for dx := 0; dx < w; dx++ {
+			var acc [4]float64
+			for _, c := range ws {
+				px := rows[4*(w*c.src+dx):]
+				for k := range acc {
+					acc[k] += c.weight * px[k]
+				}
+			}
+			set(dx, dy, acc)
+		}
+	}
+	return dst
+}
+
+// A contribution is the weight of a source pixel in a
+// destination pixel.
+type contribution struct {
+	src    int
+	weight float64
+}
+
+// boxWeights returns, for each of m destination pixels, the source
+// pixels among n it covers and their weights, which sum to 1.
+func boxWeights(n, m int) [][]contribution {
+	scale := float64(n) / float64(m)
+	weights := make([][]contribution, m)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < n && float64(j) < end; j++ {
+			cover := math.Min(end, float64(j+1)) - math.Max(start, float64(j))
+			if cover > 0 {
+				weights[i] = append(weights[i], contribution{j, cover / scale})
+			}
+		}
+	}
+	return weights
+}
+
+// sample8 converts a 16-bit sample to 8 bits, rounding.
+func sample8(v float64) uint8 {
+	return uint8(math.Min(255, math.Max(0, math.Round(v/257))))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDownscale(t *testing.T) {
+	// 4x2 gray image: columns 0, 100, 200, 255
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x, v := range []uint8{0, 100, 200, 255} {
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	small, ok := downscale(img, 2, 1).(*image.Gray)
+	if !ok {
+		t.Fatal("gray image not downscaled to gray")
+	}
+	if got, want := small.Pix, []uint8{50, 228}; !bytes.Equal(got, want) {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+
+	// scaling 3 pixels to 2 splits the middle one
+	rgba := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	for x, v := range []uint8{0, 90, 180} {
+		rgba.SetRGBA(x, 0, color.RGBA{v, v, v, 255})
+	}
+	if got := downscale(rgba, 2, 1).(*image.RGBA).Pix; !bytes.Equal(got, []uint8{30, 30, 30, 255, 150, 150, 150, 255}) {
+		t.Errorf("got samples %v", got)
+	}
+}
+
+func TestWriteJPEGPageDownscaled(t *testing.T) {
+	// a 12 megapixel photo on an A5 page
+	img := image.NewRGBA(image.Rect(0, 0, 4000, 3000))
+	for y := 0; y < 3000; y++ {
+		for x := 0; x < 4000; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	write := func(dpi float64) []byte {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		if _, err := p.WriteJPEGPageDownscaled(img, dpi, A5.Landscape(), 80); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	full := write(1000)
+	small := write(150)
+	// A5 is 8.27 in wide and 5.83 in high: the image fits the
+	// height at 874 pixels
+	if !bytes.Contains(small, []byte("/Width 1165\n/Height 874\n")) {
+		t.Errorf("image not downscaled to 1165x874")
+	}
+	if !bytes.Contains(full, []byte("/Width 4000\n/Height 3000\n")) {
+		t.Errorf("image downscaled beyond its resolution")
+	}
+	if len(small) >= len(full)/2 {
+		t.Errorf("downscaled document is %d bytes, full one %d", len(small), len(full))
+	}
+
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	if _, err := p.WriteJPEGPageDownscaled(img, 0, A5, 80); err == nil {
+		t.Error("expected error for zero resolution")
+	}
+}
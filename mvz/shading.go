@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file implements smooth shadings.
+
+// A ShadingRef refers to a shading registered with
+// RegisterLinearGradient.
+type ShadingRef struct {
+	id PDFID
+}
+
+// RegisterLinearGradient writes an axial shading varying linearly
+// from the RGB color from at (x0, y0) to the color to at (x1, y1),
+// extended beyond both ends, to be painted with (*Canvas).Shade.
+// Color components are clamped to [0, 1].
+func (p *PDFWriter) RegisterLinearGradient(from, to [3]float64, x0, y0, x1, y1 Length) (ShadingRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if x0 == x1 && y0 == y1 {
+		return ShadingRef{}, fmt.Errorf("gradient axis has zero length")
+	}
+	id, _ := p.startObj()
+	p.print("/ShadingType 2")
+	p.print("/ColorSpace /DeviceRGB")
+	p.printf("/Coords [ %.2f %.2f %.2f %.2f ]", x0, y0, x1, y1)
+	p.printf("/Function << /FunctionType 2 /Domain [ 0 1 ] /C0 %s /C1 %s /N 1 >>",
+		colorArray(from), colorArray(to))
+	p.print("/Extend [ true true ]")
+	return ShadingRef{id}, p.endObj()
+}
+
+// colorArray formats an RGB color clamped to [0, 1] as an array.
+func colorArray(c [3]float64) string {
+	s := "["
+	for _, v := range c {
+		s += " " + formatNum(math.Max(0, math.Min(1, v)))
+	}
+	return s + " ]"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLinearGradient(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	sh, err := p.RegisterLinearGradient([3]float64{1, 0, 0}, [3]float64{0, 0.5, 2}, 0, 0, 0, A4.Height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := p.Canvas(page)
+	c.Save()
+	c.Rect(0, 0, A4.Width, A4.Height)
+	c.Clip()
+	c.Shade(sh)
+	c.Restore()
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	want := "/ShadingType 2\n/ColorSpace /DeviceRGB\n/Coords [ 0.00 0.00 0.00 841.89 ]\n" +
+		"/Function << /FunctionType 2 /Domain [ 0 1 ] /C0 [ 1 0 0 ] /C1 [ 0 0.5 1 ] /N 1 >>\n" +
+		"/Extend [ true true ]"
+	if dict := objs[sh.id-1].dict; !bytes.Contains(dict, []byte(want)) {
+		t.Errorf("got shading %s, want %s", dict, want)
+	}
+	res := fmt.Sprintf("/Shading << /Sh0 %d 0 R >>", sh.id)
+	if !bytes.Contains(objs[page-1].dict, []byte(res)) {
+		t.Errorf("missing %s in page %s", res, objs[page-1].dict)
+	}
+	content := pageContents(t, buf.Bytes(), page)
+	if want := "W n\n/Sh0 sh\nQ\n"; !bytes.Contains(content, []byte(want)) {
+		t.Errorf("content stream %q lacks %q", content, want)
+	}
+
+	if _, err := p.RegisterLinearGradient([3]float64{}, [3]float64{}, 1, 1, 1, 1); err == nil {
+		t.Error("expected error for zero-length axis")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// This file implements signature fields, to be signed by an
+// external signer.
+
+// SignatureSize is the space reserved for the signature of a
+// signature field, in bytes: enough for a PKCS#7 signature with a
+// certificate chain and a timestamp.
+const SignatureSize = 8192
+
+// byteRangeWidth is the width of each number of a /ByteRange
+// placeholder.
+const byteRangeWidth = 10
+
+// A SignaturePlaceholder is the signature dictionary of a signature
+// field, in which a signer writes the signature of the complete
+// file. The offsets it records are valid after Flush.
+type SignaturePlaceholder struct {
+	// offsets of the /ByteRange numbers and the /Contents string
+	byteRangeOff, contentsOff int
+	size                      int // of the file
+}
+
+// AddSignatureField adds a signature field to page, in the area
+// rect given as [x0 y0 x1 y1], with a signature dictionary whose
+// /Contents hole of SignatureSize zero bytes is left for a signer.
+// The /ByteRange of the dictionary, everything in the file except
+// the hole, is written by Flush if the output is an io.WriteSeeker;
+// otherwise, the signer must write it with WriteByteRange.
+func (p *PDFWriter) AddSignatureField(page PDFID, name string, rect [4]Length) (*SignaturePlaceholder, error) {
+	pg, err := p.fieldPage(page, name, rect)
+	if err != nil {
+		return nil, err
+	}
+	s := new(SignaturePlaceholder)
+	sig, _ := p.startObj()
+	p.print("/Type /Sig")
+	p.print("/Filter /Adobe.PPKLite")
+	p.print("/SubFilter /adbe.pkcs7.detached")
+	s.byteRangeOff = p.offset + len("/ByteRange [0 ")
+	p.printf("/ByteRange [0 %s]", s.formatByteRange(0))
+	// not encrypted, like all signature contents
+	s.contentsOff = p.offset + len("/Contents ")
+	p.printf("/Contents <%0*d>", 2*SignatureSize, 0)
+	p.endObj()
+
+	id := p.startWidget(page, name, rect)
+	p.print("/FT /Sig")
+	p.printf("/V %d 0 R", sig)
+	p.endObj()
+	pg.annots = append(pg.annots, id)
+	p.fields = append(p.fields, formField{name, id, nil})
+	p.signatures = append(p.signatures, s)
+	return s, p.err
+}
+
+// ByteRange returns the /ByteRange of the signature: the offset
+// and length of the file before the /Contents string, then after
+// it. It is valid after Flush.
+func (s *SignaturePlaceholder) ByteRange() [4]int {
+	end := s.contentsOff + 2*SignatureSize + 2
+	return [4]int{0, s.contentsOff, end, s.size - end}
+}
+
+// formatByteRange returns the last three numbers of the byte range
+// of a file of the given size, or placeholders if it is zero.
+func (s *SignaturePlaceholder) formatByteRange(size int) string {
+	r := [4]int{}
+	if size > 0 {
+		r = s.ByteRange()
+	}
+	return fmt.Sprintf("%-*d %-*d %-*d", byteRangeWidth, r[1], byteRangeWidth, r[2], byteRangeWidth, r[3])
+}
+
+// WriteByteRange writes the /ByteRange of the signature into file,
+// the complete document, unless Flush did.
+func (s *SignaturePlaceholder) WriteByteRange(file []byte) error {
+	if len(file) != s.size {
+		return fmt.Errorf("file has %d bytes, document has %d", len(file), s.size)
+	}
+	copy(file[s.byteRangeOff:], s.formatByteRange(s.size))
+	return nil
+}
+
+// WriteSignature writes signature, the DER-encoded PKCS#7 signature
+// of the byte range of file, into the /Contents hole of file.
+func (s *SignaturePlaceholder) WriteSignature(file, signature []byte) error {
+	if len(file) != s.size {
+		return fmt.Errorf("file has %d bytes, document has %d", len(file), s.size)
+	}
+	if len(signature) > SignatureSize {
+		return fmt.Errorf("signature of %d bytes exceeds the %d reserved", len(signature), SignatureSize)
+	}
+	hex.Encode(file[s.contentsOff+1:], signature)
+	return nil
+}
+
+// patchByteRanges records the file size in the signatures, and
+// writes their byte ranges if the output can seek.
+func (p *PDFWriter) patchByteRanges() error {
+	ws, seekable := p.w.(io.WriteSeeker)
+	for _, s := range p.signatures {
+		s.size = p.offset
+		if seekable {
+			if err := p.patch(ws, s.byteRangeOff, s.formatByteRange(s.size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var byteRangeRx = regexp.MustCompile(`/ByteRange \[(\d+) +(\d+) +(\d+) +(\d+) *\]`)
+
+// checkByteRange checks that the byte range of the signature in file
+// covers all of it except the /Contents string.
+func checkByteRange(t *testing.T, file []byte, s *SignaturePlaceholder) {
+	m := byteRangeRx.FindSubmatch(file)
+	if m == nil {
+		t.Fatal("no byte range in file")
+	}
+	var r [4]int
+	for i := range r {
+		fmt.Sscan(string(m[1+i]), &r[i])
+	}
+	if r != s.ByteRange() {
+		t.Errorf("got byte range %v in file, placeholder has %v", r, s.ByteRange())
+	}
+	if r[0] != 0 || r[2]+r[3] != len(file) {
+		t.Errorf("byte range %v does not cover the %d bytes of the file", r, len(file))
+	}
+	hole := string(file[r[1]:r[2]])
+	if want := "<" + strings.Repeat("0", 2*SignatureSize) + ">"; hole != want {
+		t.Errorf("byte range %v does not exclude exactly the contents: hole is %.20q...", r, hole)
+	}
+	if !bytes.HasSuffix(file[:r[1]], []byte("/Contents ")) {
+		t.Errorf("byte range %v: hole does not start at the contents", r)
+	}
+}
+
+func TestSignatureField(t *testing.T) {
+	out := new(seekBuffer)
+	p, _ := NewPDFWriter(out)
+	page, _ := p.WriteBlankPage(A4)
+	s, err := p.AddSignatureField(page, "signature", [4]Length{72, 72, 272, 122})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	file := out.buf
+	checkByteRange(t, file, s)
+	if err := Verify(bytes.NewReader(file), int64(len(file))); err != nil {
+		t.Error(err)
+	}
+
+	field := findObject(t, file, "/FT /Sig")
+	if !bytes.Contains(field.dict, []byte("/T (signature)")) || !bytes.Contains(field.dict, []byte("/Subtype /Widget")) {
+		t.Errorf("wrong signature field %s", field.dict)
+	}
+	if catalog := readObjects(t, file)[CATALOG_ID-1]; !bytes.Contains(catalog.dict, []byte(fmt.Sprintf("/AcroForm << /Fields [ %d 0 R ] /SigFlags 3 >>", field.id))) {
+		t.Errorf("wrong form in %s", catalog.dict)
+	}
+
+	if err := s.WriteSignature(file, []byte{0xde, 0xad}); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.ByteRange(); !bytes.HasPrefix(file[r[1]:], []byte("<dead0000")) {
+		t.Errorf("signature not in contents: %.20q", file[r[1]:])
+	}
+	if err := s.WriteSignature(file, make([]byte, SignatureSize+1)); err == nil {
+		t.Error("expected error for oversized signature")
+	}
+}
+
+func TestSignatureFieldNoSeek(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	s, _ := p.AddSignatureField(page, "signature", [4]Length{72, 72, 272, 122})
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if err := s.WriteByteRange(file[1:]); err == nil {
+		t.Error("expected error for a truncated file")
+	}
+	if err := s.WriteByteRange(file); err != nil {
+		t.Fatal(err)
+	}
+	checkByteRange(t, file, s)
+}
@@ -0,0 +1,124 @@
+package main
+
+import "fmt"
+
+// This file implements tables.
+
+// A Table is a grid of text cells with borders, drawn by DrawTable.
+// Long cells wrap, making their row taller.
+type Table struct {
+	// Size is the font size of the cells, in points, and Padding
+	// the space between their text and borders.
+	Size    float64
+	Padding Length
+
+	widths []Length
+	rows   [][]string
+}
+
+// NewTable returns an empty table with columns of the given widths,
+// with 10-point text and 3 points of padding.
+func NewTable(widths ...Length) *Table {
+	return &Table{Size: 10, Padding: 3, widths: widths}
+}
+
+// AddRow adds a row to the table, with one cell per column.
+func (t *Table) AddRow(cells ...string) error {
+	if len(cells) != len(t.widths) {
+		return fmt.Errorf("row has %d cells, table has %d columns", len(cells), len(t.widths))
+	}
+	t.rows = append(t.rows, cells)
+	return nil
+}
+
+// DrawTable draws t on page, in the current font, with its top
+// left corner at (x, top). Rows that do not fit above the bottom
+// of the content box of the page continue at the top of the content
+// box of new pages of the same size. It returns the last page drawn
+// on.
+func (p *PDFWriter) DrawTable(page PDFID, x, top Length, t *Table) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(t.widths) == 0 {
+		return page, fmt.Errorf("table without columns")
+	}
+	for _, w := range t.widths {
+		if w <= 2*t.Padding {
+			return page, fmt.Errorf("column width %.2f leaves no room for text", w)
+		}
+	}
+	pg, err := p.page(page)
+	if err != nil {
+		return page, err
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return page, err
+	}
+	leading := Length(1.2 * t.Size)
+	ys := []Length{top} // row boundaries on the current page
+	for _, row := range t.rows {
+		// the height of the row is that of its tallest cell
+		lines := 1
+		for i, cell := range row {
+			wrapped, _ := wrapText(f, t.Size, t.widths[i]-2*t.Padding, cell, len(cell)+1)
+			if len(wrapped) > lines {
+				lines = len(wrapped)
+			}
+		}
+		h := Length(lines)*leading + 2*t.Padding
+		box, _ := p.contentBox(pg)
+		y := ys[len(ys)-1]
+		if y-h < box[1] && len(ys) > 1 {
+			p.drawGrid(pg, x, t.widths, ys)
+			id, err := p.writeContentPage(pg.width, pg.height, nil)
+			if err != nil {
+				return page, err
+			}
+			page = id
+			if pg, err = p.page(id); err != nil {
+				return page, err
+			}
+			box, _ = p.contentBox(pg)
+			y = box[3]
+			ys = []Length{y}
+		}
+		cx := x
+		for i, cell := range row {
+			if cell != "" {
+				rect := [4]Length{cx + t.Padding, y - h + t.Padding, cx + t.widths[i] - t.Padding, y - t.Padding}
+				pg.extra.textBox(f, t.Size, rect, cell, p.text)
+			}
+			cx += t.widths[i]
+		}
+		ys = append(ys, y-h)
+	}
+	if len(ys) > 1 {
+		p.drawGrid(pg, x, t.widths, ys)
+	}
+	return page, p.err
+}
+
+// drawGrid draws the borders of table rows on pg: horizontal lines
+// at ys, and vertical lines between columns of the given widths.
+func (p *PDFWriter) drawGrid(pg *pdfPage, x Length, widths []Length, ys []Length) {
+	c := pg.extra.Canvas()
+	c.SetLineWidth(0.5)
+	right := x
+	for _, w := range widths {
+		right += w
+	}
+	for _, y := range ys {
+		c.MoveTo(x, y)
+		c.LineTo(right, y)
+	}
+	cx := x
+	for i := 0; i <= len(widths); i++ {
+		c.MoveTo(cx, ys[0])
+		c.LineTo(cx, ys[len(ys)-1])
+		if i < len(widths) {
+			cx += widths[i]
+		}
+	}
+	c.Stroke()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := NewTable(INCH, 2*INCH, INCH)
+	rows := [][]string{
+		{"Name", "Description", "Price"},
+		{"Widget", "A small widget", "1.00"},
+		{"Gadget", "", "2.50"},
+		{"Gizmo", "A gizmo with a description too long for one line", "10.00"},
+	}
+	for _, row := range rows {
+		if err := tbl.AddRow(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tbl.AddRow("too", "few"); err == nil {
+		t.Error("expected error for wrong number of cells")
+	}
+	last, err := p.DrawTable(page, INCH, 10*INCH, tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != page {
+		t.Errorf("table ended on page %d, want %d", last, page)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	content := string(pageContents(t, buf.Bytes(), page))
+	// 5 horizontal and 4 vertical lines
+	if n := strings.Count(content, " m\n"); n != 9 {
+		t.Errorf("got %d lines, want 9:\n%s", n, content)
+	}
+	// 11 non-empty cells, one of them on 2 lines
+	if n := strings.Count(content, "BT\n"); n != 11 {
+		t.Errorf("got %d text boxes, want 11", n)
+	}
+	if n := strings.Count(content, " Tj\n"); n != 12 {
+		t.Errorf("got %d text lines, want 12", n)
+	}
+	// rows are 18 points high, the last one 30
+	for _, line := range []string{
+		"72.00 720.00 m\n360.00 720.00 l\n",
+		"72.00 666.00 m\n360.00 666.00 l\n",
+		"72.00 636.00 m\n360.00 636.00 l\n",
+		"72.00 720.00 m\n72.00 636.00 l\n",
+	} {
+		if !strings.Contains(content, line) {
+			t.Errorf("missing line %q", line)
+		}
+	}
+}
+
+func TestTablePageBreak(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf, WithMargins(INCH, INCH, INCH, INCH))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := NewTable(2 * INCH)
+	for i := 0; i < 40; i++ {
+		if err := tbl.AddRow("row"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 18-point rows: 34 fit between the starting point and the
+	// bottom margin
+	last, err := p.DrawTable(page, INCH, 9.5*INCH, tbl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == page {
+		t.Fatal("table did not break across pages")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	first := string(pageContents(t, buf.Bytes(), page))
+	if n := strings.Count(first, " Tj\n"); n != 34 {
+		t.Errorf("got %d rows on first page, want 34", n)
+	}
+	second := string(pageContents(t, buf.Bytes(), last))
+	if n := strings.Count(second, " Tj\n"); n != 6 {
+		t.Errorf("got %d rows on second page, want 6", n)
+	}
+	if !strings.Contains(second, "72.00 720.00 m\n216.00 720.00 l\n") {
+		t.Error("second page does not start at the top of the content box")
+	}
+
+	if _, err := p.DrawTable(page, 0, 0, NewTable(4)); err == nil {
+		t.Error("expected error for column narrower than padding")
+	}
+}
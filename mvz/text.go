@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// This file implements text drawing.
+
+// A Font is a font resource of a document.
+type Font struct {
+	BaseFont string
+	name     string // resource name
+	id       PDFID
+	ttf      *trueType // nil for standard fonts
+}
+
+// The standard 14 fonts, available in all PDF viewers.
+var standardFonts = []string{
+	"Times-Roman", "Times-Bold", "Times-Italic", "Times-BoldItalic",
+	"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Helvetica-BoldOblique",
+	"Courier", "Courier-Bold", "Courier-Oblique", "Courier-BoldOblique",
+	"Symbol", "ZapfDingbats",
+}
+
+// StandardFont returns one of the standard 14 Type 1 fonts,
+// writing its font dictionary on first use.
+func (p *PDFWriter) StandardFont(name string) (*Font, error) {
+	if f := p.fonts[name]; f != nil {
+		return f, nil
+	}
+	known := false
+	for _, s := range standardFonts {
+		known = known || s == name
+	}
+	if !known {
+		return nil, fmt.Errorf("%s is not a standard font", name)
+	}
+	id, _ := p.startObj()
+	p.print("/Type /Font")
+	p.print("/Subtype /Type1")
+	p.printf("/BaseFont /%s", name)
+	if name != "Symbol" && name != "ZapfDingbats" {
+		p.print("/Encoding /WinAnsiEncoding")
+	}
+	p.endObj()
+	f := &Font{BaseFont: name, name: fmt.Sprintf("F%d", len(p.fonts)+1), id: id}
+	if p.fonts == nil {
+		p.fonts = make(map[string]*Font)
+	}
+	p.fonts[name] = f
+	return f, p.err
+}
+
+// writeFonts writes the font dictionaries deferred until Flush,
+// in the order the fonts were added.
+func (p *PDFWriter) writeFonts() {
+	fonts := make([]*Font, 0, len(p.fonts))
+	for _, f := range p.fonts {
+		if f.ttf != nil {
+			fonts = append(fonts, f)
+		}
+	}
+	sort.Slice(fonts, func(i, j int) bool { return fonts[i].id < fonts[j].id })
+	for _, f := range fonts {
+		p.writeTrueType(f)
+	}
+}
+
+// SetFont sets the font used by DrawText. The default is Helvetica.
+func (p *PDFWriter) SetFont(f *Font) {
+	p.font = f
+}
+
+// SetTextDirection sets the direction of the text drawn by
+// DrawText and DrawTextBox, as by (*ContentStream).SetTextDirection.
+// The default is LeftToRight.
+func (p *PDFWriter) SetTextDirection(dir TextDirection) {
+	p.text.dir = dir
+}
+
+// SetTextColor sets the color of the text drawn by DrawText and
+// DrawTextBox, as by (*ContentStream).SetTextColor.
+func (p *PDFWriter) SetTextColor(r, g, b float64) {
+	p.text.color = textColor(r, g, b)
+}
+
+// SetTextRenderMode sets how the text drawn by DrawText and
+// DrawTextBox is painted. InvisibleText makes a searchable text
+// layer over page images.
+func (p *PDFWriter) SetTextRenderMode(mode TextRenderMode) {
+	p.text.mode = mode
+}
+
+// DrawText draws a line of text on page, starting at (x, y),
+// with the given font size, in points. Right-to-left text ends
+// at (x, y). The coordinates are relative to the lower left corner
+// of the content box of the page, which is that of the page unless
+// margins are set.
+func (p *PDFWriter) DrawText(page PDFID, x, y Length, size float64, text string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return err
+	}
+	box, _ := p.contentBox(pg)
+	pg.extra.text(f, size, box[0]+x, box[1]+y, text, p.text)
+	return p.err
+}
+
+// DrawTextBox draws text on page in the rectangle [x0 y0 x1 y1],
+// with the given font size, as by (*ContentStream).TextBox. A zero
+// rectangle stands for the content box of the page. It returns the
+// text that did not fit in the rectangle.
+func (p *PDFWriter) DrawTextBox(page PDFID, rect [4]Length, size float64, text string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return text, err
+	}
+	if rect == ([4]Length{}) {
+		rect, _ = p.contentBox(pg)
+	}
+	if rect[2] <= rect[0] || rect[3] <= rect[1] {
+		return text, fmt.Errorf("invalid rectangle %s", formatRect(rect))
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return text, err
+	}
+	return pg.extra.textBox(f, size, rect, text, p.text), p.err
+}
+
+// wrapText breaks text into at most maxLines lines no wider than
+// width, at spaces and newlines, returning the lines and the text
+// left over. Words wider than a line are kept whole.
+func wrapText(f *Font, size float64, width Length, text string, maxLines int) (lines []string, rest string) {
+	for len(text) > 0 && len(lines) < maxLines {
+		para := text
+		nl := strings.IndexByte(text, '\n')
+		if nl >= 0 {
+			para = text[:nl]
+		}
+		// end is the end of the last word that fits, i the
+		// start of the next one
+		end, i := 0, 0
+		for i < len(para) {
+			j := strings.IndexByte(para[i:], ' ')
+			if j < 0 {
+				j = len(para)
+			} else {
+				j += i
+			}
+			if end > 0 && f.StringWidth(para[:j], size) > width {
+				break
+			}
+			end = j
+			for j < len(para) && para[j] == ' ' {
+				j++
+			}
+			i = j
+		}
+		lines = append(lines, para[:end])
+		text = text[i:]
+		if i == len(para) && nl >= 0 {
+			text = text[1:]
+		}
+	}
+	return lines, text
+}
+
+// currentFont returns the font set by SetFont, or Helvetica.
+func (p *PDFWriter) currentFont() (*Font, error) {
+	if p.font == nil {
+		f, err := p.StandardFont("Helvetica")
+		if err != nil {
+			return nil, err
+		}
+		p.font = f
+	}
+	return p.font, nil
+}
+
+// Headers and footers are drawn in the current font, at this
+// size, inside the page margins or, if there are none, at this
+// distance from the page edges.
+const (
+	headerSize   = 10
+	headerMargin = INCH / 2
+)
+
+// SetHeader sets a function returning the header of each page,
+// given its number, from 1, and the number of pages. It is called
+// by Flush; empty headers are not drawn.
+func (p *PDFWriter) SetHeader(header func(page, total int) string) {
+	p.headerFunc = header
+}
+
+// SetFooter sets a function returning the footer of each page,
+// like SetHeader.
+func (p *PDFWriter) SetFooter(footer func(page, total int) string) {
+	p.footerFunc = footer
+}
+
+// drawHeaders draws the headers and footers of all pages.
+func (p *PDFWriter) drawHeaders() error {
+	if p.headerFunc == nil && p.footerFunc == nil {
+		return nil
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return err
+	}
+	for i, pg := range p.pages {
+		box, ok := p.contentBox(pg)
+		if !ok {
+			box = [4]Length{headerMargin, headerMargin, pg.width - headerMargin, pg.height - headerMargin}
+		}
+		if p.headerFunc != nil {
+			if text := p.headerFunc(i+1, len(p.pages)); text != "" {
+				pg.extra.Text(f, headerSize, box[0], box[3]-headerSize, text)
+			}
+		}
+		if p.footerFunc != nil {
+			if text := p.footerFunc(i+1, len(p.pages)); text != "" {
+				pg.extra.Text(f, headerSize, box[0], box[1], text)
+			}
+		}
+	}
+	return nil
+}
+
+// encode returns text as a string operand in the font's encoding.
+func (f *Font) encode(text string) string {
+	if f.ttf != nil {
+		return f.ttf.encode(text)
+	}
+	return "(" + escapePDFBytes(winAnsi(text)) + ")"
+}
+
+// StringWidth returns the width of s drawn in the font at the
+// given size, in points, ignoring kerning.
+func (f *Font) StringWidth(s string, size float64) Length {
+	w := 0
+	if f.ttf != nil {
+		for _, r := range s {
+			w += f.ttf.width(r)
+		}
+	} else if widths := standardWidths[f.BaseFont]; widths != nil {
+		for _, c := range []byte(winAnsi(s)) {
+			if c >= 32 {
+				w += int(widths[c-32])
+			}
+		}
+	}
+	return Length(float64(w) * size / 1000)
+}
+
+// winAnsi converts s to the WinAnsi encoding used by
+// standard fonts. Unsupported characters are replaced by '?'.
+func winAnsi(s string) string {
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80, r >= 0xa0 && r <= 0xff:
+			buf = append(buf, byte(r))
+		default:
+			buf = append(buf, '?')
+		}
+	}
+	return string(buf)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDrawText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	if err := p.DrawText(page, 72, 36, 12, "Page 1 (of 2)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	font := findObject(t, buf.Bytes(), "/Type /Font")
+	if !bytes.Contains(font.dict, []byte("/Subtype /Type1\n/BaseFont /Helvetica\n")) {
+		t.Errorf("wrong font dictionary %s", font.dict)
+	}
+	pg := readObjects(t, buf.Bytes())[page-1]
+	res := fmt.Sprintf("/Font << /F1 %d 0 R >>", font.id)
+	if !bytes.Contains(pg.dict, []byte(res)) {
+		t.Errorf("missing %s in page resources %s", res, pg.dict)
+	}
+	content := pageContents(t, buf.Bytes(), page)
+	want := "BT\n/F1 12.00 Tf\n72.00 36.00 Td\n(Page 1 \\(of 2\\)) Tj\nET\n"
+	if string(content) != want {
+		t.Errorf("got content stream %q, want %q", content, want)
+	}
+	if _, err := p.StandardFont("Comic Sans"); err == nil {
+		t.Errorf("expected error for a non-standard font")
+	}
+}
+
+// A failWriter fails once fail is set.
+type failWriter struct {
+	fail bool
+}
+
+func (w *failWriter) Write(b []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("write failed")
+	}
+	return len(b), nil
+}
+
+func TestHeaderError(t *testing.T) {
+	w := new(failWriter)
+	p, _ := newPDFWriter(w, false)
+	p.WriteBlankPage(Letter)
+	p.SetHeader(func(page, total int) string { return "Report" })
+	// the header font cannot be written
+	w.fail = true
+	err := p.Flush()
+	if err == nil {
+		t.Fatal("expected error writing the header font")
+	}
+	w.fail = false
+	if again := p.Flush(); again != err {
+		t.Errorf("flushing again: got %v, want %v", again, err)
+	}
+	if n := p.pages[0].extra.Len(); n != 0 {
+		t.Errorf("header drawn after the error: %d bytes", n)
+	}
+	if again := p.Close(); again != err {
+		t.Errorf("closing: got %v, want %v", again, err)
+	}
+}
+
+func TestHeaderFooter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var pages []PDFID
+	for i := 0; i < 3; i++ {
+		page, _ := p.WriteBlankPage(Letter)
+		pages = append(pages, page)
+	}
+	p.SetHeader(func(page, total int) string {
+		if page == 1 {
+			return "" // no header on the cover
+		}
+		return "Report"
+	})
+	p.SetFooter(func(page, total int) string {
+		return fmt.Sprintf("Page %d of %d", page, total)
+	})
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, page := range pages {
+		want := ""
+		if i > 0 {
+			want = "BT\n/F1 10.00 Tf\n36.00 746.00 Td\n(Report) Tj\nET\n"
+		}
+		want += fmt.Sprintf("BT\n/F1 10.00 Tf\n36.00 36.00 Td\n(Page %d of 3) Tj\nET\n", i+1)
+		if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+			t.Errorf("page %d: got content stream %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+func TestStringWidth(t *testing.T) {
+	p, _ := NewPDFWriter(ioutil.Discard)
+	for _, tc := range []struct {
+		font, text string
+		want       Length
+	}{
+		// H 722, e 556, l 222, l 222, o 556
+		{"Helvetica", "Hello", 27.336},
+		{"Helvetica-Oblique", "Hello", 27.336},
+		{"Helvetica-Bold", "Hello", 29.34},
+		{"Times-Roman", "Hello", 26.664},
+		{"Courier", "Hello", 36},
+		{"Helvetica", "", 0},
+		{"Helvetica", "é€", 6.672 + 6.672}, // € is replaced by ?
+	} {
+		f, err := p.StandardFont(tc.font)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.StringWidth(tc.text, 12); math.Abs(float64(got-tc.want)) > 0.001 {
+			t.Errorf("width of %q in %s: got %.3f, want %.3f", tc.text, tc.font, got, tc.want)
+		}
+	}
+
+	ttf, err := parseTrueType(buildTTF("TestSans", map[rune]int{' ': 512, 'A': 1366}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Font{BaseFont: "TestSans", ttf: ttf}
+	// 667 + 250 + 667 per 1000 units per em
+	if got := f.StringWidth("A A", 10); math.Abs(float64(got-15.84)) > 0.001 {
+		t.Errorf("width of embedded font text: got %.3f, want 15.84", got)
+	}
+}
+
+func TestDrawTextBox(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	// 80pt fits about 15 average Helvetica characters at 10pt
+	text := "The quick brown fox jumps over the lazy dog,\nthen sleeps."
+	rest, err := p.DrawTextBox(page, [4]Length{72, 500, 152, 700}, 10, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest != "" {
+		t.Errorf("got leftover text %q", rest)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	want := []string{"(The quick brown)", "(fox jumps over)", "(the lazy dog,)", "(then sleeps.)"}
+	if n := strings.Count(content, " Tj\n"); n != len(want) {
+		t.Errorf("got %d lines, want %d in %s", n, len(want), content)
+	}
+	for _, line := range want {
+		if !strings.Contains(content, line+" Tj\n") {
+			t.Errorf("missing line %s in %s", line, content)
+		}
+	}
+	if !strings.Contains(content, "72.00 500.00 80.00 200.00 re W n\n") ||
+		!strings.Contains(content, "72.00 690.00 Td\n") ||
+		strings.Count(content, "0.00 -12.00 Td\n") != 3 {
+		t.Errorf("wrong clipping or line placement in %s", content)
+	}
+}
+
+func TestTextBoxOverflow(t *testing.T) {
+	f := &Font{BaseFont: "Helvetica", name: "F1"}
+	var cs ContentStream
+	// room for two lines of 10pt text
+	rest := cs.TextBox(f, 10, [4]Length{0, 0, 80, 25}, "The quick brown fox jumps over the lazy dog")
+	if rest != "the lazy dog" {
+		t.Errorf("got leftover text %q, want %q", rest, "the lazy dog")
+	}
+	if n := strings.Count(cs.buf.String(), " Tj\n"); n != 2 {
+		t.Errorf("got %d lines, want 2", n)
+	}
+	if rest := cs.TextBox(f, 10, [4]Length{0, 0, 80, 5}, "hello"); rest != "hello" {
+		t.Errorf("got leftover text %q from box lower than a line", rest)
+	}
+}
+
+func TestTextRenderMode(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	page, _ := p.WriteBlankPage(A4)
+	p.SetTextRenderMode(InvisibleText)
+	p.DrawText(page, 72, 72, 12, "recognized")
+	p.SetTextRenderMode(FillText)
+	p.SetTextColor(1, 0, 0.5)
+	p.DrawText(page, 72, 144, 12, "red")
+	p.SetTextRenderMode(FillStrokeText)
+	p.DrawTextBox(page, [4]Length{72, 200, 300, 300}, 12, "boxed")
+	p.SetTextColor(0, 0, 0)
+	p.SetTextRenderMode(FillText)
+	p.DrawText(page, 72, 400, 12, "plain")
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content := string(pageContents(t, buf.Bytes(), page))
+	for _, want := range []string{
+		"q\nBT\n/F1 12.00 Tf\n3 Tr\n72.00 72.00 Td\n(recognized) Tj\nET\nQ\n",
+		"q\nBT\n/F1 12.00 Tf\n1 0 0.5 rg 1 0 0.5 RG\n72.00 144.00 Td\n(red) Tj\nET\nQ\n",
+		"BT\n/F1 12.00 Tf\n1 0 0.5 rg 1 0 0.5 RG\n2 Tr\n72.00 288.00 Td\n(boxed) Tj\nET\nQ\n",
+		// black is set explicitly
+		"q\nBT\n/F1 12.00 Tf\n0 0 0 rg 0 0 0 RG\n72.00 400.00 Td\n(plain) Tj\nET\nQ\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content stream lacks %q:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// This file implements embedding of TrueType fonts.
+
+// trueType holds the parts of a TrueType font needed to embed it.
+type trueType struct {
+	data        []byte
+	name        string // PostScript name
+	unitsPerEm  int
+	bbox        [4]int
+	ascent      int
+	descent     int
+	capHeight   int
+	italicAngle float64
+	fixedPitch  bool
+	advances    []int // by glyph index
+	cmap        map[rune]uint16
+
+	descriptor PDFID
+	used       map[uint16]rune // glyphs drawn, with their characters
+}
+
+// sfntTables returns the table directory of a TrueType font.
+func sfntTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("truncated font header")
+	}
+	switch v := binary.BigEndian.Uint32(data); v {
+	case 0x00010000, 0x74727565: // 1.0, "true"
+	default:
+		return nil, fmt.Errorf("unsupported font version %#x", v)
+	}
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*n {
+		return nil, fmt.Errorf("truncated table directory")
+	}
+	tables := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		rec := data[12+16*i:]
+		off := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		if uint64(off)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("table %q out of bounds", rec[:4])
+		}
+		tables[string(rec[:4])] = data[off : off+length]
+	}
+	return tables, nil
+}
+
+// parseTrueType reads the metrics and character map of a TrueType font.
+func parseTrueType(data []byte) (*trueType, error) {
+	tables, err := sfntTables(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []string{"head", "hhea", "maxp", "hmtx", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+	u16 := func(b []byte, off int) int { return int(binary.BigEndian.Uint16(b[off:])) }
+	i16 := func(b []byte, off int) int { return int(int16(binary.BigEndian.Uint16(b[off:]))) }
+
+	f := &trueType{data: data}
+	head, hhea, maxp := tables["head"], tables["hhea"], tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, fmt.Errorf("truncated font tables")
+	}
+	f.unitsPerEm = u16(head, 18)
+	if f.unitsPerEm == 0 {
+		return nil, fmt.Errorf("invalid unitsPerEm")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = i16(head, 36+2*i)
+	}
+	f.ascent, f.descent = i16(hhea, 4), i16(hhea, 6)
+	f.capHeight = f.ascent
+	if os2 := tables["OS/2"]; len(os2) >= 90 && u16(os2, 0) >= 2 {
+		f.capHeight = i16(os2, 88)
+	}
+	if post := tables["post"]; len(post) >= 16 {
+		f.italicAngle = float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536
+		f.fixedPitch = binary.BigEndian.Uint32(post[12:]) != 0
+	}
+
+	// glyph advances; glyphs past numberOfHMetrics repeat the last one
+	numGlyphs, numMetrics := u16(maxp, 4), u16(hhea, 34)
+	hmtx := tables["hmtx"]
+	if numMetrics == 0 || numMetrics > numGlyphs || len(hmtx) < 4*numMetrics {
+		return nil, fmt.Errorf("invalid hmtx table")
+	}
+	f.advances = make([]int, numGlyphs)
+	for i := range f.advances {
+		if i < numMetrics {
+			f.advances[i] = u16(hmtx, 4*i)
+		} else {
+			f.advances[i] = f.advances[numMetrics-1]
+		}
+	}
+
+	if f.cmap, err = parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	f.name = postScriptName(tables["name"])
+	return f, nil
+}
+
+// parseCmap reads the Unicode BMP subtable (format 4) of a cmap table.
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, fmt.Errorf("truncated cmap table")
+	}
+	var sub []byte
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n && 4+8*i+8 <= len(cmap); i++ {
+		rec := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		off := binary.BigEndian.Uint32(rec[4:])
+		if platform == 0 || platform == 3 && encoding == 1 {
+			if int(off)+4 <= len(cmap) && binary.BigEndian.Uint16(cmap[off:]) == 4 {
+				sub = cmap[off:]
+				break
+			}
+		}
+	}
+	if sub == nil || len(sub) < 14 {
+		return nil, fmt.Errorf("no Unicode cmap subtable")
+	}
+	segs := int(binary.BigEndian.Uint16(sub[6:])) / 2
+	if len(sub) < 16+8*segs {
+		return nil, fmt.Errorf("truncated cmap subtable")
+	}
+	seg := func(array, i int) int {
+		// endCode, startCode, idDelta and idRangeOffset arrays
+		off := 14 + 2*segs*array + 2*i
+		if array > 0 {
+			off += 2 // reservedPad
+		}
+		return int(binary.BigEndian.Uint16(sub[off:]))
+	}
+	m := make(map[rune]uint16)
+	for i := 0; i < segs; i++ {
+		end, start, delta, rangeOff := seg(0, i), seg(1, i), seg(2, i), seg(3, i)
+		for c := start; c <= end && c != 0xffff; c++ {
+			var g int
+			if rangeOff == 0 {
+				g = (c + delta) & 0xffff
+			} else {
+				off := 16 + 6*segs + 2*i + rangeOff + 2*(c-start)
+				if off+2 > len(sub) {
+					break
+				}
+				if g = int(binary.BigEndian.Uint16(sub[off:])); g != 0 {
+					g = (g + delta) & 0xffff
+				}
+			}
+			if g != 0 {
+				m[rune(c)] = uint16(g)
+			}
+		}
+	}
+	return m, nil
+}
+
+// postScriptName returns the PostScript name (ID 6) from a name
+// table, or "" if there is none.
+func postScriptName(name []byte) string {
+	if len(name) < 6 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(name[2:]))
+	storage := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < n && 6+12*i+12 <= len(name); i++ {
+		rec := name[6+12*i:]
+		if binary.BigEndian.Uint16(rec[6:]) != 6 {
+			continue
+		}
+		length, off := int(binary.BigEndian.Uint16(rec[8:])), int(binary.BigEndian.Uint16(rec[10:]))
+		if storage+off+length > len(name) {
+			continue
+		}
+		s := name[storage+off : storage+off+length]
+		switch binary.BigEndian.Uint16(rec) {
+		case 1: // Macintosh, Roman
+			return string(s)
+		case 3: // Windows, UTF-16BE
+			u := make([]uint16, len(s)/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(s[2*j:])
+			}
+			return string(utf16.Decode(u))
+		}
+	}
+	return ""
+}
+
+// scale converts font units to the 1000 units per em used by PDF.
+func (f *trueType) scale(v int) int {
+	return int(math.Round(float64(v) * 1000 / float64(f.unitsPerEm)))
+}
+
+// width returns the advance width of r, in 1000 units per em.
+func (f *trueType) width(r rune) int {
+	return f.glyphWidth(f.cmap[r])
+}
+
+// glyphWidth returns the advance width of glyph g, in 1000 units per em.
+func (f *trueType) glyphWidth(g uint16) int {
+	if int(g) >= len(f.advances) {
+		g = 0
+	}
+	return f.scale(f.advances[g])
+}
+
+// encode returns text as a hex string of 2-byte glyph indices,
+// recording the glyphs used.
+func (f *trueType) encode(text string) string {
+	buf := make([]byte, 0, 2+4*len(text))
+	buf = append(buf, '<')
+	for _, r := range text {
+		g := f.cmap[r]
+		if _, ok := f.used[g]; !ok && g != 0 {
+			f.used[g] = r
+		}
+		buf = append(buf, fmt.Sprintf("%04x", g)...)
+	}
+	return string(append(buf, '>'))
+}
+
+// writeTrueType writes the dictionaries of an embedded font.
+func (p *PDFWriter) writeTrueType(font *Font) error {
+	f := font.ttf
+	glyphs := make([]int, 0, len(f.used))
+	for g := range f.used {
+		glyphs = append(glyphs, int(g))
+	}
+	sort.Ints(glyphs)
+
+	cidFont, _ := p.startObj()
+	p.print("/Type /Font")
+	p.print("/Subtype /CIDFontType2")
+	p.printf("/BaseFont /%s", font.BaseFont)
+	p.printf("/CIDSystemInfo << /Registry %s /Ordering %s /Supplement 0 >>", p.str("Adobe"), p.str("Identity"))
+	p.printf("/FontDescriptor %d 0 R", f.descriptor)
+	p.printf("/DW %d", f.glyphWidth(0))
+	widths := new(bytes.Buffer)
+	for _, g := range glyphs {
+		fmt.Fprintf(widths, "%d [%d] ", g, f.glyphWidth(uint16(g)))
+	}
+	p.printf("/W [ %s]", widths)
+	p.print("/CIDToGIDMap /Identity")
+	p.endObj()
+
+	toUnicode, _ := p.writeStreamObject(toUnicodeCMap(f.used, glyphs))
+
+	p.startObjID(font.id)
+	p.print("/Type /Font")
+	p.print("/Subtype /Type0")
+	p.printf("/BaseFont /%s", font.BaseFont)
+	p.print("/Encoding /Identity-H")
+	p.printf("/DescendantFonts [ %d 0 R ]", cidFont)
+	p.printf("/ToUnicode %d 0 R", toUnicode)
+	return p.endObj()
+}
+
+// toUnicodeCMap returns a CMap mapping glyphs to their characters.
+func toUnicodeCMap(chars map[uint16]rune, glyphs []int) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(`/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def
+/CMapName /Adobe-Identity-UCS def
+/CMapType 2 def
+1 begincodespacerange
+<0000> <ffff>
+endcodespacerange
+`)
+	// at most 100 entries per block
+	for len(glyphs) > 0 {
+		n := len(glyphs)
+		if n > 100 {
+			n = 100
+		}
+		fmt.Fprintf(buf, "%d beginbfchar\n", n)
+		for _, g := range glyphs[:n] {
+			fmt.Fprintf(buf, "<%04x> <", g)
+			for _, u := range utf16.Encode([]rune{chars[uint16(g)]}) {
+				fmt.Fprintf(buf, "%04x", u)
+			}
+			buf.WriteString(">\n")
+		}
+		buf.WriteString("endbfchar\n")
+		glyphs = glyphs[n:]
+	}
+	buf.WriteString(`endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end
+`)
+	return buf.Bytes()
+}
+
+// Font descriptor flags
+const (
+	fontFixedPitch  = 1 << 0
+	fontSymbolic    = 1 << 2
+	fontNonsymbolic = 1 << 5
+	fontItalic      = 1 << 6
+)
+
+// EmbedTTF embeds the TrueType font at path for use with DrawText.
+// It is embedded as a composite font, so text may use any character
+// in the font; characters without a glyph are drawn as .notdef.
+func (p *PDFWriter) EmbedTTF(path string) (*Font, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ttf, err := parseTrueType(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	name := ttf.name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, name)
+	if f := p.fonts[name]; f != nil {
+		return f, nil
+	}
+
+	// font file
+	fileID, _ := p.startObj()
+	p.printf("/Length1 %d", len(data))
+	p.writeStream(p.encodeStream(data, flateFilter))
+
+	// font descriptor
+	flags := fontSymbolic
+	if ttf.fixedPitch {
+		flags |= fontFixedPitch
+	}
+	if ttf.italicAngle != 0 {
+		flags |= fontItalic
+	}
+	descID, _ := p.startObj()
+	p.print("/Type /FontDescriptor")
+	p.printf("/FontName /%s", name)
+	p.printf("/Flags %d", flags)
+	p.printf("/FontBBox [%d %d %d %d]", ttf.scale(ttf.bbox[0]), ttf.scale(ttf.bbox[1]),
+		ttf.scale(ttf.bbox[2]), ttf.scale(ttf.bbox[3]))
+	p.printf("/ItalicAngle %g", ttf.italicAngle)
+	p.printf("/Ascent %d", ttf.scale(ttf.ascent))
+	p.printf("/Descent %d", ttf.scale(ttf.descent))
+	p.printf("/CapHeight %d", ttf.scale(ttf.capHeight))
+	p.print("/StemV 80")
+	p.printf("/FontFile2 %d 0 R", fileID)
+	p.endObj()
+
+	// The font dictionary lists widths and a ToUnicode CMap
+	// for the glyphs used, so it is written by Flush.
+	id := p.reserveObj()
+	ttf.descriptor = descID
+	ttf.used = make(map[uint16]rune)
+	f := &Font{BaseFont: name, name: fmt.Sprintf("F%d", len(p.fonts)+1), id: id, ttf: ttf}
+	if p.fonts == nil {
+		p.fonts = make(map[string]*Font)
+	}
+	p.fonts[name] = f
+	return f, p.err
+}
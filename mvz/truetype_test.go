@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// buildTTF returns a minimal TrueType font with unitsPerEm 2048 and
+// empty glyphs for the given runes, with their advance widths.
+// Glyph 0 is .notdef, 1024 units wide.
+func buildTTF(name string, advances map[rune]int) []byte {
+	runes := make([]rune, 0, len(advances))
+	for r := range advances {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	numGlyphs := len(runes) + 1
+
+	be := binary.BigEndian
+	u16 := func(b *bytes.Buffer, vs ...int) {
+		for _, v := range vs {
+			binary.Write(b, be, uint16(v))
+		}
+	}
+	tables := map[string]*bytes.Buffer{}
+	table := func(tag string) *bytes.Buffer {
+		tables[tag] = new(bytes.Buffer)
+		return tables[tag]
+	}
+
+	head := table("head")
+	u16(head, 1, 0, 1, 0, 0, 0) // version, revision, checksum adjustment
+	binary.Write(head, be, uint32(0x5f0f3cf5))
+	u16(head, 0, 2048)                    // flags, unitsPerEm
+	head.Write(make([]byte, 16))          // created, modified
+	u16(head, 0xff9c, 0xfe00, 2000, 1800) // bbox: -100 -512 2000 1800
+	u16(head, 0, 8, 2, 0, 0)              // macStyle, lowestRecPPEM, direction, indexToLoc, glyphData
+
+	hhea := table("hhea")
+	u16(hhea, 1, 0, 1600, 0xfe00, 0, 2048, 0, 0, 2000, 1, 0, 0)
+	hhea.Write(make([]byte, 10))
+	u16(hhea, numGlyphs)
+
+	maxp := table("maxp")
+	u16(maxp, 0, 0x5000, numGlyphs) // version 0.5
+
+	hmtx := table("hmtx")
+	u16(hmtx, 1024, 0)
+	for _, r := range runes {
+		u16(hmtx, advances[r], 0)
+	}
+
+	// format 4 with one segment per rune, plus the final 0xffff segment
+	cmap := table("cmap")
+	segs := len(runes) + 1
+	u16(cmap, 0, 1, 3, 1, 0, 12)
+	u16(cmap, 4, 16+8*segs, 0, 2*segs, 0, 0, 0)
+	for _, r := range runes {
+		u16(cmap, int(r))
+	}
+	u16(cmap, 0xffff, 0)
+	for _, r := range runes {
+		u16(cmap, int(r))
+	}
+	u16(cmap, 0xffff)
+	for i, r := range runes {
+		u16(cmap, (i+1-int(r))&0xffff)
+	}
+	u16(cmap, 1)
+	for i := 0; i < segs; i++ {
+		u16(cmap, 0)
+	}
+
+	post := table("post")
+	u16(post, 3, 0)
+	post.Write(make([]byte, 28))
+
+	loca := table("loca")
+	for i := 0; i <= numGlyphs; i++ {
+		u16(loca, 0)
+	}
+	table("glyf")
+
+	nameTable := table("name")
+	u16(nameTable, 0, 1, 18, 1, 0, 0, 6, len(name), 0)
+	nameTable.WriteString(name)
+
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	var font bytes.Buffer
+	binary.Write(&font, be, uint32(0x00010000))
+	u16(&font, len(tags), 0, 0, 0)
+	off := 12 + 16*len(tags)
+	for _, tag := range tags {
+		n := tables[tag].Len()
+		font.WriteString(tag)
+		binary.Write(&font, be, []uint32{0, uint32(off), uint32(n)})
+		off += (n + 3) &^ 3
+	}
+	for _, tag := range tags {
+		t := tables[tag].Bytes()
+		font.Write(t)
+		font.Write(make([]byte, (4-len(t)%4)%4))
+	}
+	return font.Bytes()
+}
+
+func TestParseTrueType(t *testing.T) {
+	data := buildTTF("TestSans", map[rune]int{' ': 512, 'A': 1366, 'é': 1024})
+	f, err := parseTrueType(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.name != "TestSans" {
+		t.Errorf("got name %q, want TestSans", f.name)
+	}
+	if want := [4]int{-100, -512, 2000, 1800}; f.bbox != want {
+		t.Errorf("got bbox %v, want %v", f.bbox, want)
+	}
+	if f.ascent != 1600 || f.descent != -512 {
+		t.Errorf("got ascent %d, descent %d, want 1600, -512", f.ascent, f.descent)
+	}
+	for r, want := range map[rune]int{' ': 250, 'A': 667, 'é': 500, 'x': 500} {
+		if got := f.width(r); got != want {
+			t.Errorf("width of %q: got %d, want %d", r, got, want)
+		}
+	}
+	if _, err := parseTrueType(data[:40]); err == nil {
+		t.Error("expected error for truncated font")
+	}
+}
+
+func TestEmbedTTF(t *testing.T) {
+	data := buildTTF("TestSans", map[rune]int{' ': 512, 'A': 1366})
+	path := filepath.Join(t.TempDir(), "test.ttf")
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(Letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := p.EmbedTTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.BaseFont != "TestSans" {
+		t.Errorf("got base font %q, want TestSans", f.BaseFont)
+	}
+	if again, err := p.EmbedTTF(path); err != nil || again != f {
+		t.Errorf("embedding twice: got %v, %v, want the same font", again, err)
+	}
+	p.SetFont(f)
+	if err := p.DrawText(page, 72, 72, 10, "A A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := findObject(t, buf.Bytes(), fmt.Sprintf("/Length1 %d", len(data)))
+	z, err := zlib.NewReader(bytes.NewReader(file.stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(embedded, data) {
+		t.Error("embedded font file differs from the original")
+	}
+
+	desc := findObject(t, buf.Bytes(), "/Type /FontDescriptor")
+	for _, want := range []string{
+		"/FontName /TestSans", "/Flags 4", "/FontBBox [-49 -250 977 879]",
+		"/Ascent 781", "/Descent -250", fmt.Sprintf("/FontFile2 %d 0 R", file.id),
+	} {
+		if !bytes.Contains(desc.dict, []byte(want)) {
+			t.Errorf("font descriptor missing %q:\n%s", want, desc.dict)
+		}
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	font := objs[f.id-1]
+	for _, want := range []string{
+		"/Subtype /Type0", "/BaseFont /TestSans", "/Encoding /Identity-H",
+	} {
+		if !bytes.Contains(font.dict, []byte(want)) {
+			t.Errorf("font dictionary missing %q:\n%s", want, font.dict)
+		}
+	}
+	cidFont := findObject(t, buf.Bytes(), "/Subtype /CIDFontType2")
+	for _, want := range []string{
+		"/DW 500", "/W [ 1 [250] 2 [667] ]", "/CIDToGIDMap /Identity",
+		fmt.Sprintf("/FontDescriptor %d 0 R", desc.id),
+	} {
+		if !bytes.Contains(cidFont.dict, []byte(want)) {
+			t.Errorf("CIDFont dictionary missing %q:\n%s", want, cidFont.dict)
+		}
+	}
+	if content := pageContents(t, buf.Bytes(), page); !bytes.Contains(content, []byte("/F1 10.00 Tf\n72.00 72.00 Td\n<000200010002> Tj")) {
+		t.Errorf("text not drawn with embedded font:\n%s", content)
+	}
+}
+
+var (
+	toUnicodeRx = regexp.MustCompile(`/ToUnicode (\d+) 0 R`)
+	bfcharRx    = regexp.MustCompile(`<([0-9a-f]{4})> <([0-9a-f]+)>`)
+)
+
+func TestUnicodeText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ttf")
+	data := buildTTF("TestSans", map[rune]int{'C': 1300, 'a': 1100, 'f': 600, 'é': 1100})
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	p, err := NewPDFWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := p.WriteBlankPage(A4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := p.EmbedTTF(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetFont(f)
+	// the font has no glyph for '€', which is drawn as .notdef
+	if err := p.DrawText(page, 72, 72, 10, "Café €"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// glyphs are numbered in rune order: C a f é
+	content := pageContents(t, buf.Bytes(), page)
+	if want := "<000100020003000400000000> Tj"; !bytes.Contains(content, []byte(want)) {
+		t.Errorf("content stream missing %q:\n%s", want, content)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	m := toUnicodeRx.FindSubmatch(objs[f.id-1].dict)
+	if m == nil {
+		t.Fatalf("font has no ToUnicode CMap:\n%s", objs[f.id-1].dict)
+	}
+	id, _ := strconv.Atoi(string(m[1]))
+	cmap := objs[id-1].stream
+	got := map[string]string{}
+	for _, m := range bfcharRx.FindAllSubmatch(cmap[bytes.Index(cmap, []byte("beginbfchar")):], -1) {
+		got[string(m[1])] = string(m[2])
+	}
+	want := map[string]string{"0001": "0043", "0002": "0061", "0003": "0066", "0004": "00e9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ToUnicode maps %v, want %v:\n%s", got, want, cmap)
+	}
+}
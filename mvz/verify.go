@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+// This file implements a structural check of PDF files.
+
+// An xrefEntry locates an object: at an offset of the file, or at
+// an index of an object stream.
+type xrefEntry struct {
+	offset int   // in the file, for uncompressed objects
+	stream PDFID // object stream, for compressed objects
+	index  int   // in the object stream
+}
+
+// A verifier holds the objects of a file being verified.
+type verifier struct {
+	data    []byte
+	xref    map[PDFID]xrefEntry
+	trailer []byte
+	objects map[PDFID][]byte // objects without their streams
+	streams map[PDFID][]byte // stream data, still encoded
+}
+
+var (
+	verifyStartxref = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	verifyObjHeader = regexp.MustCompile(`^(\d+) (\d+) obj\s`)
+	verifyLength    = regexp.MustCompile(`/Length (\d+)(?: (\d+) R)?`)
+	verifyRef       = regexp.MustCompile(`(\d+) (\d+) R\b`)
+	verifyType      = regexp.MustCompile(`/Type /(\w+)`)
+	verifyStream    = regexp.MustCompile(`>>\s*stream\r?\n`)
+)
+
+// Verify checks the structure of the PDF file of the given size
+// read from r: the cross-reference table and trailer, the offset
+// of every object, that every reference points to an object, and
+// the page tree. It does not check the contents of pages.
+func Verify(r io.ReaderAt, size int64) error {
+	data := make([]byte, size)
+	if n, err := r.ReadAt(data, 0); int64(n) < size {
+		return err
+	}
+	v := &verifier{
+		data:    data,
+		xref:    make(map[PDFID]xrefEntry),
+		objects: make(map[PDFID][]byte),
+		streams: make(map[PDFID][]byte),
+	}
+	return v.verify()
+}
+
+func (v *verifier) verify() error {
+	m := verifyStartxref.FindSubmatch(v.data)
+	if m == nil {
+		return fmt.Errorf("missing startxref")
+	}
+	off, _ := strconv.Atoi(string(m[1]))
+	if off >= len(v.data) {
+		return fmt.Errorf("startxref %d beyond end of file", off)
+	}
+	var err error
+	if bytes.HasPrefix(v.data[off:], []byte("xref")) {
+		err = v.readXRefTable(off)
+	} else {
+		err = v.readXRefStream(off)
+	}
+	if err != nil {
+		return err
+	}
+	// uncompressed objects first: they hold the object streams
+	for id, e := range v.xref {
+		if e.stream == 0 {
+			if err := v.readObject(id, e.offset); err != nil {
+				return err
+			}
+		}
+	}
+	for id, e := range v.xref {
+		if e.stream != 0 {
+			if err := v.readPacked(id, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := v.checkRefs(0, v.trailer); err != nil {
+		return err
+	}
+	for id, obj := range v.objects {
+		if err := v.checkRefs(id, obj); err != nil {
+			return err
+		}
+	}
+	return v.checkPages()
+}
+
+// readXRefTable reads a cross-reference table and the trailer
+// following it.
+func (v *verifier) readXRefTable(off int) error {
+	end := bytes.Index(v.data[off:], []byte("trailer"))
+	if end < 0 {
+		return fmt.Errorf("missing trailer")
+	}
+	trailerEnd := bytes.Index(v.data[off+end:], []byte("startxref"))
+	if trailerEnd < 0 {
+		return fmt.Errorf("missing startxref after trailer")
+	}
+	v.trailer = v.data[off+end : off+end+trailerEnd]
+	lines := bytes.Fields(v.data[off+len("xref") : off+end])
+	for len(lines) > 0 {
+		if len(lines) < 2 {
+			return fmt.Errorf("truncated xref subsection")
+		}
+		first, err1 := strconv.Atoi(string(lines[0]))
+		count, err2 := strconv.Atoi(string(lines[1]))
+		if err1 != nil || err2 != nil || first < 0 || count < 0 || count > (len(lines)-2)/3 {
+			return fmt.Errorf("invalid xref subsection %q %q", lines[0], lines[1])
+		}
+		for i := 0; i < count; i++ {
+			entry := lines[2+3*i : 5+3*i]
+			if string(entry[2]) == "f" {
+				continue
+			}
+			if string(entry[2]) != "n" {
+				return fmt.Errorf("invalid xref entry for object %d", first+i)
+			}
+			off, err := strconv.Atoi(string(entry[0]))
+			if err != nil || off < 0 {
+				return fmt.Errorf("invalid offset for object %d", first+i)
+			}
+			v.xref[PDFID(first+i)] = xrefEntry{offset: off}
+		}
+		lines = lines[2+3*count:]
+	}
+	return nil
+}
+
+// readXRefStream reads a cross-reference stream, whose dictionary
+// holds the trailer.
+func (v *verifier) readXRefStream(off int) error {
+	m := verifyObjHeader.FindSubmatch(v.data[off:])
+	if m == nil {
+		return fmt.Errorf("no xref table or stream at offset %d", off)
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	id := PDFID(n)
+	if err := v.readObject(id, off); err != nil {
+		return err
+	}
+	dict := v.objects[id]
+	if t := verifyType.FindSubmatch(dict); t == nil || string(t[1]) != "XRef" {
+		return fmt.Errorf("object %d at startxref is not a cross-reference stream", id)
+	}
+	data, err := decodeStream(dict, v.streams[id])
+	if err != nil {
+		return fmt.Errorf("xref stream: %v", err)
+	}
+	v.trailer = dict
+	w := regexp.MustCompile(`/W \[\s*(\d+) (\d+) (\d+)\s*\]`).FindSubmatch(dict)
+	if w == nil {
+		return fmt.Errorf("xref stream has no valid /W")
+	}
+	var widths [3]int
+	for i := range widths {
+		widths[i], _ = strconv.Atoi(string(w[i+1]))
+		if widths[i] > 8 {
+			return fmt.Errorf("xref stream has %d-byte fields", widths[i])
+		}
+	}
+	size := widths[0] + widths[1] + widths[2]
+	if size == 0 {
+		return fmt.Errorf("xref stream has empty entries")
+	}
+	first, count := 0, len(data)/size
+	if ix := regexp.MustCompile(`/Index \[\s*(\d+) (\d+)\s*\]`).FindSubmatch(dict); ix != nil {
+		first, _ = strconv.Atoi(string(ix[1]))
+		count, _ = strconv.Atoi(string(ix[2]))
+	}
+	if len(data)%size != 0 || count != len(data)/size {
+		return fmt.Errorf("xref stream has %d bytes, want %d entries of %d", len(data), count, size)
+	}
+	field := func(b []byte) int {
+		n := 0
+		for _, c := range b {
+			n = n<<8 | int(c)
+		}
+		return n
+	}
+	for i := 0; i < count; i++ {
+		e := data[i*size:]
+		typ := 1 // default when the type field is absent
+		if widths[0] > 0 {
+			typ = field(e[:widths[0]])
+		}
+		f2 := field(e[widths[0] : widths[0]+widths[1]])
+		f3 := field(e[widths[0]+widths[1] : size])
+		switch typ {
+		case 0:
+		case 1:
+			v.xref[PDFID(first+i)] = xrefEntry{offset: f2}
+		case 2:
+			v.xref[PDFID(first+i)] = xrefEntry{stream: PDFID(f2), index: f3}
+		default:
+			return fmt.Errorf("invalid xref entry type %d for object %d", typ, first+i)
+		}
+	}
+	return nil
+}
+
+// readObject reads object id at offset off, with its stream.
+func (v *verifier) readObject(id PDFID, off int) error {
+	if off < 0 || off >= len(v.data) {
+		return fmt.Errorf("object %d: invalid offset %d", id, off)
+	}
+	m := verifyObjHeader.FindSubmatch(v.data[off:])
+	if m == nil || string(m[1]) != strconv.Itoa(int(id)) {
+		return fmt.Errorf("object %d: not found at offset %d", id, off)
+	}
+	body := v.data[off+len(m[0]):]
+	end := bytes.Index(body, []byte("endobj"))
+	loc := verifyStream.FindIndex(body)
+	if loc == nil || end >= 0 && end < loc[0] {
+		if end < 0 {
+			return fmt.Errorf("object %d: missing endobj", id)
+		}
+		v.objects[id] = body[:end]
+		return nil
+	}
+	dict := body[:loc[0]+2]
+	v.objects[id] = dict
+	l := verifyLength.FindSubmatch(dict)
+	if l == nil {
+		return fmt.Errorf("object %d: stream has no /Length", id)
+	}
+	length, _ := strconv.Atoi(string(l[1]))
+	if l[2] != nil {
+		var err error
+		if length, err = v.intObject(PDFID(length)); err != nil {
+			return fmt.Errorf("object %d: stream length: %v", id, err)
+		}
+	}
+	data := body[loc[1]:]
+	if length < 0 || length > len(data) {
+		return fmt.Errorf("object %d: invalid stream length %d", id, length)
+	}
+	tail := bytes.TrimLeft(data[length:], "\r\n")
+	if !bytes.HasPrefix(tail, []byte("endstream")) ||
+		!bytes.HasPrefix(bytes.TrimLeft(tail[len("endstream"):], "\r\n "), []byte("endobj")) {
+		return fmt.Errorf("object %d: stream length %d does not match its data", id, length)
+	}
+	v.streams[id] = data[:length]
+	return nil
+}
+
+// intObject returns the value of uncompressed integer object id.
+func (v *verifier) intObject(id PDFID) (int, error) {
+	e, ok := v.xref[id]
+	if !ok || e.stream != 0 || e.offset < 0 || e.offset >= len(v.data) {
+		return 0, fmt.Errorf("object %d not found", id)
+	}
+	m := verifyObjHeader.FindSubmatch(v.data[e.offset:])
+	if m == nil {
+		return 0, fmt.Errorf("object %d not found at offset %d", id, e.offset)
+	}
+	body := v.data[e.offset+len(m[0]):]
+	end := bytes.Index(body, []byte("endobj"))
+	if end < 0 {
+		return 0, fmt.Errorf("object %d: missing endobj", id)
+	}
+	n, err := strconv.Atoi(string(bytes.TrimSpace(body[:end])))
+	if err != nil {
+		return 0, fmt.Errorf("object %d is not an integer", id)
+	}
+	return n, nil
+}
+
+// readPacked reads object id from an object stream.
+func (v *verifier) readPacked(id PDFID, e xrefEntry) error {
+	dict, ok := v.objects[e.stream]
+	if t := verifyType.FindSubmatch(dict); !ok || t == nil || string(t[1]) != "ObjStm" {
+		return fmt.Errorf("object %d: object %d is not an object stream", id, e.stream)
+	}
+	data, err := decodeStream(dict, v.streams[e.stream])
+	if err != nil {
+		return fmt.Errorf("object stream %d: %v", e.stream, err)
+	}
+	n := regexp.MustCompile(`/N (\d+)`).FindSubmatch(dict)
+	first := regexp.MustCompile(`/First (\d+)`).FindSubmatch(dict)
+	if n == nil || first == nil {
+		return fmt.Errorf("object stream %d: missing /N or /First", e.stream)
+	}
+	count, _ := strconv.Atoi(string(n[1]))
+	start, _ := strconv.Atoi(string(first[1]))
+	if start > len(data) {
+		return fmt.Errorf("object stream %d: /First beyond its data", e.stream)
+	}
+	index := bytes.Fields(data[:start])
+	if e.index >= count || len(index) < 2*count {
+		return fmt.Errorf("object %d: index %d beyond object stream %d", id, e.index, e.stream)
+	}
+	num, _ := strconv.Atoi(string(index[2*e.index]))
+	off, _ := strconv.Atoi(string(index[2*e.index+1]))
+	if PDFID(num) != id || off < 0 || start+off > len(data) {
+		return fmt.Errorf("object %d: not found in object stream %d", id, e.stream)
+	}
+	end := len(data)
+	if e.index+1 < count {
+		next, _ := strconv.Atoi(string(index[2*e.index+3]))
+		if next < off || start+next > len(data) {
+			return fmt.Errorf("object %d: invalid offsets in object stream %d", id, e.stream)
+		}
+		end = start + next
+	}
+	v.objects[id] = data[start+off : end]
+	return nil
+}
+
+// decodeStream decodes stream data with the filters of dict.
+func decodeStream(dict, data []byte) ([]byte, error) {
+	filters := regexp.MustCompile(`/Filter (?:\[([^\]]*)\]|(/\w+))`).FindSubmatch(dict)
+	if filters == nil {
+		return data, nil
+	}
+	for _, f := range bytes.Fields(append(filters[1], filters[2]...)) {
+		switch string(f) {
+		case "/ASCII85Decode":
+			if end := bytes.Index(data, []byte("~>")); end >= 0 {
+				data = data[:end]
+			}
+			var err error
+			if data, err = ioutil.ReadAll(ascii85.NewDecoder(bytes.NewReader(data))); err != nil {
+				return nil, err
+			}
+		case "/FlateDecode":
+			z, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			if data, err = ioutil.ReadAll(z); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported filter %s", f)
+		}
+	}
+	return data, nil
+}
+
+// checkRefs checks that the references of object id point to objects.
+func (v *verifier) checkRefs(id PDFID, obj []byte) error {
+	for _, m := range verifyRef.FindAllSubmatch(obj, -1) {
+		n, _ := strconv.Atoi(string(m[1]))
+		if _, ok := v.xref[PDFID(n)]; !ok {
+			if id == 0 {
+				return fmt.Errorf("trailer refers to missing object %d", n)
+			}
+			return fmt.Errorf("object %d refers to missing object %d", id, n)
+		}
+	}
+	return nil
+}
+
+// checkPages checks the catalog and page tree.
+func (v *verifier) checkPages() error {
+	m := regexp.MustCompile(`/Root (\d+) 0 R`).FindSubmatch(v.trailer)
+	if m == nil {
+		return fmt.Errorf("trailer has no /Root")
+	}
+	root, _ := strconv.Atoi(string(m[1]))
+	catalog := v.objects[PDFID(root)]
+	if t := verifyType.FindSubmatch(catalog); t == nil || string(t[1]) != "Catalog" {
+		return fmt.Errorf("root object %d is not a catalog", root)
+	}
+	m = regexp.MustCompile(`/Pages (\d+) 0 R`).FindSubmatch(catalog)
+	if m == nil {
+		return fmt.Errorf("catalog has no /Pages")
+	}
+	pages, _ := strconv.Atoi(string(m[1]))
+	_, err := v.checkPageTree(PDFID(pages), map[PDFID]bool{})
+	return err
+}
+
+// checkPageTree checks the pages under node, and returns their count.
+func (v *verifier) checkPageTree(node PDFID, seen map[PDFID]bool) (int, error) {
+	if seen[node] {
+		return 0, fmt.Errorf("page tree loops at object %d", node)
+	}
+	seen[node] = true
+	obj := v.objects[node]
+	t := verifyType.FindSubmatch(obj)
+	if t != nil && string(t[1]) == "Page" {
+		if !bytes.Contains(obj, []byte("/MediaBox")) {
+			return 0, fmt.Errorf("page %d has no /MediaBox", node)
+		}
+		return 1, nil
+	}
+	if t == nil || string(t[1]) != "Pages" {
+		return 0, fmt.Errorf("object %d in page tree is not a page", node)
+	}
+	kids := regexp.MustCompile(`/Kids \[([^\]]*)\]`).FindSubmatch(obj)
+	if kids == nil {
+		return 0, fmt.Errorf("pages %d has no /Kids", node)
+	}
+	total := 0
+	for _, ref := range verifyRef.FindAllSubmatch(kids[1], -1) {
+		kid, _ := strconv.Atoi(string(ref[1]))
+		n, err := v.checkPageTree(PDFID(kid), seen)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	count := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(obj)
+	if count == nil || string(count[1]) != strconv.Itoa(total) {
+		return 0, fmt.Errorf("pages %d has wrong /Count for %d pages", node, total)
+	}
+	return total, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyDocument writes a small document with the given options.
+func verifyDocument(t *testing.T, setup func(p *PDFWriter)) []byte {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	setup(p)
+	p.WriteInfo("verified", time.Now())
+	for i := 0; i < 3; i++ {
+		page, _ := p.WriteBlankPage(A4)
+		p.DrawText(page, 72, 72, 12, "Page (stream) endobj")
+		p.AddBookmark("page", page)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerify(t *testing.T) {
+	for name, setup := range map[string]func(p *PDFWriter){
+		"plain":          func(p *PDFWriter) {},
+		"compressed":     func(p *PDFWriter) { p.Compress = true },
+		"object streams": func(p *PDFWriter) { p.ObjectStreams = true },
+		"ascii85": func(p *PDFWriter) {
+			p.ObjectStreams = true
+			p.ASCII85 = true
+		},
+		"encrypted": func(p *PDFWriter) { p.SetEncryption("", "owner", AllPermissions, AES256) },
+	} {
+		data := verifyDocument(t, setup)
+		if err := Verify(bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	data := verifyDocument(t, func(p *PDFWriter) {})
+	for name, corrupt := range map[string]func(s string) string{
+		"offset": func(s string) string {
+			// shift the offset of the last object by one
+			entries := regexp.MustCompile(`(?m)^(\d{10}) 00000 n$`).FindAllStringIndex(s, -1)
+			last := entries[len(entries)-1]
+			return s[:last[0]+9] + string(s[last[0]+9]+1) + s[last[0]+10:]
+		},
+		"reference": func(s string) string {
+			// object 0 is always free
+			ref := fmt.Sprintf("/Pages %d 0 R", PAGES_ID)
+			return strings.Replace(s, ref, "/Pages 0 0 R", 1)
+		},
+		"length": func(s string) string {
+			return regexp.MustCompile(`/Length (\d+)`).ReplaceAllString(s, "/Length 1$1")
+		},
+		"truncated": func(s string) string {
+			return s[:len(s)-20]
+		},
+		"negative offset": func(s string) string {
+			entries := regexp.MustCompile(`(?m)^(\d{10}) 00000 n$`).FindAllStringIndex(s, -1)
+			last := entries[len(entries)-1]
+			return s[:last[0]] + "-" + s[last[0]+1:]
+		},
+		"negative count": func(s string) string {
+			return regexp.MustCompile(`xref\n0 (\d+)`).ReplaceAllString(s, "xref\n0 -$1")
+		},
+		"huge count": func(s string) string {
+			return regexp.MustCompile(`xref\n0 (\d+)`).ReplaceAllString(s, "xref\n0 4611686018427387904")
+		},
+		"startxref before trailer": func(s string) string {
+			return strings.Replace(s, "trailer", "startxref\ntrailer", 1)
+		},
+	} {
+		bad := []byte(corrupt(string(data)))
+		if bytes.Equal(bad, data) {
+			t.Fatalf("%s: document not corrupted", name)
+		}
+		if err := Verify(bytes.NewReader(bad), int64(len(bad))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
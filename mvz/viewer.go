@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file implements how viewers open the document.
+
+// A FitMode is how a destination is displayed.
+type FitMode string
+
+const (
+	FitPage  FitMode = "Fit"  // whole page in the window
+	FitWidth FitMode = "FitH" // page width in the window, Top at the top
+	FitXYZ   FitMode = "XYZ"  // Left and Top at the top left, at Zoom
+)
+
+// An OpenAction is the view of the first page the document opens
+// at. Lengths are from the bottom left of the page; Zoom is a
+// factor, 1 for 100%, or zero to keep the current one.
+type OpenAction struct {
+	Fit       FitMode
+	Left, Top Length
+	Zoom      float64
+}
+
+// SetOpenAction sets the view the document opens at, on its first
+// page. The document must then have pages.
+func (p *PDFWriter) SetOpenAction(action OpenAction) error {
+	switch action.Fit {
+	case FitPage, FitWidth, FitXYZ:
+	default:
+		return fmt.Errorf("invalid fit mode %q", action.Fit)
+	}
+	if action.Zoom < 0 {
+		return fmt.Errorf("invalid zoom %g", action.Zoom)
+	}
+	p.openAction = &action
+	return nil
+}
+
+// openActionDest returns the destination of the open action.
+func (p *PDFWriter) openActionDest() string {
+	a := p.openAction
+	switch a.Fit {
+	case FitWidth:
+		return fmt.Sprintf("[%d 0 R /FitH %.2f]", p.pages[0].id, a.Top)
+	case FitXYZ:
+		return fmt.Sprintf("[%d 0 R /XYZ %.2f %.2f %s]", p.pages[0].id, a.Left, a.Top, formatNum(a.Zoom))
+	}
+	return fmt.Sprintf("[%d 0 R /Fit]", p.pages[0].id)
+}
+
+// ViewerPreferences control the window and user interface of
+// viewers displaying the document. The zero value is the viewer
+// defaults.
+type ViewerPreferences struct {
+	HideToolbar     bool
+	HideMenubar     bool
+	HideWindowUI    bool // scroll bars and navigation controls
+	FitWindow       bool // resize the window to the first page
+	CenterWindow    bool
+	DisplayDocTitle bool // the title instead of the file name
+	// FullScreen opens the document in full-screen mode, which
+	// hides all of the above.
+	FullScreen bool
+}
+
+// SetViewerPreferences sets how viewers display the document.
+func (p *PDFWriter) SetViewerPreferences(prefs ViewerPreferences) {
+	p.viewerPrefs = prefs
+}
+
+// viewerPreferences returns the viewer preferences dictionary, or
+// "" if there are none.
+func (p *PDFWriter) viewerPreferences() string {
+	var keys []string
+	for _, k := range []struct {
+		set  bool
+		name string
+	}{
+		{p.viewerPrefs.HideToolbar, "HideToolbar"},
+		{p.viewerPrefs.HideMenubar, "HideMenubar"},
+		{p.viewerPrefs.HideWindowUI, "HideWindowUI"},
+		{p.viewerPrefs.FitWindow, "FitWindow"},
+		{p.viewerPrefs.CenterWindow, "CenterWindow"},
+		{p.viewerPrefs.DisplayDocTitle, "DisplayDocTitle"},
+	} {
+		if k.set {
+			keys = append(keys, "/"+k.name+" true")
+		}
+	}
+	if p.viewerPrefs.FullScreen && p.outlines != 0 {
+		// show the outline on leaving full-screen mode
+		keys = append(keys, "/NonFullScreenPageMode /UseOutlines")
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return "<< " + strings.Join(keys, " ") + " >>"
+}
+
+// pageMode returns the page mode of the catalog, or "" for the
+// default.
+func (p *PDFWriter) pageMode() string {
+	switch {
+	case p.viewerPrefs.FullScreen:
+		return "/FullScreen"
+	case p.outlines != 0:
+		return "/UseOutlines"
+	}
+	return ""
+}
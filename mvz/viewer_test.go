@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestOpenAction(t *testing.T) {
+	for _, tc := range []struct {
+		action OpenAction
+		want   string
+	}{
+		{OpenAction{Fit: FitWidth, Top: A4.Height}, "/OpenAction [%d 0 R /FitH 841.89]"},
+		{OpenAction{Fit: FitPage}, "/OpenAction [%d 0 R /Fit]"},
+		{OpenAction{Fit: FitXYZ, Left: 36, Top: 800, Zoom: 1.5}, "/OpenAction [%d 0 R /XYZ 36.00 800.00 1.5]"},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		first, _ := p.WriteBlankPage(A4)
+		p.WriteBlankPage(A4)
+		if err := p.SetOpenAction(tc.action); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf(tc.want, first)
+		if catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]; !bytes.Contains(catalog.dict, []byte(want)) {
+			t.Errorf("missing %s in %s", want, catalog.dict)
+		}
+	}
+
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	for _, bad := range []OpenAction{{Fit: "FitB"}, {Fit: FitXYZ, Zoom: -1}} {
+		if err := p.SetOpenAction(bad); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+	p.SetOpenAction(OpenAction{Fit: FitPage})
+	err := p.Flush()
+	if err == nil {
+		t.Error("expected error for open action without pages")
+	}
+	if again := p.Close(); again != err {
+		t.Errorf("closing: got %v, want %v", again, err)
+	}
+
+	// validated when Close flushes the document
+	p, _ = NewPDFWriter(new(bytes.Buffer))
+	p.SetOpenAction(OpenAction{Fit: FitPage})
+	if err := p.Close(); err == nil {
+		t.Error("Close: expected error for open action without pages")
+	}
+}
+
+func TestViewerPreferences(t *testing.T) {
+	for _, tc := range []struct {
+		prefs    ViewerPreferences
+		outline  bool
+		want     []string
+		dontWant []string
+	}{
+		{
+			prefs:    ViewerPreferences{HideToolbar: true, FullScreen: true},
+			want:     []string{"/PageMode /FullScreen", "/ViewerPreferences << /HideToolbar true >>"},
+			dontWant: []string{"/HideMenubar", "/FitWindow"},
+		},
+		{
+			prefs:   ViewerPreferences{FitWindow: true, CenterWindow: true, FullScreen: true},
+			outline: true,
+			want: []string{"/PageMode /FullScreen",
+				"/ViewerPreferences << /FitWindow true /CenterWindow true /NonFullScreenPageMode /UseOutlines >>"},
+		},
+		{
+			outline:  true,
+			want:     []string{"/PageMode /UseOutlines"},
+			dontWant: []string{"/ViewerPreferences"},
+		},
+		{
+			dontWant: []string{"/PageMode", "/ViewerPreferences"},
+		},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		page, _ := p.WriteBlankPage(A4)
+		if tc.outline {
+			p.AddBookmark("Start", page)
+		}
+		p.SetViewerPreferences(tc.prefs)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+		for _, want := range tc.want {
+			if !bytes.Contains(catalog.dict, []byte(want)) {
+				t.Errorf("%+v: missing %s in %s", tc.prefs, want, catalog.dict)
+			}
+		}
+		for _, bad := range tc.dontWant {
+			if bytes.Contains(catalog.dict, []byte(bad)) {
+				t.Errorf("%+v: unexpected %s in %s", tc.prefs, bad, catalog.dict)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// This file implements watermarks drawn across all pages.
+
+// SetWatermark sets text to be drawn by Flush diagonally across
+// each page, centered, in the current font scaled to the page, in
+// gray with the given opacity, clamped to [0, 1], as set by an
+// ExtGState. An empty text removes the watermark.
+func (p *PDFWriter) SetWatermark(text string, opacity float64) {
+	if !(opacity > 0) {
+		opacity = 0
+	} else if opacity > 1 {
+		opacity = 1
+	}
+	p.watermark = text
+	p.watermarkOpacity = opacity
+}
+
+// drawWatermark draws the watermark on all pages.
+func (p *PDFWriter) drawWatermark() error {
+	if p.watermark == "" || len(p.pages) == 0 {
+		return nil
+	}
+	f, err := p.currentFont()
+	if err != nil {
+		return err
+	}
+	unit := float64(f.StringWidth(p.watermark, 1))
+	if unit == 0 {
+		return nil
+	}
+	gs, err := p.registerExtGState(ExtGStateOpts{p.watermarkOpacity, p.watermarkOpacity})
+	if err != nil {
+		return err
+	}
+	for _, pg := range p.pages {
+		w, h := float64(pg.width), float64(pg.height)
+		// the text spans most of the diagonal
+		size := 0.8 * math.Hypot(w, h) / unit
+		angle := math.Atan2(h, w)
+		cos, sin := math.Cos(angle), math.Sin(angle)
+		cs := &pg.extra
+		cs.addResource("Font", f.name, f.id)
+		name := cs.resourceName("ExtGState", "GS", gs.id)
+		// centered on the baseline, raised by about half the
+		// height of capitals
+		fmt.Fprintf(&cs.buf, "q\n/%s gs\n0.5 g\nBT\n/%s %.2f Tf\n%.4f %.4f %.4f %.4f %.2f %.2f Tm\n%.2f %.2f Td\n%s Tj\nET\nQ\n",
+			name, f.name, size, cos, sin, -sin, cos, w/2, h/2,
+			-unit*size/2, -0.35*size, f.encode(p.watermark))
+	}
+	return p.err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var gsRx = regexp.MustCompile(`/ExtGState << /(GS\d+) (\d+) 0 R >>`)
+
+func TestWatermark(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.SetWatermark("DRAFT", 0.3)
+	portrait, _ := p.WriteBlankPage(A4)
+	landscape, _ := p.WriteBlankPage(A4.Landscape())
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	objs := readObjects(t, buf.Bytes())
+	for _, page := range []PDFID{portrait, landscape} {
+		m := gsRx.FindSubmatch(objs[page-1].dict)
+		if m == nil {
+			t.Fatalf("page %d resources lack the alpha state: %s", page, objs[page-1].dict)
+		}
+		var id int
+		fmt.Sscan(string(m[2]), &id)
+		if gs := objs[id-1].dict; !bytes.Contains(gs, []byte("/Type /ExtGState\n/ca 0.3 /CA 0.3")) {
+			t.Errorf("wrong graphics state %s", gs)
+		}
+		content := string(pageContents(t, buf.Bytes(), page))
+		for _, op := range []string{"/" + string(m[1]) + " gs\n", " Tm\n", "(DRAFT) Tj\n"} {
+			if !strings.Contains(content, op) {
+				t.Errorf("page %d contents lack %q: %s", page, op, content)
+			}
+		}
+	}
+	// the text follows the diagonal of each page
+	if c := string(pageContents(t, buf.Bytes(), portrait)); !strings.Contains(c, "0.5773 0.8165 -0.8165 0.5773 297.64 420.94 Tm") {
+		t.Errorf("wrong portrait text matrix in %s", c)
+	}
+	if c := string(pageContents(t, buf.Bytes(), landscape)); !strings.Contains(c, "0.8165 0.5773 -0.5773 0.8165 420.94 297.64 Tm") {
+		t.Errorf("wrong landscape text matrix in %s", c)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-1.3")) || !bytes.Contains(buf.Bytes(), []byte("/Version /1.4")) {
+		t.Error("translucent watermark does not require PDF 1.4")
+	}
+}
+
+func TestWatermarkOpacity(t *testing.T) {
+	for _, tc := range []struct {
+		opacity float64
+		want    string
+	}{
+		{-1, "/ca 0 /CA 0"},
+		{0.5, "/ca 0.5 /CA 0.5"},
+		{2, "/ca 1 /CA 1"},
+	} {
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		p.SetWatermark("DRAFT", tc.opacity)
+		p.WriteBlankPage(Letter)
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte(tc.want)) {
+			t.Errorf("opacity %g: missing %s", tc.opacity, tc.want)
+		}
+	}
+}
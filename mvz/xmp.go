@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+// This file implements XMP metadata packets.
+
+// XMPMetadata holds document metadata written as an XMP packet.
+type XMPMetadata struct {
+	Title       string
+	Creators    []string
+	Description string // the Subject of the information dictionary
+	Keywords    string
+	CreateDate  time.Time
+}
+
+// WriteXMP adds an XMP metadata stream to the document catalog.
+// It is written by Flush, and fields left empty are taken from
+// the information dictionary, which is updated accordingly.
+func (p *PDFWriter) WriteXMP(meta XMPMetadata) error {
+	p.xmp = &meta
+	return p.err
+}
+
+// syncXMP fills in the blanks of the info dictionary and
+// the XMP metadata from each other.
+func (p *PDFWriter) syncXMP() {
+	if p.xmp == nil {
+		return
+	}
+	info, meta := &p.info, p.xmp
+	if meta.Title == "" {
+		meta.Title = info.Title
+	}
+	if len(meta.Creators) == 0 && info.Author != "" {
+		meta.Creators = []string{info.Author}
+	}
+	if meta.Description == "" {
+		meta.Description = info.Subject
+	}
+	if meta.Keywords == "" {
+		meta.Keywords = info.Keywords
+	}
+	if meta.CreateDate.IsZero() {
+		meta.CreateDate = info.ModTime
+	}
+	info.Title = meta.Title
+	info.Author = strings.Join(meta.Creators, ", ")
+	info.Subject = meta.Description
+	info.Keywords = meta.Keywords
+	info.ModTime = meta.CreateDate
+}
+
+// writeXMP writes the metadata stream. It is never compressed
+// so that it can be read by tools unaware of PDF.
+func (p *PDFWriter) writeXMP() (PDFID, error) {
+	packet := p.xmpPacket()
+	id, _ := p.startObj()
+	p.print("/Type /Metadata")
+	p.print("/Subtype /XML")
+	p.writeStream(packet)
+	return id, p.err
+}
+
+// xmpPacket returns the XMP packet of the document metadata,
+// declaring PDF/A-1b conformance if required.
+func (p *PDFWriter) xmpPacket() []byte {
+	meta, pdfa := p.xmp, p.PDFA1b
+	buf := new(bytes.Buffer)
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	buf.WriteString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	buf.WriteString(`<rdf:Description rdf:about=""` + "\n")
+	buf.WriteString(` xmlns:dc="http://purl.org/dc/elements/1.1/"` + "\n")
+	buf.WriteString(` xmlns:xmp="http://ns.adobe.com/xap/1.0/"` + "\n")
+	buf.WriteString(` xmlns:pdf="http://ns.adobe.com/pdf/1.3/"`)
+	if pdfa {
+		buf.WriteString("\n" + ` xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/"`)
+	}
+	buf.WriteString(">\n")
+	if pdfa {
+		buf.WriteString("<pdfaid:part>1</pdfaid:part>\n")
+		buf.WriteString("<pdfaid:conformance>B</pdfaid:conformance>\n")
+	}
+	if meta.Title != "" {
+		buf.WriteString(`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">`)
+		xml.EscapeText(buf, []byte(meta.Title))
+		buf.WriteString("</rdf:li></rdf:Alt></dc:title>\n")
+	}
+	if len(meta.Creators) > 0 {
+		buf.WriteString("<dc:creator><rdf:Seq>")
+		for _, c := range meta.Creators {
+			buf.WriteString("<rdf:li>")
+			xml.EscapeText(buf, []byte(c))
+			buf.WriteString("</rdf:li>")
+		}
+		buf.WriteString("</rdf:Seq></dc:creator>\n")
+	}
+	if meta.Description != "" {
+		buf.WriteString(`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">`)
+		xml.EscapeText(buf, []byte(meta.Description))
+		buf.WriteString("</rdf:li></rdf:Alt></dc:description>\n")
+	}
+	if !meta.CreateDate.IsZero() {
+		date := meta.CreateDate.Format(time.RFC3339)
+		buf.WriteString("<xmp:CreateDate>" + date + "</xmp:CreateDate>\n")
+		buf.WriteString("<xmp:ModifyDate>" + date + "</xmp:ModifyDate>\n")
+	}
+	if p.creator != "" {
+		buf.WriteString("<xmp:CreatorTool>")
+		xml.EscapeText(buf, []byte(p.creator))
+		buf.WriteString("</xmp:CreatorTool>\n")
+	}
+	if meta.Keywords != "" {
+		buf.WriteString("<pdf:Keywords>")
+		xml.EscapeText(buf, []byte(meta.Keywords))
+		buf.WriteString("</pdf:Keywords>\n")
+	}
+	if p.producer != "" {
+		buf.WriteString("<pdf:Producer>")
+		xml.EscapeText(buf, []byte(p.producer))
+		buf.WriteString("</pdf:Producer>\n")
+	}
+	buf.WriteString("</rdf:Description>\n")
+	buf.WriteString("</rdf:RDF>\n")
+	buf.WriteString("</x:xmpmeta>\n")
+	buf.WriteString(`<?xpacket end="w"?>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestXMP(t *testing.T) {
+	date := time.Date(2012, 3, 4, 5, 6, 7, 0, time.UTC)
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	p.WriteInfo("Book <1> & more", date)
+	p.WriteXMP(XMPMetadata{Creators: []string{"Alice", "Bob"}})
+	p.WritePage(21*CM, 29.7*CM, nil)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog := readObjects(t, buf.Bytes())[CATALOG_ID-1]
+	if !bytes.Contains(catalog.dict, []byte("/Metadata ")) {
+		t.Errorf("catalog does not reference metadata: %s", catalog.dict)
+	}
+	obj := findObject(t, buf.Bytes(), "/Type /Metadata")
+	packet := obj.stream
+	if !bytes.HasPrefix(packet, []byte("<?xpacket begin=")) ||
+		!bytes.HasSuffix(packet, []byte(`<?xpacket end="w"?>`)) {
+		t.Errorf("missing xpacket header or trailer")
+	}
+
+	var meta struct {
+		Title      string   `xml:"RDF>Description>title>Alt>li"`
+		Creators   []string `xml:"RDF>Description>creator>Seq>li"`
+		CreateDate string   `xml:"RDF>Description>CreateDate"`
+	}
+	if err := xml.Unmarshal(packet, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Book <1> & more" {
+		t.Errorf("wrong title %q", meta.Title)
+	}
+	if len(meta.Creators) != 2 || meta.Creators[0] != "Alice" || meta.Creators[1] != "Bob" {
+		t.Errorf("wrong creators %q", meta.Creators)
+	}
+	if meta.CreateDate != "2012-03-04T05:06:07Z" {
+		t.Errorf("wrong creation date %q", meta.CreateDate)
+	}
+
+	info := readObjects(t, buf.Bytes())[INFO_ID-1]
+	if !bytes.Contains(info.dict, []byte("/Author (Alice, Bob)")) {
+		t.Errorf("info dictionary not in sync with XMP: %s", info.dict)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// This file implements images written once and drawn
+// on any number of pages.
+
+// An ImageRef refers to an image registered with RegisterImage.
+type ImageRef struct {
+	id            PDFID
+	width, height int  // in pixels
+	gray          bool // whether it can be a soft mask
+}
+
+// RegisterImage writes img as an image XObject, to be drawn with
+// DrawImage. If data is not nil, it is the JPEG encoding of img and
+// is embedded as is; otherwise img is compressed losslessly, as by
+// WritePNGPage.
+func (p *PDFWriter) RegisterImage(img image.Image, data []byte) (ImageRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.registerImage(img, data)
+}
+
+func (p *PDFWriter) registerImage(img image.Image, data []byte) (ImageRef, error) {
+	ref := ImageRef{width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+	if data != nil {
+		return p.registerJPEG(ref.width, ref.height, data)
+	}
+	dict, data, mask, err := losslessImage(img)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	if mask != nil {
+		dict.smask, err = p.writeImage(imageDict{
+			width: ref.width, height: ref.height,
+			filter:     "/FlateDecode",
+			colorSpace: "/DeviceGray",
+			mask:       true,
+		}, mask)
+		if err != nil {
+			return ImageRef{}, err
+		}
+	}
+	ref.id, err = p.writeImage(dict, data)
+	ref.gray = dict.colorSpace == "/DeviceGray"
+	return ref, err
+}
+
+func (p *PDFWriter) registerJPEG(w, h int, data []byte) (ImageRef, error) {
+	data, err := p.baselineJPEG(data)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	cs, decode, err := jpegColorSpace(data)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	if p.StripMetadata {
+		data = stripJPEG(data)
+	}
+	id, err := p.writeImage(imageDict{
+		width: w, height: h,
+		filter:     "/DCTDecode",
+		colorSpace: cs,
+		decode:     decode,
+	}, data)
+	return ImageRef{id: id, width: w, height: h, gray: cs == "/DeviceGray"}, err
+}
+
+// SetSoftMask returns ref drawn through mask, a grayscale image of
+// the same size: each pixel of ref is as opaque as the matching
+// pixel of mask is light. Unlike the alpha channel of images, the
+// mask can be any registered image, and used for several images.
+// The returned image is drawn like ref. Soft masks require PDF 1.4.
+func (p *PDFWriter) SetSoftMask(ref, mask ImageRef) (ImageRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ref.id == 0 || mask.id == 0 {
+		return ImageRef{}, fmt.Errorf("image is not registered")
+	}
+	if !mask.gray {
+		return ImageRef{}, fmt.Errorf("soft mask is not a grayscale image")
+	}
+	if mask.width != ref.width || mask.height != ref.height {
+		return ImageRef{}, fmt.Errorf("%dx%d soft mask for %dx%d image",
+			mask.width, mask.height, ref.width, ref.height)
+	}
+	p.requireVersion("1.4")
+	// the luminosity of the mask, drawn in the unit square where
+	// images are drawn, is the opacity of the image
+	group := p.writeUnitForm(mask.id, 0, "/Group << /S /Transparency /CS /DeviceGray >>")
+	gs, _ := p.startObj()
+	p.print("/Type /ExtGState")
+	p.printf("/SMask << /Type /Mask /S /Luminosity /G %d 0 R >>", group)
+	p.endObj()
+	p.softMasked = true
+	id := p.writeUnitForm(ref.id, gs, "")
+	return ImageRef{id: id, width: ref.width, height: ref.height}, p.err
+}
+
+// writeUnitForm writes a form XObject drawing image img in the unit
+// square, in graphics state gs if not zero, with an optional group
+// attributes dictionary entry.
+func (p *PDFWriter) writeUnitForm(img, gs PDFID, group string) PDFID {
+	var cs ContentStream
+	if gs != 0 {
+		fmt.Fprintf(&cs.buf, "/%s gs\n", cs.resourceName("ExtGState", "GS", gs))
+	}
+	cs.drawImage(img, 0, 0, 1, 1)
+	id, _ := p.startObj()
+	p.print("/Type /XObject")
+	p.print("/Subtype /Form")
+	p.print("/BBox [0 0 1 1]")
+	if group != "" {
+		p.print(group)
+	}
+	p.print("/Resources <<")
+	p.writeResources(cs.res)
+	p.print(">>")
+	p.writeStream(p.encodeStream(cs.buf.Bytes(), p.contentFilters()...))
+	return id
+}
+
+// registerEncoded registers an encoded image, as accepted by
+// WriteImagePage.
+func (p *PDFWriter) registerEncoded(data []byte) (ImageRef, error) {
+	switch format := imageFormat(data); format {
+	case "jpeg":
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return ImageRef{}, err
+		}
+		return p.registerJPEG(cfg.Width, cfg.Height, data)
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return ImageRef{}, err
+		}
+		return p.registerImage(img, nil)
+	case "":
+		return ImageRef{}, fmt.Errorf("unknown image format")
+	default:
+		return ImageRef{}, fmt.Errorf("cannot embed %s images", format)
+	}
+}
+
+// WriteImageFitted writes a page of the given size holding an
+// encoded image, as accepted by WriteImagePage, scaled to the
+// largest size keeping its aspect ratio within margin of the page
+// edges, and centered.
+func (p *PDFWriter) WriteImageFitted(data []byte, size PageSize, margin Length) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ref, err := p.registerEncoded(data)
+	if err != nil {
+		return 0, err
+	}
+	x, y, w, h, err := fitImage(ref, size, margin)
+	if err != nil {
+		return 0, err
+	}
+	var cs ContentStream
+	cs.DrawImage(ref, x, y, w, h)
+	return p.writeContentPage(size.Width, size.Height, &cs)
+}
+
+// WriteJPEGPageAutoOrient writes a page holding a JPEG image,
+// scaled to fit and centered like WriteImageFitted without margin,
+// on a page of the given size turned to portrait or landscape to
+// match the image.
+func (p *PDFWriter) WriteJPEGPageAutoOrient(data []byte, size PageSize) (PDFID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	w, h, _, err := jpegDimensions(data)
+	if err != nil {
+		return 0, err
+	}
+	ref, err := p.registerJPEG(w, h, data)
+	if err != nil {
+		return 0, err
+	}
+	if (w > h) != (size.Width > size.Height) && w != h {
+		size = size.Landscape()
+	}
+	x, y, fw, fh, err := fitImage(ref, size, 0)
+	if err != nil {
+		return 0, err
+	}
+	var cs ContentStream
+	cs.DrawImage(ref, x, y, fw, fh)
+	return p.writeContentPage(size.Width, size.Height, &cs)
+}
+
+// fitImage returns the placement of ref scaled to fit within
+// margin of the edges of a page, and centered.
+func fitImage(ref ImageRef, size PageSize, margin Length) (x, y, w, h Length, err error) {
+	if margin < 0 {
+		return 0, 0, 0, 0, fmt.Errorf("invalid margin %.2f", margin)
+	}
+	boxW, boxH := size.Width-2*margin, size.Height-2*margin
+	if boxW <= 0 || boxH <= 0 {
+		return 0, 0, 0, 0, fmt.Errorf("margin %.2f too large for %.2fx%.2f page", margin, size.Width, size.Height)
+	}
+	if ref.width <= 0 || ref.height <= 0 {
+		return 0, 0, 0, 0, fmt.Errorf("empty image")
+	}
+	scale := math.Min(float64(boxW)/float64(ref.width), float64(boxH)/float64(ref.height))
+	w, h = Length(float64(ref.width)*scale), Length(float64(ref.height)*scale)
+	return (size.Width - w) / 2, (size.Height - h) / 2, w, h, nil
+}
+
+// DrawImage draws a registered image on page, into the rectangle
+// with lower left corner (x, y), width w and height h. Images may
+// be drawn over other content, including full-page images.
+func (p *PDFWriter) DrawImage(page PDFID, ref ImageRef, x, y, w, h Length) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	if ref.id == 0 {
+		return fmt.Errorf("image is not registered")
+	}
+	pg.extra.DrawImage(ref, x, y, w, h)
+	return nil
+}
+
+// DrawImageTransform draws a registered image on page, mapping the
+// unit square it occupies to the page with the transformation matrix
+// m = [a b c d e f], as by the cm operator: the point (u, v) of the image
+// goes to (a*u + c*v + e, b*u + d*v + f). FlipH, FlipV and Rotate90
+// return common matrices.
+func (p *PDFWriter) DrawImageTransform(page PDFID, ref ImageRef, m [6]float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pg, err := p.page(page)
+	if err != nil {
+		return err
+	}
+	if ref.id == 0 {
+		return fmt.Errorf("image is not registered")
+	}
+	if m[0]*m[3]-m[1]*m[2] == 0 {
+		return fmt.Errorf("singular image matrix %v", m)
+	}
+	cs := &pg.extra
+	name := cs.resourceName("XObject", "I", ref.id)
+	fmt.Fprintf(&cs.buf, "q\n%.2f %.2f %.2f %.2f %.2f %.2f cm\n/%s Do\nQ\n", m[0], m[1], m[2], m[3], m[4], m[5], name)
+	return nil
+}
+
+// FlipH returns the matrix drawing an image mirrored left to right
+// into the rectangle with lower left corner (x, y), width w and
+// height h.
+func FlipH(x, y, w, h Length) [6]float64 {
+	return [6]float64{-float64(w), 0, 0, float64(h), float64(x + w), float64(y)}
+}
+
+// FlipV returns the matrix drawing an image upside down, mirrored
+// top to bottom, into the rectangle with lower left corner (x, y),
+// width w and height h.
+func FlipV(x, y, w, h Length) [6]float64 {
+	return [6]float64{float64(w), 0, 0, -float64(h), float64(x), float64(y + h)}
+}
+
+// Rotate90 returns the matrix drawing an image turned 90 degrees
+// clockwise into the rectangle with lower left corner (x, y), width
+// w and height h, the top of the image to the right.
+func Rotate90(x, y, w, h Length) [6]float64 {
+	return [6]float64{0, -float64(h), float64(w), 0, float64(x), float64(y + h)}
+}
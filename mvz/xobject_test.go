@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestRegisterImage(t *testing.T) {
+	logo := image.NewGray(image.Rect(0, 0, 40, 20))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, logo, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	ref, err := p.RegisterImage(logo, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []PDFID
+	for i := 0; i < 3; i++ {
+		page, err := p.WriteBlankPage(A4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.DrawImage(page, ref, 72, 72, 80, 40); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	if err := p.DrawImage(pages[0], ImageRef{}, 0, 0, 1, 1); err == nil {
+		t.Error("expected error for unregistered image")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	images := 0
+	objs := readObjects(t, buf.Bytes())
+	for _, obj := range objs {
+		if bytes.Contains(obj.dict, []byte("/Subtype /Image")) {
+			images++
+		}
+	}
+	if images != 1 {
+		t.Errorf("found %d image XObjects, want 1", images)
+	}
+	want := "q\n80.00 0 0 40.00 72.00 72.00 cm\n/I0 Do\nQ\n"
+	res := fmt.Sprintf("/XObject << /I0 %d 0 R >>", ref.id)
+	for _, page := range pages {
+		if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+			t.Errorf("page %d: got content stream %q, want %q", page, got, want)
+		}
+		if pg := objs[page-1]; !bytes.Contains(pg.dict, []byte(res)) {
+			t.Errorf("page %d: missing %s in %s", page, res, pg.dict)
+		}
+	}
+}
+
+func TestDrawImageTransform(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	ref, err := p.RegisterImage(image.NewGray(image.Rect(0, 0, 30, 20)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := p.WriteBlankPage(Letter)
+	for _, m := range [][6]float64{
+		FlipH(72, 360, 216, 144),
+		FlipV(72, 100, 30, 20),
+		Rotate90(300, 100, 20, 30),
+	} {
+		if err := p.DrawImageTransform(page, ref, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.DrawImageTransform(page, ref, [6]float64{1, 2, 2, 4, 0, 0}); err == nil {
+		t.Error("expected error for singular matrix")
+	}
+	if err := p.DrawImageTransform(page, ImageRef{}, FlipH(0, 0, 1, 1)); err == nil {
+		t.Error("expected error for unregistered image")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n-216.00 0.00 0.00 144.00 288.00 360.00 cm\n/I0 Do\nQ\n" +
+		"q\n30.00 0.00 0.00 -20.00 72.00 120.00 cm\n/I0 Do\nQ\n" +
+		"q\n0.00 -30.00 20.00 0.00 300.00 130.00 cm\n/I0 Do\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+}
+
+func TestSoftMask(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	solid := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	draw.Draw(solid, solid.Bounds(), image.NewUniform(color.RGBA{200, 0, 0, 255}), image.ZP, draw.Src)
+	ref, err := p.RegisterImage(solid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gradient := image.NewGray(solid.Bounds())
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			gradient.SetGray(x, y, color.Gray{uint8(16 * x)})
+		}
+	}
+	mask, err := p.RegisterImage(gradient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := p.SetSoftMask(ref, mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetSoftMask(mask, ref); err == nil {
+		t.Error("expected error for color mask")
+	}
+	small, _ := p.RegisterImage(image.NewGray(image.Rect(0, 0, 4, 4)), nil)
+	if _, err := p.SetSoftMask(ref, small); err == nil {
+		t.Error("expected error for mask of another size")
+	}
+	page, _ := p.WriteBlankPage(Letter)
+	if err := p.DrawImage(page, masked, 72, 72, 160, 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := readObjects(t, buf.Bytes())
+	ref1 := func(obj pdfObject, rx string) pdfObject {
+		t.Helper()
+		m := regexp.MustCompile(rx + ` (\d+) 0 R`).FindSubmatch(obj.dict)
+		if m == nil {
+			t.Fatalf("missing %s in %s", rx, obj.dict)
+		}
+		id, _ := strconv.Atoi(string(m[1]))
+		return objs[id-1]
+	}
+	form := objs[masked.id-1]
+	if !bytes.Contains(form.dict, []byte("/Subtype /Form")) {
+		t.Fatalf("masked image is not a form: %s", form.dict)
+	}
+	if want := fmt.Sprintf("/I0 %d 0 R", ref.id); !bytes.Contains(form.dict, []byte(want)) {
+		t.Errorf("missing %s in %s", want, form.dict)
+	}
+	group := ref1(ref1(form, "/GS0"), "/S /Luminosity /G")
+	if want := fmt.Sprintf("/I0 %d 0 R", mask.id); !bytes.Contains(group.dict, []byte(want)) {
+		t.Errorf("soft mask group %s does not draw the mask", group.dict)
+	}
+	if want := "q\n160.00 0 0 80.00 72.00 72.00 cm\n/I0 Do\nQ\n"; string(pageContents(t, buf.Bytes(), page)) != want {
+		t.Errorf("masked image not drawn like an image")
+	}
+}
+
+func TestDrawImagePosition(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var refs []ImageRef
+	for _, c := range []uint8{0, 255} {
+		img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{c}), image.ZP, draw.Src)
+		ref, err := p.RegisterImage(img, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	page, _ := p.WriteBlankPage(Letter)
+	p.DrawImage(page, refs[0], 72, 360, 216, 144)
+	p.DrawImage(page, refs[1], 324, 360, 216, 144)
+	p.DrawImage(page, refs[0], 72, 100, 30, 20)
+
+	// full-page images already use /I0
+	photo := image.NewGray(image.Rect(0, 0, 60, 40))
+	data := new(bytes.Buffer)
+	if err := jpeg.Encode(data, photo, nil); err != nil {
+		t.Fatal(err)
+	}
+	photoPage, err := p.WriteJPEGPage(photo, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.DrawImage(photoPage, refs[1], 10, 10, 20, 20)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "q\n216.00 0 0 144.00 72.00 360.00 cm\n/I0 Do\nQ\n" +
+		"q\n216.00 0 0 144.00 324.00 360.00 cm\n/I1 Do\nQ\n" +
+		"q\n30.00 0 0 20.00 72.00 100.00 cm\n/I0 Do\nQ\n"
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	objs := readObjects(t, buf.Bytes())
+	res := fmt.Sprintf("/XObject << /I0 %d 0 R /I1 %d 0 R >>", refs[0].id, refs[1].id)
+	if pg := objs[page-1]; !bytes.Contains(pg.dict, []byte(res)) {
+		t.Errorf("missing %s in %s", res, pg.dict)
+	}
+	got := pageContents(t, buf.Bytes(), photoPage)
+	if !bytes.HasSuffix(got, []byte("q\n20.00 0 0 20.00 10.00 10.00 cm\n/I1 Do\nQ\n")) {
+		t.Errorf("image not drawn over photo: %q", got)
+	}
+}
+
+func TestRegisterImageLossless(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 128})
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	ref, err := p.RegisterImage(img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	obj := readObjects(t, buf.Bytes())[ref.id-1]
+	if !bytes.Contains(obj.dict, []byte("/Filter [ /FlateDecode ]")) {
+		t.Errorf("image is not compressed losslessly: %s", obj.dict)
+	}
+	if !bytes.Contains(obj.dict, []byte("/SMask ")) {
+		t.Errorf("missing soft mask in %s", obj.dict)
+	}
+}
+
+func TestImageGrid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p, _ := NewPDFWriter(buf)
+	var cs ContentStream
+	var want string
+	var refs []ImageRef
+	for i := 0; i < 4; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{uint8(60 * i)}), image.ZP, draw.Src)
+		ref, err := p.RegisterImage(img, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+		x, y := Length(72+216*(i%2)), Length(72+216*(i/2))
+		cs.DrawImage(ref, x, y, 200, 200)
+		want += fmt.Sprintf("q\n200.00 0 0 200.00 %.2f %.2f cm\n/I%d Do\nQ\n", x, y, i)
+	}
+	page, err := p.WritePage(Letter.Width, Letter.Height, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := pageContents(t, buf.Bytes(), page); string(got) != want {
+		t.Errorf("got content stream %q, want %q", got, want)
+	}
+	res := fmt.Sprintf("/XObject << /I0 %d 0 R /I1 %d 0 R /I2 %d 0 R /I3 %d 0 R >>",
+		refs[0].id, refs[1].id, refs[2].id, refs[3].id)
+	if pg := readObjects(t, buf.Bytes())[page-1]; !bytes.Contains(pg.dict, []byte(res)) {
+		t.Errorf("missing %s in %s", res, pg.dict)
+	}
+}
+
+func TestWriteJPEGPageAutoOrient(t *testing.T) {
+	for _, tc := range []struct {
+		w, h     int
+		size     PageSize
+		mediaBox string
+	}{
+		{400, 300, A4, "/MediaBox [0 0 841.89 595.28]"},
+		{300, 400, A4, "/MediaBox [0 0 595.28 841.89]"},
+		{300, 400, A4.Landscape(), "/MediaBox [0 0 595.28 841.89]"},
+		{400, 400, Letter.Landscape(), "/MediaBox [0 0 792.00 612.00]"},
+	} {
+		img := image.NewGray(image.Rect(0, 0, tc.w, tc.h))
+		data := new(bytes.Buffer)
+		if err := jpeg.Encode(data, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		page, err := p.WriteJPEGPageAutoOrient(data.Bytes(), tc.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if pg := readObjects(t, buf.Bytes())[page-1]; !bytes.Contains(pg.dict, []byte(tc.mediaBox)) {
+			t.Errorf("%dx%d: got page %s, want %s", tc.w, tc.h, pg.dict, tc.mediaBox)
+		}
+	}
+
+	p, _ := NewPDFWriter(ioutil.Discard)
+	if _, err := p.WriteJPEGPageAutoOrient([]byte("not a JPEG"), A4); err == nil {
+		t.Error("expected error for invalid JPEG data")
+	}
+}
+
+func TestWriteImageFitted(t *testing.T) {
+	for _, tc := range []struct {
+		w, h int
+		want string
+	}{
+		// wide image on a tall page: the width fills the margins
+		{400, 200, "q\n540.00 0 0 270.00 36.00 261.00 cm\n/I0 Do\nQ\n"},
+		// tall image: the height fills the margins
+		{100, 400, "q\n180.00 0 0 720.00 216.00 36.00 cm\n/I0 Do\nQ\n"},
+	} {
+		img := image.NewGray(image.Rect(0, 0, tc.w, tc.h))
+		data := new(bytes.Buffer)
+		if err := jpeg.Encode(data, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		p, _ := NewPDFWriter(buf)
+		page, err := p.WriteImageFitted(data.Bytes(), Letter, INCH/2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := pageContents(t, buf.Bytes(), page); string(got) != tc.want {
+			t.Errorf("%dx%d: got content stream %q, want %q", tc.w, tc.h, got, tc.want)
+		}
+		pg := readObjects(t, buf.Bytes())[page-1]
+		if !bytes.Contains(pg.dict, []byte("/MediaBox [0 0 612.00 792.00]")) {
+			t.Errorf("%dx%d: wrong media box in %s", tc.w, tc.h, pg.dict)
+		}
+	}
+
+	p, _ := NewPDFWriter(new(bytes.Buffer))
+	data := new(bytes.Buffer)
+	jpeg.Encode(data, image.NewGray(image.Rect(0, 0, 10, 10)), nil)
+	if _, err := p.WriteImageFitted(data.Bytes(), Letter, 5*INCH); err == nil {
+		t.Error("expected error for margin larger than the page")
+	}
+}